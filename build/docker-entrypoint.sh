@@ -13,5 +13,5 @@ else
 fi
 chmod -R 777 $HOME
 
-# 最新化したビルドスクリプトを叩く
-bash $HOME/build/start.sh
\ No newline at end of file
+# 最新化したビルドスクリプトを叩く（execでPID 1を引き継ぎ、docker stopのSIGTERMがバイナリに届くようにする）
+exec bash $HOME/build/start.sh
\ No newline at end of file
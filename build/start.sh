@@ -1,29 +1,24 @@
 #!/bin/bash
 # ビルド用コンテナと実行用コンテナで共通利用する
+# COMMANDがあれば実行（例 COMMAND=serve-api、COMMAND="run-all archive fill-stations notify"）、なければビルドのみ
 
 HOME=${GOPATH}/src/github.com/bikeshare_api
-NAMES=(${BINARY_NAMES//,/ })
-SIZE=${#NAMES[@]}
+BINARY_NAME=bikeshare
 
-# 全部ビルドする
-FullBuild(){
+# ビルドする
+Build(){
     # 成果物を削除しておく
-    for BINARY_NAME in ${NAMES[@]}; do
-        if [ -e /usr/bikeshare_api/app/bin/${BINARY_NAME} ]; then
-            echo ${BINARY_NAME} exists and removed
-            rm /usr/bikeshare_api/app/bin/${BINARY_NAME}
-        fi
-    done
-    # すべてビルド
-    for BINARY_NAME in ${NAMES[@]}; do
-        echo start build to ${BINARY_NAME}
-        cd ${HOME}/src/${BINARY_NAME}
-        godep restore
-        echo restore end
-        go build -o ${BINARY_NAME}
-        echo build end
-        mv ${BINARY_NAME} ../../app/bin
-    done
+    if [ -e /usr/bikeshare_api/app/bin/${BINARY_NAME} ]; then
+        echo ${BINARY_NAME} exists and removed
+        rm /usr/bikeshare_api/app/bin/${BINARY_NAME}
+    fi
+    echo start build to ${BINARY_NAME}
+    cd ${HOME}/src/${BINARY_NAME}
+    godep restore
+    echo restore end
+    go build -o ${BINARY_NAME}
+    echo build end
+    mv ${BINARY_NAME} ../../app/bin
     # ビルド成果物を作業フォルダにコピー
     chmod -R 777 $HOME
     cp -R -f  ${HOME}/ /usr/
@@ -34,23 +29,23 @@ Execute(){
     # バイナリが見つかるまでループ
     while true
     do
-        if [ -e /usr/bikeshare_api/app/bin/${NAMES[0]} ]; then
+        if [ -e /usr/bikeshare_api/app/bin/${BINARY_NAME} ]; then
             break
         fi
-        echo "looking for ${NAMES[0]}..."
+        echo "looking for ${BINARY_NAME}..."
         sleep 10
     done
 
-    echo "execute ${NAMES[0]}"
+    echo "execute ${BINARY_NAME} ${COMMAND}"
     cd /usr/bikeshare_api/app/bin
-    exec ./${NAMES[0]}
+    exec ./${BINARY_NAME} ${COMMAND}
 }
 
 # メイン処理
-if [ $SIZE = 1 ]; then
+if [ -n "${COMMAND}" ]; then
     # 実行
     Execute
 else
-    # BINARY_NAMESが複数あるときはビルドのみ
-    FullBuild
+    # COMMANDがないときはビルドのみ
+    Build
 fi
\ No newline at end of file
@@ -0,0 +1,351 @@
+package apiserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/middleware"
+	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/8245snake/bikeshare_api/src/lib/shutdown"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：APIキー
+//
+//　利用者ごとにキーを発行し、スコープ・1分あたりのリクエスト数の上限・利用回数を管理する
+//　リクエスト数の制限はRateLimitMiddlewareがキーごとのトークンバケットで行う（apikeyLimit）
+//　キーはX-API-Keyヘッダ、cert（従来のヘッダ）、api_keyパラメータのいずれかで渡す
+//　環境変数API_CERTは全てのスコープを持つマスターキーとして引き続き使える（未設定なら発行したキーのみ）
+//　ローテーションしても猶予期間は前の秘密文字列を受け付けるので、クライアントを1つずつ切り替えられる
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//keyring APIキーのキャッシュと利用状況
+type keyring struct {
+	mu   sync.Mutex
+	keys []rdb.Apikey
+	//usage DBに保存していない利用回数（キーはID）
+	usage    map[string]int64
+	lastUsed map[string]time.Time
+}
+
+const (
+	//apikeyHeader APIキーを渡すヘッダ
+	apikeyHeader = "X-API-Key"
+	//apikeyRefresh 利用回数の保存とキーの再読み込みの間隔（他のプロセスでの変更もこの間隔で反映される）
+	apikeyRefresh = time.Minute
+	//apiCertID API_CERTでのリクエストを数えるときのID（制限しない）
+	apiCertID = "API_CERT"
+)
+
+//Keys APIキーのキャッシュ
+var Keys = &keyring{usage: make(map[string]int64), lastUsed: make(map[string]time.Time)}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  エンドポイント関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//GetApikeys APIキーの一覧を返す
+func GetApikeys(w rest.ResponseWriter, r *rest.Request) {
+	keys, err := Store.SearchApikeys()
+	if err != nil {
+		logger.FromContext(r.Context()).Errorf("GetApikeys SearchApikeysでエラー : %v", err)
+		writeError(w, errInternal("APIキーの検索に失敗しました"))
+		return
+	}
+	jBody := static.JApikeysBody{Items: []static.JApikey{}}
+	for _, key := range keys {
+		jBody.Items = append(jBody.Items, jApikey(key))
+	}
+	jBody.Num = len(jBody.Items)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
+
+//CreateApikey APIキーを発行する（秘密文字列はこのレスポンスでしか返さない）
+func CreateApikey(w rest.ResponseWriter, r *rest.Request) {
+	body := static.JApikeyRequest{}
+	if err := r.DecodeJsonPayload(&body); err != nil {
+		writeError(w, errInvalidParameter("JSONの形式が不正です : %v", err))
+		return
+	}
+	scopes := rdb.ParseScopes(strings.Join(body.Scopes, ","))
+	if strings.TrimSpace(body.Name) == "" || len(scopes) == 0 || len(scopes) != len(body.Scopes) || body.RateLimit < 0 {
+		writeError(w, errInvalidParameter("nameとscopes（%s）を指定する必要があります（rate_limitは0以上）", rdb.JoinScopes(rdb.Scopes)))
+		return
+	}
+	id, err := rdb.NewApikeyID()
+	if err != nil {
+		writeError(w, errInternal("APIキーの作成に失敗しました"))
+		return
+	}
+	secret, hash, err := rdb.NewApikeySecret()
+	if err != nil {
+		writeError(w, errInternal("APIキーの作成に失敗しました"))
+		return
+	}
+	key := rdb.Apikey{ID: id, Name: strings.TrimSpace(body.Name), Hash: hash, Scopes: scopes,
+		RateLimit: body.RateLimit, Created: time.Now()}
+	if err := Store.UpsertApikey(key); err != nil {
+		logger.FromContext(r.Context()).Errorf("CreateApikey UpsertApikeyでエラー : %v", err)
+		writeError(w, errInternal("APIキーの保存に失敗しました"))
+		return
+	}
+	logger.FromContext(r.Context()).Infof("CreateApikey APIキーを発行しました(id=%s, name=%s)", key.ID, key.Name)
+	reloadApikeys()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(static.JApikeySecret{JApikey: jApikey(key), Secret: secret})
+}
+
+//RotateApikey APIキーの秘密文字列を作り直す（前の秘密文字列もgrace分間は使える）
+func RotateApikey(w rest.ResponseWriter, r *rest.Request) {
+	r.ParseForm()
+	grace, err := parseOptionalInt(r.Form.Get("grace"), currentConf().API.ApikeyRotateGrace)
+	if err != nil || grace < 0 {
+		writeError(w, errInvalidParameter("graceには0以上の整数（分）を指定する必要があります"))
+		return
+	}
+	key, err := findApikey(r.PathParam("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	secret, hash, err := rdb.NewApikeySecret()
+	if err != nil {
+		writeError(w, errInternal("APIキーの作成に失敗しました"))
+		return
+	}
+	key.PreviousHash, key.Hash = key.Hash, hash
+	key.PreviousUntil = time.Now().Add(time.Duration(grace) * time.Minute)
+	if grace == 0 {
+		key.PreviousHash, key.PreviousUntil = "", time.Time{}
+	}
+	if err := Store.UpsertApikey(key); err != nil {
+		logger.FromContext(r.Context()).Errorf("RotateApikey UpsertApikeyでエラー : %v", err)
+		writeError(w, errInternal("APIキーの保存に失敗しました"))
+		return
+	}
+	logger.FromContext(r.Context()).Infof("RotateApikey APIキーをローテーションしました(id=%s, grace=%d分)", key.ID, grace)
+	reloadApikeys()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(static.JApikeySecret{JApikey: jApikey(key), Secret: secret})
+}
+
+//RevokeApikey APIキーを無効にする
+func RevokeApikey(w rest.ResponseWriter, r *rest.Request) {
+	key, err := findApikey(r.PathParam("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if key.Active() {
+		key.Revoked = time.Now()
+		if err := Store.UpsertApikey(key); err != nil {
+			logger.FromContext(r.Context()).Errorf("RevokeApikey UpsertApikeyでエラー : %v", err)
+			writeError(w, errInternal("APIキーの保存に失敗しました"))
+			return
+		}
+		logger.FromContext(r.Context()).Infof("RevokeApikey APIキーを無効にしました(id=%s)", key.ID)
+		reloadApikeys()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jApikey(key))
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  その他関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//initApikeys APIキーを読み込む
+func initApikeys() {
+	if currentConf().API.Cert == "" {
+		logger.Info("initApikeys", "API_CERTが未設定のためマスターキーは使えません（発行したAPIキーのみ受け付けます）")
+	}
+	reloadApikeys()
+}
+
+//startApikeyRefresh ctxがキャンセルされるまで利用回数の保存とAPIキーの読み込み直しを定期実行する
+func startApikeyRefresh(ctx context.Context) {
+	go background.Run(func() {
+		for shutdown.Sleep(ctx, apikeyRefresh) {
+			Keys.Flush(Store)
+			reloadApikeys()
+		}
+	})
+}
+
+//reloadApikeys DBからAPIキーを読み込み直す
+func reloadApikeys() error {
+	keys, err := Store.SearchApikeys()
+	if err != nil {
+		logger.Errorf("reloadApikeys SearchApikeysでエラー : %v", err)
+		return err
+	}
+	Keys.Set(keys)
+	return nil
+}
+
+//authorizeRoutes ルートのハンドラをrouteDocsのスコープで認可するハンドラに包む
+func authorizeRoutes(routes []*rest.Route) {
+	for _, route := range routes {
+		route.Func = authorize(routeDocs[routeKey(route)].scope, route.Func)
+	}
+}
+
+//authorize scopeを持つAPIキーのリクエストのみhandlerに渡す（scopeが空なら認証しない）
+func authorize(scope rdb.Scope, handler rest.HandlerFunc) rest.HandlerFunc {
+	if scope == "" {
+		return handler
+	}
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		if err := checkApikey(r, scope); err != nil {
+			writeError(w, err)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+//checkApikey リクエストのAPIキーを確認し利用回数を数える
+func checkApikey(r *rest.Request, scope rdb.Scope) error {
+	setting := currentConf().API
+	secret := apikeyFromRequest(r)
+	if secret == "" {
+		if scope == rdb.ScopeRead && !setting.RequireKey {
+			return nil
+		}
+		return errUnauthorized()
+	}
+	if apiCert := setting.Cert; apiCert != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(apiCert)) == 1 {
+		return nil
+	}
+	now := time.Now()
+	key, ok := Keys.Authenticate(secret, now)
+	if !ok {
+		return errUnauthorized()
+	}
+	if !key.Allows(scope) {
+		return errForbidden("このAPIキーには%sのスコープがありません", scope)
+	}
+	Keys.Use(key, now)
+	return nil
+}
+
+//apikeyLimit RateLimitMiddlewareのKeyFunc（有効なキーならキーごとに数える、API_CERTは制限しない）
+func apikeyLimit(r *rest.Request) (string, middleware.Limit, bool) {
+	secret := apikeyFromRequest(r)
+	if secret == "" {
+		return "", middleware.Limit{}, false
+	}
+	setting := currentConf().API
+	if apiCert := setting.Cert; apiCert != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(apiCert)) == 1 {
+		return apiCertID, middleware.Limit{}, true
+	}
+	key, ok := Keys.Authenticate(secret, time.Now())
+	if !ok {
+		return "", middleware.Limit{}, false
+	}
+	if key.RateLimit > 0 {
+		return key.ID, middleware.Limit{PerMinute: key.RateLimit}, true
+	}
+	return key.ID, middleware.Limit{PerMinute: setting.RateLimitKey, Burst: setting.RateLimitKeyBurst}, true
+}
+
+//apikeyFromRequest リクエストからAPIキーを取り出す
+func apikeyFromRequest(r *rest.Request) string {
+	if key := r.Header.Get(apikeyHeader); key != "" {
+		return key
+	}
+	if key := r.Header.Get("cert"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+//findApikey IDからAPIキーを探す
+func findApikey(id string) (rdb.Apikey, error) {
+	keys, err := Store.SearchApikeys()
+	if err != nil {
+		logger.Errorf("findApikey SearchApikeysでエラー : %v", err)
+		return rdb.Apikey{}, errInternal("APIキーの検索に失敗しました")
+	}
+	for _, key := range keys {
+		if key.ID == id {
+			return key, nil
+		}
+	}
+	return rdb.Apikey{}, errNotFound("APIキーが見つかりません(id=%s)", id)
+}
+
+//jApikey APIキーをJSON構造体に変換する
+func jApikey(key rdb.Apikey) static.JApikey {
+	item := static.JApikey{ID: key.ID, Name: key.Name, RateLimit: key.RateLimit, Usage: key.Usage,
+		Created: key.Created.Format(JsonTimeLayout), Scopes: []string{}}
+	for _, scope := range key.Scopes {
+		item.Scopes = append(item.Scopes, string(scope))
+	}
+	if !key.LastUsed.IsZero() {
+		lastUsed := key.LastUsed.Format(JsonTimeLayout)
+		item.LastUsed = &lastUsed
+	}
+	if key.PreviousHash != "" && !key.PreviousUntil.IsZero() {
+		previousUntil := key.PreviousUntil.Format(JsonTimeLayout)
+		item.PreviousUntil = &previousUntil
+	}
+	if !key.Active() {
+		revoked := key.Revoked.Format(JsonTimeLayout)
+		item.Revoked = &revoked
+	}
+	return item
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Set キーを入れ替える
+func (k *keyring) Set(keys []rdb.Apikey) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = keys
+}
+
+//Authenticate 秘密文字列に一致する有効なキーを探す
+func (k *keyring) Authenticate(secret string, now time.Time) (rdb.Apikey, bool) {
+	hash := rdb.HashApikey(secret)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, key := range k.keys {
+		if key.Active() && key.Matches(hash, now) {
+			return key, true
+		}
+	}
+	return rdb.Apikey{}, false
+}
+
+//Use 利用回数を数える
+func (k *keyring) Use(key rdb.Apikey, now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.usage[key.ID]++
+	k.lastUsed[key.ID] = now
+}
+
+//Flush 利用回数をDBに保存する
+func (k *keyring) Flush(store rdb.Store) {
+	k.mu.Lock()
+	usage, lastUsed := k.usage, k.lastUsed
+	k.usage, k.lastUsed = make(map[string]int64), make(map[string]time.Time)
+	k.mu.Unlock()
+	for id, count := range usage {
+		if err := store.AddApikeyUsage(id, count, lastUsed[id]); err != nil {
+			logger.Errorf("keyring.Flush AddApikeyUsageでエラー(id=%s) : %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+//apikeyRequest secretをX-API-Keyヘッダに付けたリクエスト（空なら付けない）
+func apikeyRequest(secret string) *rest.Request {
+	r := httptest.NewRequest(http.MethodGet, "/private/places", nil)
+	if secret != "" {
+		r.Header.Set(apikeyHeader, secret)
+	}
+	return &rest.Request{Request: r, PathParams: map[string]string{}, Env: map[string]interface{}{}}
+}
+
+//statusOf checkApikeyのエラーのステータス（nilなら200）
+func statusOf(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	if e, ok := err.(*apiError); ok {
+		return e.status
+	}
+	return 0
+}
+
+//TestCheckApikey API_CERTの有無にかかわらず読み取り以外はAPIキーが必要で、API_CERTはマスターキーとして使える
+func TestCheckApikey(t *testing.T) {
+	saved := currentConf()
+	defer func() {
+		confMu.Lock()
+		conf = saved
+		confMu.Unlock()
+		Keys.Set(nil)
+	}()
+	Keys.Set([]rdb.Apikey{{ID: "ingest", Hash: rdb.HashApikey("ingest-secret"), Scopes: []rdb.Scope{rdb.ScopeIngest}}})
+
+	tests := []struct {
+		cert   string
+		secret string
+		scope  rdb.Scope
+		want   int
+	}{
+		{"", "", rdb.ScopeRead, http.StatusOK},
+		{"", "", rdb.ScopeIngest, http.StatusUnauthorized},
+		{"", "", rdb.ScopeAdmin, http.StatusUnauthorized},
+		{"", "wrong", rdb.ScopeAdmin, http.StatusUnauthorized},
+		{"", "ingest-secret", rdb.ScopeIngest, http.StatusOK},
+		{"", "ingest-secret", rdb.ScopeAdmin, http.StatusForbidden},
+		{"master", "", rdb.ScopeAdmin, http.StatusUnauthorized},
+		{"master", "master", rdb.ScopeAdmin, http.StatusOK},
+		{"master", "ingest-secret", rdb.ScopeIngest, http.StatusOK},
+		{"master", "ingest-secret", rdb.ScopeAdmin, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		confMu.Lock()
+		conf.API.Cert = tt.cert
+		conf.API.RequireKey = false
+		confMu.Unlock()
+		if got := statusOf(checkApikey(apikeyRequest(tt.secret), tt.scope)); got != tt.want {
+			t.Errorf("API_CERT=%q キー=%q スコープ=%s : %d（期待値 %d）", tt.cert, tt.secret, tt.scope, got, tt.want)
+		}
+	}
+}
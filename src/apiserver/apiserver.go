@@ -1,4 +1,4 @@
-package main
+package apiserver
 
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 //  概要：REST APIのサーバ
@@ -9,39 +9,87 @@ package main
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
-	"github.com/8245snake/bikeshare_api/src/lib/filer"
+	"github.com/8245snake/bikeshare_api/src/lib/command"
+	"github.com/8245snake/bikeshare_api/src/lib/config"
 	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/metrics"
+	"github.com/8245snake/bikeshare_api/src/lib/middleware"
 	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/8245snake/bikeshare_api/src/lib/shutdown"
 	"github.com/8245snake/bikeshare_api/src/lib/static"
 
 	"github.com/ant0ine/go-json-rest/rest"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 //  変数
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-//Db DB接続オブジェクト
-var Db *sql.DB
+//Config apiserverの設定
+type Config struct {
+	DB         config.DB         `section:"DB"`
+	Log        config.Log        `section:"LOG"`
+	API        config.API        `section:"API"`
+	GBFS       config.GBFS       `section:"GBFS"`
+	GBFSImport config.GBFSImport `section:"GBFS_IMPORT"`
+	GBFSMap    map[string]string `section:"GBFS_MAP" restart:"true"`
+}
+
+var (
+	//confMu confの読み込み直しとの排他
+	confMu sync.RWMutex
+	//conf 設定（読み込み直すことがあるのでリクエストの処理ではcurrentConfで読む）
+	conf Config
+	//startConf 起動時の設定（再起動するまで反映されない設定の変更を調べる）
+	startConf Config
+)
+
+//Store データの保存先
+var Store rdb.Store
+
+//background GBFSの取り込みなど裏で定期実行する処理（終了するときに待つ）
+var background shutdown.Jobs
+
+//Masters 駐輪場情報構造体のキャッシュ
+var Masters = &rdb.MasterCache{}
+
+//Location DBの時刻（タイムゾーンなし）のタイムゾーン
+var Location = time.Local
 
-//MasterSave 駐輪場情報構造体のキャッシュ
-var MasterSave []rdb.Spotmaster
+//メトリクス（kindはcountsまたはplaces）
+var (
+	//ingestRows スクレイパーとGBFSから保存した行数
+	ingestRows = metrics.NewCounter("bikeshare_ingest_rows_total", "スクレイパーとGBFSから保存した行数", "kind")
+	//ingestErrors 保存に失敗した回数
+	ingestErrors = metrics.NewCounter("bikeshare_ingest_errors_total", "スクレイパーとGBFSからの保存に失敗した回数", "kind")
+	//ingestLastSuccess 最後に保存できた日時
+	ingestLastSuccess = metrics.NewGauge("bikeshare_ingest_last_success_timestamp_seconds", "最後に保存できた日時（UNIX時間）", "kind")
+)
 
 const (
 	//JsonTimeLayout 時刻フォーマット
 	JsonTimeLayout = "2006/01/02 15:04"
-	//ini_section セクション
-	ini_section = "API"
+	//MaxCountsRange 期間検索で指定できる最大の期間
+	MaxCountsRange = 31 * 24 * time.Hour
+	//DefaultDistancesLimit 近いスポット検索の既定の件数
+	DefaultDistancesLimit = 10
+	//MaxDistancesLimit 近いスポット検索で返す最大の件数
+	MaxDistancesLimit = 1000
+	//DefaultSuggestLimit スポット名の候補の既定の件数
+	DefaultSuggestLimit = 10
+	//MaxSuggestLimit スポット名の候補で返す最大の件数
+	MaxSuggestLimit = 100
+	//MaxHourlyRollupRange 時間単位の集計で指定できる最大の期間
+	MaxHourlyRollupRange = 31 * 24 * time.Hour
+	//MaxDailyRollupRange 日単位の集計で指定できる最大の期間
+	MaxDailyRollupRange = 366 * 24 * time.Hour
 )
 
 //OrderByType ソート順指定用
@@ -67,28 +115,23 @@ func GetCounts(w rest.ResponseWriter, r *rest.Request) {
 	//レスポンス用
 	var jBody static.JCountsBody
 	var jCounts []static.JCount
-	//パース
+	//検索
 	r.ParseForm()
-	params := r.Form
-	area := params.Get("area")
-	spot := params.Get("spot")
-	day := params.Get("day")
-
-	if area == "" || spot == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteJson("台数検索の際にはareaとspotの両方を指定する必要があります")
-		return
-	}
-
-	rows, err := rdb.SearchCountsByDay(Db, area, spot, day)
+	result, err := searchCounts(r.Form)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteJson(err.Error())
+		writeError(w, err)
 		return
 	}
+	master := result.master
+	jBody.Area = master.Area
+	jBody.Spot = master.Spot
+	jBody.Description = master.Description
+	jBody.Lat = master.Lat
+	jBody.Lon = master.Lon
+	jBody.Name = master.Name
 
 	//JSON構造体に変換
-	for _, s := range rows {
+	for _, s := range result.rows {
 		datetime := s.Time.Format(JsonTimeLayout)
 		y := strconv.Itoa(s.Time.Year())
 		m := strconv.Itoa(int(s.Time.Month()))
@@ -97,22 +140,9 @@ func GetCounts(w rest.ResponseWriter, r *rest.Request) {
 		mi := strconv.Itoa(s.Time.Minute())
 		jCounts = append(jCounts,
 			static.JCount{Count: s.Count, Datetime: datetime, Year: y, Month: m, Day: d,
-				Hour: h, Minute: mi})
+				Hour: h, Minute: mi, Name: result.nameAt(s.Time)})
 	}
 	jBody.Counts = jCounts
-	//マスタ検索
-	if master, err := GetSpotmasterFromCache(area, spot); err == nil {
-		jBody.Area = master.Area
-		jBody.Spot = master.Spot
-		jBody.Description = master.Description
-		jBody.Lat = master.Lat
-		jBody.Lon = master.Lon
-		jBody.Name = master.Name
-	} else {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteJson("マスターの検索に失敗しました")
-		return
-	}
 	//返却
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteJson(jBody)
@@ -122,73 +152,27 @@ func GetCounts(w rest.ResponseWriter, r *rest.Request) {
 func GetPlaces(w rest.ResponseWriter, r *rest.Request) {
 	var jItems []static.JPlaces
 	var jBody static.JPlacesBody
-	//パース
-	r.ParseForm()
-	params := r.Form
-	area := params.Get("area")
-	spot := params.Get("spot")
-	query := params.Get("q")
-	addwhere := ""
-	//自由検索
-	if query != "" {
-		addwhere = fmt.Sprintf(" position( '%s' in trim(area) || '-' || trim(spot) || ',' || name || station ) > 0", query)
-	}
-	//スポット指定（自由検索より優先される）
-	places := params.Get("places")
-	if places != "" {
-		arr := strings.Split(places, ",")
-		if len(arr) > 0 {
-			tmpArr := []string{}
-			for _, str := range arr {
-				tmpArr = append(tmpArr, "'"+str+"'")
-			}
-			addwhere = "(trim(area) || '-' || trim(spot)) in (" + strings.Join(tmpArr, ",") + ")"
-		}
-	}
-	//ソート順
-	sort := params.Get("sort")
-	var orderBy string
-	switch OrderByType(sort) {
-	case OrderByAreaSpotAsc:
-		orderBy = "area,spot"
-	case OrderByAreaSpotDesc:
-		orderBy = "area desc,spot desc"
-	case OrderByCountAsc:
-		orderBy = "to_number(count, '999') asc"
-	case OrderByCountDesc:
-		orderBy = "to_number(count, '999') desc"
-	}
-	//リミット
-	limitStr := params.Get("limit")
-	var limit int
-	if limitStr != "" {
-		if val, err := strconv.Atoi(limitStr); err == nil {
-			limit = val
-		}
-	}
-	//検索条件セット
-	option := rdb.SearchOptions{
-		Area:     area,
-		Spot:     spot,
-		AddWhere: addwhere,
-		OrderBy:  orderBy,
-		Limit:    limit,
-	}
 	//検索
-	arr, err := rdb.SearchCurrentFull(Db, option)
+	r.ParseForm()
+	views, closed, err := searchPlaces(r.Form)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteJson("マスターの検索に失敗しました")
+		writeError(w, err)
 		return
 	}
 	//変換
-	for _, view := range arr {
+	for _, view := range views {
 		recent := static.Recent{Count: view.Count, Datetime: view.Time.Format(JsonTimeLayout)}
 		json := static.JPlaces{Area: view.Area, Spot: view.Spot, Name: view.Name,
 			Lat: view.Lat, Lon: view.Lon, Description: view.Description,
 			Recent: recent}
 		jItems = append(jItems, json)
 	}
+	//終了したスポット（台数がないので有効なスポットの後ろに付ける）
+	for _, master := range closed {
+		jItems = append(jItems, static.JPlaces{Area: master.Area, Spot: master.Spot, Name: master.Name,
+			Lat: master.Lat, Lon: master.Lon, Description: master.Description,
+			Endtime: master.Endtime.Format(JsonTimeLayout)})
+	}
 	//返却
 	jBody.Num = len(jItems)
 	jBody.Items = jItems
@@ -199,73 +183,48 @@ func GetPlaces(w rest.ResponseWriter, r *rest.Request) {
 //GetAllPlaces 全てのスポットマスタを返す公開API
 func GetAllPlaces(w rest.ResponseWriter, r *rest.Request) {
 	var jBody static.JAllPlacesBody
-	//マスタ全検索
-	jBody.Num = len(MasterSave)
-
+	r.ParseForm()
+	masters, err := searchAllPlaces(r.Form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 	//型変換
-	for _, master := range MasterSave {
+	for _, master := range masters {
 		var chiled static.JAllSpotChiled
 		chiled.Area = master.Area
 		chiled.Spot = master.Spot
 		chiled.Name = master.Name
+		if !master.Endtime.IsZero() {
+			chiled.Endtime = master.Endtime.Format(JsonTimeLayout)
+		}
 		jBody.Items = append(jBody.Items, chiled)
 	}
+	jBody.Num = len(jBody.Items)
 	//返却
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteJson(jBody)
 }
 
 //GetDistances 距離を返す公開API
+//lat, lonから近い順に返す（bboxを指定した場合は範囲内のスポットのみ、lat, lonを省略すると範囲の中心から近い順）
 func GetDistances(w rest.ResponseWriter, r *rest.Request) {
 	var jItems []static.JDistances
 	var jBody static.JDistancesBody
-	//パース
+	//検索
 	r.ParseForm()
-	params := r.Form
-	lat := params.Get("lat")
-	lon := params.Get("lon")
-	if lat == "" || lon == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteJson("latとlonの両方を指定する必要があります")
-		return
-	}
-
-	qry := `select 
-	trim(area) as area 
-	, trim(spot) as spot 
-	, trim(name) as name 
-	, trim(count) as count 
-	, time  
-	, lat 
-	, lon 
-	, description
-	, trunc(  
-	  sqrt(  
-		pow(TO_NUMBER(lat, '99.999999') - %s, 2) + pow(TO_NUMBER(lon, '999.999999') - %s, 2) 
-	  ) * 109133 
-	  , 0 
-	) as distance 
-    from current_full order by distance limit 10`
-	qry = fmt.Sprintf(qry, lat, lon)
-	rows, err := Db.Query(qry)
+	distances, err := searchDistances(r.Form)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteJson("DBの検索に失敗しました")
+		writeError(w, err)
 		return
 	}
-	for rows.Next() {
-		var area, spot, name, count, lat, lon, description string
-		var time time.Time
-		var distance int
-		err := rows.Scan(&area, &spot, &name, &count, &time,
-			&lat, &lon, &description, &distance)
-		if err != nil {
-			continue
-		}
-		recent := static.Recent{Count: count, Datetime: time.Format(JsonTimeLayout)}
-		distanceStr := fmt.Sprintf("%d m", distance)
-		jItems = append(jItems, static.JDistances{Area: area, Spot: spot, Name: name,
-			Lat: lat, Lon: lon, Description: description, Distance: distanceStr, Recent: recent})
+	for _, d := range distances {
+		view := d.view
+		recent := static.Recent{Count: view.Count, Datetime: view.Time.Format(JsonTimeLayout)}
+		distanceStr := fmt.Sprintf("%d m", d.distance)
+		jItems = append(jItems, static.JDistances{Area: view.Area, Spot: view.Spot, Name: view.Name,
+			Lat: view.Lat, Lon: view.Lon, Description: view.Description, Distance: distanceStr,
+			Meters: d.distance, Recent: recent})
 	}
 	//返却
 	jBody.Num = len(jItems)
@@ -282,28 +241,34 @@ func CheckStatus(w rest.ResponseWriter, r *rest.Request) {
 	default:
 		allOK = false
 		//DB接続確認
-		err := Db.Ping()
+		err := Store.Ping()
 		if err != nil {
 			status.Connection = static.StatusMessage(err.Error())
 			break
 		} else {
 			status.Connection = static.StatusOK
 		}
-		err = rdb.CheckScrapingStatus(Db)
+		detail, ok, err := checkScraping(time.Now())
 		if err != nil {
 			status.Scraping = static.StatusMessage(err.Error())
 			break
-		} else {
-			status.Scraping = static.StatusOK
 		}
+		status.ScrapingDetail = &detail
+		if !ok {
+			status.Scraping = static.StatusScrapingError
+			break
+		}
+		status.Scraping = static.StatusOK
 		allOK = true
 	}
+	w.Header().Set("Content-Type", "application/json")
 	if allOK {
 		status.Status = static.StatusOK
 	} else {
+		//監視から判定できるよう503を返す（ボディは正常時と同じ形式）
 		status.Status = static.StatusNG
+		w.WriteHeader(http.StatusServiceUnavailable)
 	}
-	w.Header().Set("Content-Type", "application/json")
 	w.WriteJson(status)
 }
 
@@ -311,84 +276,262 @@ func CheckStatus(w rest.ResponseWriter, r *rest.Request) {
 //  その他関数
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-//checkHeader ヘッダ情報をチェックし秘密文字列の照合を行う
-func checkHeader(r *rest.Request) bool {
-	cert := r.Header.Get("cert")
-	apiCert := os.Getenv("API_CERT")
-	return (cert == apiCert)
+//parseDatetimeParam 日時パラメータ(yyyymmdd, yyyymmddhhmm, yyyymmddhhmmss)をパースする
+//日付のみ指定された場合はendOfDay = trueならその日の最終時刻とする
+func parseDatetimeParam(value string, endOfDay bool) (time.Time, error) {
+	for _, layout := range []string{"20060102150405", "200601021504", "20060102"} {
+		if len(value) != len(layout) {
+			continue
+		}
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			return t, errInvalidParameter("日時の形式が不正です(%s)", value)
+		}
+		if layout == "20060102" && endOfDay {
+			t = t.AddDate(0, 0, 1).Add(-1 * time.Second)
+		}
+		return t, nil
+	}
+	return time.Time{}, errInvalidParameter("日時の形式が不正です(%s)", value)
 }
 
-//GetCacheSpotMaster Spotmasterをキャッシュする
-func GetCacheSpotMaster() {
-	//マスタ検索
-	option := rdb.SearchOptions{OrderBy: "area,spot", AddWhere: "endtime is null"}
-	if master, err := rdb.SearchSpotmaster(Db, option); err == nil {
-		MasterSave = master
-		logger.Infof("GetCacheSpotMaster マスタの取得に成功しました(%d件)", len(MasterSave))
-	} else {
-		MasterSave = []rdb.Spotmaster{}
-		logger.Infof("GetCacheSpotMaster マスタの取得に失敗しました")
+//parseRangeParams from, toパラメータを解釈する（toを省略した場合は現在時刻、期間はmaxRange以内）
+func parseRangeParams(from, to string, maxRange time.Duration) (fromTime, toTime time.Time, err error) {
+	if from == "" {
+		return fromTime, toTime, errInvalidParameter("期間検索の際にはfromを指定する必要があります")
+	}
+	if fromTime, err = parseDatetimeParam(from, false); err != nil {
+		return
 	}
+	if to == "" {
+		//DBの時刻に合わせてタイムゾーン情報を落とす
+		now := time.Now()
+		toTime = time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), 0, time.UTC)
+	} else if toTime, err = parseDatetimeParam(to, true); err != nil {
+		return
+	}
+	if toTime.Before(fromTime) {
+		return fromTime, toTime, errInvalidParameter("fromにはtoより前の日時を指定してください")
+	}
+	if toTime.Sub(fromTime) > maxRange {
+		return fromTime, toTime, errInvalidParameter("期間は%d日以内で指定してください", int(maxRange.Hours()/24))
+	}
+	return
 }
 
-//GetSpotmasterFromCache キャッシュしたデータからSpotmasterを探す
-func GetSpotmasterFromCache(area string, spot string) (rdb.Spotmaster, error) {
-	for _, s := range MasterSave {
-		if s.Area == area && s.Spot == spot {
-			return s, nil
+//parseOptionalInt 省略可能な整数パラメータをパースする（省略時はdef）
+func parseOptionalInt(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+//checkScraping 最新の台数の時刻とエリアごとの台数のあるスポット数からスクレイピングの状況を判定する
+//有効なスポットのあるエリアで、最新の台数がSCRAPING_STALE分より古いか
+//直近SCRAPING_WINDOW分に台数のあるスポットがSCRAPING_MIN_RATIO%未満なら問題ありとする
+func checkScraping(now time.Time) (static.JScrapingStatus, bool, error) {
+	setting := currentConf().API
+	staleAfter := time.Duration(setting.ScrapingStale) * time.Minute
+	window := setting.ScrapingWindow
+	minRatio := setting.ScrapingMinRatio
+	detail := static.JScrapingStatus{WindowMinutes: window, StaleAreas: []string{}, Areas: []static.JAreaScrapingStatus{}}
+	//DBの時刻はLocationの時刻で保存されている
+	areas, err := Store.SearchScrapingStatus(now.In(Location).Add(-time.Duration(window) * time.Minute))
+	if err != nil {
+		logger.Errorf("checkScraping SearchScrapingStatusでエラー : %v", err)
+		return detail, false, err
+	}
+	var latest time.Time
+	for _, area := range areas {
+		item := static.JAreaScrapingStatus{Area: area.Area, Status: static.StatusOK, Reporting: area.Reporting, Active: area.Active}
+		if !area.Latest.IsZero() {
+			item.Latest, item.LatestAge = latestFields(dbTime(area.Latest), now)
+			if area.Latest.After(latest) {
+				latest = area.Latest
+			}
+		}
+		switch {
+		case area.Active == 0:
+			//有効なスポットがない（終了した）エリアは判定しない
+		case area.Latest.IsZero() || now.Sub(dbTime(area.Latest)) > staleAfter:
+			item.Status = static.StatusScrapingStale
+		case area.Reporting*100 < area.Active*minRatio:
+			item.Status = static.StatusScrapingFewSpots
+		}
+		if item.Status != static.StatusOK {
+			detail.StaleAreas = append(detail.StaleAreas, area.Area)
 		}
+		detail.Reporting += area.Reporting
+		detail.Active += area.Active
+		detail.Areas = append(detail.Areas, item)
 	}
-	return rdb.Spotmaster{}, fmt.Errorf("area=%s, spot=%s nothing", area, spot)
+	if latest.IsZero() {
+		//台数が1件もない
+		return detail, false, nil
+	}
+	detail.Latest, detail.LatestAge = latestFields(dbTime(latest), now)
+	return detail, len(detail.StaleAreas) == 0 && now.Sub(dbTime(latest)) <= staleAfter, nil
+}
+
+//latestFields 最新の台数の時刻と何秒前か
+func latestFields(latest, now time.Time) (*string, *int) {
+	text := latest.Format(JsonTimeLayout)
+	age := int(now.Sub(latest).Seconds())
+	return &text, &age
 }
 
-func init() {
-	//初期化
-	err := filer.InitDirSetting()
+//initLocation DBの時刻のタイムゾーンを読み込む
+func initLocation() {
+	timezone := conf.API.Timezone
+	location, err := time.LoadLocation(timezone)
 	if err != nil {
+		logger.Warnf("initLocation タイムゾーン%sが読み込めないためローカル時刻を使います : %v", timezone, err)
 		return
 	}
-	logger.Info(filer.GetExeName(), "開始")
-	//DB接続
-	Db, err = rdb.GetConnectionPsql()
+	Location = location
+}
+
+//dbTime DBの時刻（タイムゾーンなし）にLocationのタイムゾーンを付ける
+func dbTime(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, Location)
+}
+
+//GetCacheSpotMaster Spotmasterをキャッシュする（取得できなければ前のキャッシュのまま）
+func GetCacheSpotMaster() error {
+	count, err := Masters.Load(Store)
 	if err != nil {
-		log.Fatal(err)
+		logger.Errorf("GetCacheSpotMaster マスタの取得に失敗しました : %v", err)
+		return err
 	}
-	//起動時にキャッシュ
-	GetCacheSpotMaster()
+	logger.Infof("GetCacheSpotMaster マスタの取得に成功しました(%d件)", count)
+	return nil
 }
 
-func main() {
-	api := rest.NewApi()
-	api.Use(rest.DefaultDevStack...)
-	api.Use(&rest.CorsMiddleware{
-		RejectNonCorsRequests: false,
-		OriginValidator: func(origin string, request *rest.Request) bool {
-			return true
-		},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
-		AllowedHeaders: []string{
-			"Accept", "Content-Type", "X-Custom-Header", "Origin"},
-		AccessControlAllowCredentials: true,
-		AccessControlMaxAge:           3600,
-	})
-	router, err := rest.MakeRouter(
+//GetSpotmasterFromCache キャッシュしたデータからSpotmasterを探す
+func GetSpotmasterFromCache(area string, spot string) (rdb.Spotmaster, error) {
+	if s, ok := Masters.Find(area, spot); ok {
+		return s, nil
+	}
+	return rdb.Spotmaster{}, fmt.Errorf("area=%s, spot=%s nothing", area, spot)
+}
+
+//apiRoutes ルート表（追加したらopenapi.goのrouteDocsにも追加する、スコープもrouteDocsで指定する）
+func apiRoutes() []*rest.Route {
+	return []*rest.Route{
 		rest.Get("/counts", GetCounts),
 		rest.Get("/places", GetPlaces),
+		rest.Get("/places/history", GetPlacesHistory),
+		rest.Get("/places/changes", GetPlacesChanges),
 		rest.Get("/all_places", GetAllPlaces),
 		rest.Get("/distances", GetDistances),
+		rest.Get("/stats/rollup", GetRollup),
+		rest.Get("/forecast", GetForecast),
+		rest.Get("/stream", GetStream),
 		rest.Get("/status", CheckStatus),
+		rest.Get("/openapi.json", GetOpenAPI),
+		rest.Get("/v2/counts", GetCountsV2),
+		rest.Get("/v2/places", GetPlacesV2),
+		rest.Get("/v2/all_places", GetAllPlacesV2),
+		rest.Get("/v2/distances", GetDistancesV2),
+		rest.Get("/v2/places/suggest", GetPlaceSuggestionsV2),
+		rest.Get("/gbfs/gbfs.json", GetGBFS),
+		rest.Get("/gbfs/system_information.json", GetGBFSSystemInformation),
+		rest.Get("/gbfs/station_information.json", GetGBFSStationInformation),
+		rest.Get("/gbfs/station_status.json", GetGBFSStationStatus),
 		rest.Get("/private/config", GetConfig),
 		rest.Get("/private/users", GetUser),
 		rest.Post("/private/counts", SetSpotinfo),
 		rest.Post("/private/places", SetSpotMaster),
 		rest.Post("/private/user", UpdateUser),
-	)
+		rest.Get("/private/keys", GetApikeys),
+		rest.Post("/private/keys", CreateApikey),
+		rest.Post("/private/keys/:id/rotate", RotateApikey),
+		rest.Delete("/private/keys/:id", RevokeApikey),
+		rest.Post("/private/reload", PostReload),
+	}
+}
+
+//Command serve-apiサブコマンド
+var Command = &command.Command{
+	Name:    "serve-api",
+	Exe:     "apiserver",
+	Summary: "REST APIのサーバ（スクレーパーからPOSTされた台数の保存とWEB API）",
+	Daemon:  true,
+	Config:  &conf,
+	Run:     serve,
+}
+
+//serve 初期化してサーバを開始する（ctxがキャンセルされたら処理中のリクエストを待って終了する）
+func serve(ctx context.Context, args []string) error {
+	startConf = conf
+	//DB接続
+	var err error
+	Store, err = rdb.OpenStore()
+	if err != nil {
+		return err
+	}
+	//APIキーの利用回数を保存してから切断する
+	defer Store.Close()
+	initLocation()
+	initApikeys()
+	//起動時にキャッシュ
+	GetCacheSpotMaster()
+	//配信の比較用に最新の台数を入れておく
+	if currents, err := Store.SearchCurrentFull(rdb.SearchOptions{}); err == nil {
+		Hub.Seed(currents)
+	}
+
+	api := rest.NewApi()
+	api.Use(rest.DefaultDevStack...)
+	//エラーのレスポンスにもリクエストIDを付けるため先に入れる
+	api.Use(&middleware.RequestIDMiddleware{})
+	//CORSの403もエラーの形式にするため先に入れる
+	api.Use(&middleware.ErrorMiddleware{})
+	api.Use(middleware.NewCorsMiddleware(conf.API.CorsOrigins, apikeyHeader))
+	//IPアドレスごと（有効なAPIキーがあればキーごと）にリクエスト数を制限する
+	limiter := middleware.NewRateLimitMiddleware(middleware.Limit{PerMinute: conf.API.RateLimitIP, Burst: conf.API.RateLimitIPBurst}, conf.API.TrustProxy)
+	limiter.KeyFunc = apikeyLimit
+	api.Use(limiter)
+	//ルート表からOpenAPIの仕様を作る（ドキュメントのないルートがあれば起動しない）
+	routes := apiRoutes()
+	if openAPISpec, err = buildOpenAPI(routes); err != nil {
+		return err
+	}
+	//routeDocsのスコープでAPIキーを確認する
+	authorizeRoutes(routes)
+	//ルートごとの処理時間を記録する（認証の時間も含める）
+	middleware.InstrumentRoutes(routes)
+	router, err := rest.MakeRouter(routes...)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	//メトリクスは公開用とは別のポートで待ち受ける
+	if err := metrics.Serve(conf.API.MetricsAddr); err != nil {
+		return err
 	}
-	defer Db.Close()
 
-	//サーバ開始
+	//GBFSフィードの取り込み（設定がある場合のみ）
+	startGBFSImport(ctx)
+	//APIキーの利用回数の保存と読み込み直し
+	startApikeyRefresh(ctx)
+
+	//ポートと終了時の待ち時間は再起動が必要な設定なので、読み込み直しが始まる前に決めておく
+	port := conf.API.Port
+	shutdownTimeout := time.Duration(conf.API.ShutdownTimeout) * time.Second
+
+	//SIGHUPとRELOAD_INTERVAL毎に設定とキャッシュを読み込み直す
+	reloader.Watch(ctx, time.Duration(conf.API.ReloadInterval)*time.Minute)
+
+	//サーバ開始（終了するときは処理中のリクエストを待つ）
 	api.SetApp(router)
-	log.Fatal(http.ListenAndServe(":5001", api.MakeHandler()))
+	server := shutdown.NewServer(port, api.MakeHandler())
+	//配信中の/streamは終わらないので先に切断する
+	server.RegisterOnShutdown(Hub.Close)
+	err = shutdown.Serve(ctx, server, shutdownTimeout)
+	//取り込み中のGBFSフィードを待ち、APIキーの利用回数を保存する
+	background.Wait()
+	Keys.Flush(Store)
+	return err
 }
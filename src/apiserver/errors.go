@@ -0,0 +1,73 @@
+package apiserver
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/8245snake/bikeshare_api/src/lib/middleware"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：エラーのレスポンス
+//
+//　エラーは全て {"result":"failed","code":...,"reason":...} の形式で4xx/5xxのステータスとともに返す
+//　ハンドラはapiErrorを作ってwriteErrorに渡す（apiError以外のエラーは500とする）
+//　ルーターの404/405やCORSの403などrest.Errorで書かれたエラーはmiddleware.ErrorMiddlewareで同じ形式に変換する
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//apiError HTTPステータスとエラーコードを持つエラー
+type apiError struct {
+	status int
+	code   static.ErrorCode
+	reason string
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//errInvalidParameter パラメータが不正（400）
+func errInvalidParameter(format string, a ...interface{}) error {
+	return &apiError{status: http.StatusBadRequest, code: static.ErrorInvalidParameter, reason: fmt.Sprintf(format, a...)}
+}
+
+//errUnauthorized 認証に失敗した（401）
+func errUnauthorized() error {
+	return &apiError{status: http.StatusUnauthorized, code: static.ErrorUnauthorized, reason: "認証に失敗しました"}
+}
+
+//errForbidden スコープが足りない（403）
+func errForbidden(format string, a ...interface{}) error {
+	return &apiError{status: http.StatusForbidden, code: static.ErrorForbidden, reason: fmt.Sprintf(format, a...)}
+}
+
+//errNotFound 対象が見つからない（404）
+func errNotFound(format string, a ...interface{}) error {
+	return &apiError{status: http.StatusNotFound, code: static.ErrorNotFound, reason: fmt.Sprintf(format, a...)}
+}
+
+//errInternal サーバ内部のエラー（500）
+func errInternal(format string, a ...interface{}) error {
+	return &apiError{status: http.StatusInternalServerError, code: static.ErrorInternal, reason: fmt.Sprintf(format, a...)}
+}
+
+//writeError エラーをJSONで返す
+func writeError(w rest.ResponseWriter, err error) {
+	e, ok := err.(*apiError)
+	if !ok {
+		e = &apiError{status: http.StatusInternalServerError, code: static.ErrorInternal, reason: err.Error()}
+	}
+	middleware.WriteError(w, e.status, e.code, e.reason)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Error errorインターフェースの実装
+func (e *apiError) Error() string {
+	return e.reason
+}
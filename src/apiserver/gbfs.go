@@ -0,0 +1,165 @@
+package apiserver
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  構造体
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//gbfsSetting GBFSフィードの設定
+type gbfsSetting struct {
+	baseURL string
+	ttl     int
+	system  static.JGBFSSystem
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  エンドポイント関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//GetGBFS gbfs.json（フィード一覧）を返す
+func GetGBFS(w rest.ResponseWriter, r *rest.Request) {
+	base := gbfsBaseURL(r)
+	var feeds []static.JGBFSFeed
+	for _, name := range []static.GBFSFeedName{
+		static.GBFSFeedSystemInformation,
+		static.GBFSFeedStationInformation,
+		static.GBFSFeedStationStatus,
+	} {
+		feeds = append(feeds, static.JGBFSFeed{Name: name, URL: base + string(name) + ".json"})
+	}
+	jBody := static.JGBFSDiscovery{
+		JGBFSHeader: gbfsHeader(time.Now()),
+		Data:        map[string]static.JGBFSFeeds{currentGBFS().system.Language: {Feeds: feeds}},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
+
+//GetGBFSSystemInformation system_information.jsonを返す
+func GetGBFSSystemInformation(w rest.ResponseWriter, r *rest.Request) {
+	jBody := static.JGBFSSystemInformation{JGBFSHeader: gbfsHeader(time.Now()), Data: currentGBFS().system}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
+
+//GetGBFSStationInformation station_information.jsonを返す（有効なスポットマスタ）
+func GetGBFSStationInformation(w rest.ResponseWriter, r *rest.Request) {
+	var jBody static.JGBFSStationInformation
+	jBody.JGBFSHeader = gbfsHeader(time.Now())
+	jBody.Data.Stations = []static.JGBFSStation{}
+	for _, master := range Masters.All() {
+		lat, errLat := strconv.ParseFloat(master.Lat, 64)
+		lon, errLon := strconv.ParseFloat(master.Lon, 64)
+		if errLat != nil || errLon != nil {
+			continue
+		}
+		station := static.JGBFSStation{
+			StationID: gbfsStationID(master.Area, master.Spot),
+			Name:      master.Name,
+			Lat:       lat,
+			Lon:       lon,
+			Address:   master.Description,
+		}
+		if master.Capacity > 0 {
+			capacity := master.Capacity
+			station.Capacity = &capacity
+		}
+		jBody.Data.Stations = append(jBody.Data.Stations, station)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
+
+//GetGBFSStationStatus station_status.jsonを返す（最新の台数）
+func GetGBFSStationStatus(w rest.ResponseWriter, r *rest.Request) {
+	arr, err := Store.SearchCurrentFull(rdb.SearchOptions{}.Sort(rdb.Asc(rdb.ColumnArea), rdb.Asc(rdb.ColumnSpot)))
+	if err != nil {
+		logger.FromContext(r.Context()).Errorf("GetGBFSStationStatus SearchCurrentFullでエラー : %v", err)
+		writeError(w, errInternal("DBの検索に失敗しました"))
+		return
+	}
+	var jBody static.JGBFSStationStatus
+	jBody.JGBFSHeader = gbfsHeader(time.Now())
+	jBody.Data.Stations = []static.JGBFSStatus{}
+	for _, view := range arr {
+		count, err := strconv.Atoi(strings.TrimSpace(view.Count))
+		if err != nil {
+			continue
+		}
+		jBody.Data.Stations = append(jBody.Data.Stations, static.JGBFSStatus{
+			StationID:         gbfsStationID(view.Area, view.Spot),
+			NumBikesAvailable: count,
+			IsInstalled:       true,
+			IsRenting:         true,
+			IsReturning:       true,
+			LastReported:      gbfsUnixTime(view.Time),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  その他関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//currentGBFS 今の設定からGBFSフィードの設定を作る
+func currentGBFS() gbfsSetting {
+	setting := currentConf().GBFS
+	var gbfs gbfsSetting
+	gbfs.baseURL = setting.BaseURL
+	if gbfs.baseURL != "" && !strings.HasSuffix(gbfs.baseURL, "/") {
+		gbfs.baseURL += "/"
+	}
+	gbfs.ttl = setting.TTL
+	gbfs.system = static.JGBFSSystem{
+		SystemID: setting.SystemID,
+		Language: setting.Language,
+		Name:     setting.Name,
+		Operator: setting.Operator,
+		URL:      setting.URL,
+		Timezone: Location.String(),
+	}
+	return gbfs
+}
+
+//gbfsHeader フィード共通のヘッダ
+func gbfsHeader(t time.Time) static.JGBFSHeader {
+	return static.JGBFSHeader{LastUpdated: t.Unix(), TTL: currentGBFS().ttl, Version: static.GBFSVersion}
+}
+
+//gbfsBaseURL フィードのURLの共通部分（設定がなければリクエストから組み立てる）
+func gbfsBaseURL(r *rest.Request) string {
+	if base := currentGBFS().baseURL; base != "" {
+		return base
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + "/gbfs/"
+}
+
+//gbfsStationID スポットのstation_id（area-spot）
+func gbfsStationID(area, spot string) static.GBFSID {
+	return static.GBFSID(strings.TrimSpace(area) + "-" + strings.TrimSpace(spot))
+}
+
+//gbfsUnixTime DBの時刻（タイムゾーンなし）をUNIX時刻に変換する
+func gbfsUnixTime(t time.Time) int64 {
+	return dbTime(t).Unix()
+}
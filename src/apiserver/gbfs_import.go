@@ -0,0 +1,208 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/8245snake/bikeshare_api/src/lib/shutdown"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：GBFSフィードの取り込み（スクレイパーのPOSTの代わりに使える）
+//
+//　station_informationはimportSpotmaster、station_statusはsaveSpotinfoを通して保存する
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//GBFSImporter GBFSフィードの取り込み
+type GBFSImporter struct {
+	//DiscoveryURL gbfs.jsonのURL
+	DiscoveryURL string
+	//Language gbfs.jsonのどの言語のフィードを使うか（見つからなければ最初の言語）
+	Language string
+	//Mapping station_id→area-spot
+	Mapping map[string]string
+	//DefaultArea Mappingにないstation_idに使うエリアコード（空なら取り込まない）
+	DefaultArea string
+	//Location last_reportedを変換するタイムゾーン
+	Location *time.Location
+	//Client HTTPクライアント
+	Client *http.Client
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//NewGBFSImporterFromIni app.iniの設定からGBFSImporterを作成（URLが未設定ならnil）
+func NewGBFSImporterFromIni() *GBFSImporter {
+	url := conf.GBFSImport.URL
+	if url == "" {
+		return nil
+	}
+	mapping := make(map[string]string)
+	for stationID, code := range conf.GBFSMap {
+		mapping[stationID] = strings.TrimSpace(code)
+	}
+	return &GBFSImporter{
+		DiscoveryURL: url,
+		Language:     conf.GBFSImport.Language,
+		Mapping:      mapping,
+		DefaultArea:  conf.GBFSImport.DefaultArea,
+		Location:     Location,
+		Client:       &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+//startGBFSImport 設定があればctxがキャンセルされるまでGBFSの取り込みを定期実行する
+func startGBFSImport(ctx context.Context) {
+	importer := NewGBFSImporterFromIni()
+	if importer == nil {
+		return
+	}
+	interval := time.Duration(conf.GBFSImport.Interval) * time.Second
+	masterInterval := time.Duration(conf.GBFSImport.MasterInterval) * time.Minute
+	logger.Infof("startGBFSImport %sを%v間隔で取り込みます", importer.DiscoveryURL, interval)
+	go background.Run(func() {
+		var lastMaster time.Time
+		for {
+			//マスタを先に取り込まないと台数の表示に使えないため、初回は必ずマスタから
+			if time.Since(lastMaster) >= masterInterval {
+				if masters, err := importer.FetchSpotmaster(ctx); err != nil {
+					logger.Warnf("GBFSImport station_informationの取り込みに失敗しました : %v", err)
+				} else if err := importSpotmaster(masters); err != nil {
+					logger.Errorf("GBFSImport station_informationを保存できません : %v", err)
+				} else {
+					lastMaster = time.Now()
+				}
+			}
+			if rows, err := importer.FetchSpotinfo(ctx); err != nil {
+				logger.Warnf("GBFSImport station_statusの取り込みに失敗しました : %v", err)
+			} else if err := saveSpotinfo(rows); err != nil {
+				logger.Errorf("GBFSImport station_statusを保存できません : %v", err)
+			}
+			if !shutdown.Sleep(ctx, interval) {
+				return
+			}
+		}
+	})
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//FetchSpotmaster station_informationを取得しマスタに変換する（ctxがキャンセルされたら取得を中断する）
+func (g *GBFSImporter) FetchSpotmaster(ctx context.Context) ([]rdb.Spotmaster, error) {
+	var feed static.JGBFSStationInformation
+	if err := g.fetchFeed(ctx, static.GBFSFeedStationInformation, &feed); err != nil {
+		return nil, err
+	}
+	var rows []rdb.Spotmaster
+	for _, station := range feed.Data.Stations {
+		area, spot, ok := g.code(station.StationID)
+		if !ok {
+			continue
+		}
+		master := rdb.Spotmaster{Area: area, Spot: spot,
+			Name: strings.TrimSpace(station.Name),
+			Lat:  strconv.FormatFloat(station.Lat, 'f', -1, 64),
+			Lon:  strconv.FormatFloat(station.Lon, 'f', -1, 64)}
+		if station.Capacity != nil {
+			master.Capacity = *station.Capacity
+		}
+		rows = append(rows, master)
+	}
+	return rows, nil
+}
+
+//FetchSpotinfo station_statusを取得し台数に変換する（設置されていないステーションは除く、ctxがキャンセルされたら取得を中断する）
+func (g *GBFSImporter) FetchSpotinfo(ctx context.Context) ([]rdb.Spotinfo, error) {
+	var feed static.JGBFSStationStatus
+	if err := g.fetchFeed(ctx, static.GBFSFeedStationStatus, &feed); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var rows []rdb.Spotinfo
+	for _, status := range feed.Data.Stations {
+		area, spot, ok := g.code(status.StationID)
+		if !ok || !bool(status.IsInstalled) {
+			continue
+		}
+		reported := now
+		if status.LastReported > 0 {
+			reported = time.Unix(status.LastReported, 0)
+		}
+		//DBにはタイムゾーンなしの時刻で保存する
+		reported = reported.In(g.Location)
+		reported = time.Date(reported.Year(), reported.Month(), reported.Day(),
+			reported.Hour(), reported.Minute(), reported.Second(), 0, time.UTC)
+		rows = append(rows, rdb.Spotinfo{Area: area, Spot: spot, Time: reported,
+			Count: strconv.Itoa(status.NumBikesAvailable)})
+	}
+	return rows, nil
+}
+
+//code station_idをエリアコードとスポットコードに変換する
+//Mapping → "area-spot"形式 → DefaultArea の順に試す
+func (g *GBFSImporter) code(stationID static.GBFSID) (area, spot string, ok bool) {
+	id := strings.TrimSpace(string(stationID))
+	if mapped, found := g.Mapping[id]; found {
+		id = mapped
+	} else if !strings.Contains(id, "-") {
+		if g.DefaultArea == "" {
+			return "", "", false
+		}
+		id = g.DefaultArea + "-" + id
+	}
+	arr := strings.SplitN(id, "-", 2)
+	if len(arr) != 2 || arr[0] == "" || arr[1] == "" || len(arr[0]) > 3 || len(arr[1]) > 3 {
+		return "", "", false
+	}
+	return arr[0], arr[1], true
+}
+
+//fetchFeed gbfs.jsonからフィードのURLを探して取得する
+func (g *GBFSImporter) fetchFeed(ctx context.Context, name static.GBFSFeedName, v interface{}) error {
+	var discovery static.JGBFSDiscovery
+	if err := g.getJSON(ctx, g.DiscoveryURL, &discovery); err != nil {
+		return err
+	}
+	feeds, ok := discovery.Data[g.Language]
+	if !ok {
+		for _, f := range discovery.Data {
+			feeds = f
+			break
+		}
+	}
+	for _, feed := range feeds.Feeds {
+		if feed.Name == name {
+			return g.getJSON(ctx, feed.URL, v)
+		}
+	}
+	return fmt.Errorf("fetchFeed %sが%sに見つかりません", name, g.DiscoveryURL)
+}
+
+//getJSON URLからJSONを取得してデコードする
+func (g *GBFSImporter) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("getJSON %s status=%d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,185 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+)
+
+//jst テスト用のタイムゾーン（tzdataに頼らない）
+var jst = time.FixedZone("JST", 9*60*60)
+
+//newFixtureFeed testdata/gbfsを配信するGBFSフィード（failに含まれるフィードは500を返す）
+func newFixtureFeed(t *testing.T, fail ...string) *httptest.Server {
+	files := http.FileServer(http.Dir("testdata/gbfs"))
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range fail {
+			if r.URL.Path == "/"+name+".json" {
+				http.Error(w, "error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if r.URL.Path == "/gbfs.json" {
+			fmt.Fprintf(w, `{"last_updated":1700000000,"ttl":60,"data":{"ja":{"feeds":[
+				{"name":"station_information","url":"%[1]s/station_information.json"},
+				{"name":"station_status","url":"%[1]s/station_status.json"}]}}}`, server.URL)
+			return
+		}
+		files.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+//newFixtureImporter フィードを取り込むGBFSImporter
+func newFixtureImporter(server *httptest.Server) *GBFSImporter {
+	return &GBFSImporter{
+		DiscoveryURL: server.URL + "/gbfs.json",
+		Language:     "ja",
+		Mapping:      map[string]string{"101": "A1-001"},
+		DefaultArea:  "A3",
+		Location:     jst,
+		Client:       server.Client(),
+	}
+}
+
+//TestFetchSpotmaster station_idの変換（対応表、area-spot形式、DefaultArea、変換できないもの）
+func TestFetchSpotmaster(t *testing.T) {
+	masters, err := newFixtureImporter(newFixtureFeed(t)).FetchSpotmaster(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rdb.Spotmaster{
+		{Area: "A1", Spot: "001", Name: "東京駅八重洲口", Lat: "35.6812", Lon: "139.7671", Capacity: 20},
+		{Area: "A2", Spot: "002", Name: "有楽町", Lat: "35.675", Lon: "139.763"},
+		{Area: "A3", Spot: "303", Name: "番号のみ", Lat: "35.69", Lon: "139.7", Capacity: 8},
+	}
+	if len(masters) != len(want) {
+		t.Fatalf("件数 %d（期待値 %d） : %+v", len(masters), len(want), masters)
+	}
+	for i, m := range masters {
+		w := want[i]
+		if m.Area != w.Area || m.Spot != w.Spot || m.Name != w.Name || m.Lat != w.Lat || m.Lon != w.Lon || m.Capacity != w.Capacity {
+			t.Errorf("%d件目 %+v（期待値 %+v）", i, m, w)
+		}
+	}
+}
+
+//TestFetchSpotmasterWithoutDefaultArea DefaultAreaがなければ対応表にない数字だけのstation_idは取り込まない
+func TestFetchSpotmasterWithoutDefaultArea(t *testing.T) {
+	importer := newFixtureImporter(newFixtureFeed(t))
+	importer.DefaultArea = ""
+	masters, err := importer.FetchSpotmaster(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range masters {
+		if m.Spot == "303" {
+			t.Errorf("取り込まれています : %+v", m)
+		}
+	}
+	if len(masters) != 2 {
+		t.Errorf("件数 %d（期待値 2）", len(masters))
+	}
+}
+
+//TestFetchSpotinfo station_statusにないステーションと設置されていないステーションは台数にならない
+func TestFetchSpotinfo(t *testing.T) {
+	before := time.Now()
+	rows, err := newFixtureImporter(newFixtureFeed(t)).FetchSpotinfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("件数 %d（期待値 2） : %+v", len(rows), rows)
+	}
+	//last_reportedはLocationの時刻をタイムゾーンなしで保存する
+	if r := rows[0]; r.Area != "A1" || r.Spot != "001" || r.Count != "5" ||
+		!r.Time.Equal(time.Date(2023, 11, 15, 7, 13, 20, 0, time.UTC)) {
+		t.Errorf("1件目 %+v", r)
+	}
+	//last_reportedがなければ取得した時刻
+	r := rows[1]
+	if r.Area != "A3" || r.Spot != "303" || r.Count != "0" {
+		t.Errorf("2件目 %+v", r)
+	}
+	now := before.In(jst)
+	if wall := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), 0, time.UTC); r.Time.Before(wall) {
+		t.Errorf("2件目の時刻 %v が取得した時刻 %v より前です", r.Time, wall)
+	}
+}
+
+//TestFetchHTTPError フィードが取得できなければエラー
+func TestFetchHTTPError(t *testing.T) {
+	for _, name := range []string{"gbfs", "station_status"} {
+		if _, err := newFixtureImporter(newFixtureFeed(t, name)).FetchSpotinfo(context.Background()); err == nil || !strings.Contains(err.Error(), "status=500") {
+			t.Errorf("%sが500のときのエラー : %v", name, err)
+		}
+	}
+	//station_informationが500でもstation_statusは取り込める
+	if _, err := newFixtureImporter(newFixtureFeed(t, "station_information")).FetchSpotinfo(context.Background()); err != nil {
+		t.Errorf("station_statusが取り込めません : %v", err)
+	}
+	//gbfs.jsonにないフィード
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"ja":{"feeds":[]}}}`)
+	}))
+	defer server.Close()
+	if _, err := newFixtureImporter(server).FetchSpotmaster(context.Background()); err == nil {
+		t.Error("フィードがないのにエラーになりません")
+	}
+}
+
+//TestFetchCanceled ctxがキャンセルされたら応答を待たずにエラー
+func TestFetchCanceled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := newFixtureImporter(server).FetchSpotinfo(ctx); err == nil {
+		t.Fatal("キャンセルしたのにエラーになりません")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("キャンセルしてから%v待ちました", elapsed)
+	}
+}
+
+//TestImportSpotmasterKeepsScraperSpots GBFSの取り込みは送られてこなかったスポットの回数を数えも数え直しもしない
+func TestImportSpotmasterKeepsScraperSpots(t *testing.T) {
+	useMasterStore(t, 3)
+	importer := newFixtureImporter(newFixtureFeed(t))
+	saveMasters(t, masterRows("A1-001", "A1-005", "A2-002", "A2-009"), false)
+	//A1-005とA2-002がスクレイパーのPOSTに2回続けて含まれていない
+	saveMasters(t, masterRows("A1-001", "A2-009"), false, "A1", "A2")
+	saveMasters(t, masterRows("A1-001", "A2-009"), false, "A1", "A2")
+	for i := 0; i < 5; i++ {
+		masters, err := importer.FetchSpotmaster(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := importSpotmaster(masters); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkActive(t, "GBFSの取り込みを5回", map[string]bool{"A1-001": true, "A1-005": true, "A2-002": true, "A2-009": true, "A3-303": true})
+	//GBFSに含まれるA2-002も数え直さない
+	for _, key := range []string{"A1-005", "A2-002"} {
+		if n := spotMissing[key]; n != 2 {
+			t.Errorf("%sの回数 %d（期待値 2）", key, n)
+		}
+	}
+	saveMasters(t, masterRows("A1-001", "A2-009"), false, "A1", "A2")
+	checkActive(t, "スクレイパーのPOSTを3回", map[string]bool{"A1-005": false, "A2-002": false, "A3-303": true})
+}
@@ -0,0 +1,70 @@
+package apiserver
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：緯度経度の計算（/distancesで使う）
+//
+//　lat, lonはDBに文字列で入っているので、PostgresとSQLiteで同じ結果になるようGo側で計算する
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//earthRadius 地球の平均半径（メートル）
+const earthRadius = 6371008.8
+
+//boundingBox 地図の表示範囲
+type boundingBox struct {
+	minLon, minLat, maxLon, maxLat float64
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//haversine 2点間の大円距離（メートル）
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+//parseBoundingBox bboxパラメータ（minLon,minLat,maxLon,maxLat）をパースする
+func parseBoundingBox(value string) (boundingBox, error) {
+	arr := strings.Split(value, ",")
+	if len(arr) != 4 {
+		return boundingBox{}, errInvalidParameter("bboxはminLon,minLat,maxLon,maxLatの形式で指定してください")
+	}
+	var values [4]float64
+	for i, s := range arr {
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return boundingBox{}, errInvalidParameter("bboxには数値を指定する必要があります")
+		}
+		values[i] = v
+	}
+	box := boundingBox{minLon: values[0], minLat: values[1], maxLon: values[2], maxLat: values[3]}
+	if box.minLon > box.maxLon || box.minLat > box.maxLat ||
+		box.minLat < -90 || box.maxLat > 90 || box.minLon < -180 || box.maxLon > 180 {
+		return boundingBox{}, errInvalidParameter("bboxの範囲が不正です")
+	}
+	return box, nil
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//contains 範囲内か
+func (b boundingBox) contains(lat, lon float64) bool {
+	return b.minLat <= lat && lat <= b.maxLat && b.minLon <= lon && lon <= b.maxLon
+}
+
+//center 中心の緯度経度
+func (b boundingBox) center() (lat, lon float64) {
+	return (b.minLat + b.maxLat) / 2, (b.minLon + b.maxLon) / 2
+}
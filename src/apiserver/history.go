@@ -0,0 +1,218 @@
+package apiserver
+
+import (
+	"sort"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：スポットマスタの変更履歴
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	//ChangeOpened 登録された（終了した後の再登録を含む）
+	ChangeOpened = "opened"
+	//ChangeRenamed 名前が変わった
+	ChangeRenamed = "renamed"
+	//ChangeMoved 位置が変わった
+	ChangeMoved = "moved"
+	//ChangeClosed 終了した
+	ChangeClosed = "closed"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  エンドポイント関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//GetPlacesHistory スポットマスタの全ての版を有効期間とともに返す公開API
+func GetPlacesHistory(w rest.ResponseWriter, r *rest.Request) {
+	//パース
+	r.ParseForm()
+	params := r.Form
+	area := params.Get("area")
+	spot := params.Get("spot")
+	if area == "" || spot == "" {
+		writeError(w, errInvalidParameter("履歴の検索の際にはareaとspotの両方を指定する必要があります"))
+		return
+	}
+	history, err := rdb.SearchSpotmasterHistory(Store, area, spot)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorf("GetPlacesHistory SearchSpotmasterHistoryでエラー : %v", err)
+		writeError(w, errInternal("マスターの検索に失敗しました"))
+		return
+	}
+	if len(history) == 0 {
+		writeError(w, errNotFound("スポットが見つかりません(area=%s, spot=%s)", area, spot))
+		return
+	}
+
+	//JSON構造体に変換
+	jBody := static.JPlacesHistoryBody{Area: history[0].Area, Spot: history[0].Spot}
+	for i, master := range history {
+		item := static.JPlaceVersion{
+			Name:        master.Name,
+			Lat:         master.Lat,
+			Lon:         master.Lon,
+			Description: master.Description,
+			Starttime:   master.Starttime.Format(JsonTimeLayout),
+			Changes:     spotmasterChanges(history, i),
+		}
+		if master.Capacity > 0 {
+			capacity := master.Capacity
+			item.Capacity = &capacity
+		}
+		if !master.Endtime.IsZero() {
+			endtime := master.Endtime.Format(JsonTimeLayout)
+			item.Endtime = &endtime
+		}
+		jBody.Items = append(jBody.Items, item)
+	}
+	jBody.Active = history[len(history)-1].Endtime.IsZero()
+	jBody.Num = len(jBody.Items)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
+
+//GetPlacesChanges sinceより後に登録・名前の変更・移動・終了があったスポットを時刻の昇順で返す公開API
+func GetPlacesChanges(w rest.ResponseWriter, r *rest.Request) {
+	//パース
+	r.ParseForm()
+	params := r.Form
+	if params.Get("since") == "" {
+		writeError(w, errInvalidParameter("sinceを指定する必要があります"))
+		return
+	}
+	since, err := parseDatetimeParam(params.Get("since"), false)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	option := rdb.SearchOptions{Area: params.Get("area")}.
+		Sort(rdb.Asc(rdb.ColumnArea), rdb.Asc(rdb.ColumnSpot), rdb.Asc(rdb.ColumnStarttime))
+	masters, err := Store.SearchSpotmaster(option)
+	if err != nil {
+		writeError(w, errInternal("マスターの検索に失敗しました"))
+		return
+	}
+
+	//DBの時刻はタイムゾーンを持たないので文字列で比較する
+	sinceStr := since.Format(rdb.TimeLayout)
+	jBody := static.JPlacesChangesBody{Items: []static.JPlaceChange{}}
+	var events []spotmasterEvent
+	for _, history := range groupSpotmasters(masters) {
+		events = append(events, spotmasterEvents(history)...)
+	}
+	sortSpotmasterEvents(events)
+	for _, event := range events {
+		if event.Time.Format(rdb.TimeLayout) < sinceStr {
+			continue
+		}
+		jBody.Items = append(jBody.Items, static.JPlaceChange{
+			Datetime:     event.Time.Format(JsonTimeLayout),
+			Change:       event.Change,
+			Area:         event.Master.Area,
+			Spot:         event.Master.Spot,
+			Name:         event.Master.Name,
+			PreviousName: event.PreviousName,
+			Lat:          event.Master.Lat,
+			Lon:          event.Master.Lon,
+		})
+	}
+	jBody.Num = len(jBody.Items)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  その他関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//spotmasterEvent スポットの変更
+type spotmasterEvent struct {
+	Time         time.Time
+	Change       string
+	Master       rdb.Spotmaster
+	PreviousName string
+}
+
+//groupSpotmasters area, spot, starttimeの順に並んだマスタをスポットごとの履歴に分ける
+func groupSpotmasters(masters []rdb.Spotmaster) [][]rdb.Spotmaster {
+	var groups [][]rdb.Spotmaster
+	for i, master := range masters {
+		if i == 0 || master.Area != masters[i-1].Area || master.Spot != masters[i-1].Spot {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], master)
+	}
+	return groups
+}
+
+//spotmasterEvents 1つのスポットの履歴を変更の一覧にする
+func spotmasterEvents(history []rdb.Spotmaster) []spotmasterEvent {
+	var events []spotmasterEvent
+	for i, master := range history {
+		for _, change := range spotmasterChanges(history, i) {
+			event := spotmasterEvent{Time: master.Starttime, Change: change, Master: master}
+			if change == ChangeRenamed {
+				event.PreviousName = history[i-1].Name
+			}
+			events = append(events, event)
+		}
+		//次の版がない、または次の版の開始前に終了している
+		if !master.Endtime.IsZero() && (i == len(history)-1 || closedBefore(master, history[i+1])) {
+			events = append(events, spotmasterEvent{Time: master.Endtime, Change: ChangeClosed, Master: master})
+		}
+	}
+	return events
+}
+
+//sortSpotmasterEvents 時刻の昇順に並べる
+func sortSpotmasterEvents(events []spotmasterEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Format(rdb.TimeLayout) < events[j].Time.Format(rdb.TimeLayout)
+	})
+}
+
+//closedSpotmasters 終了したスポットの最後の版を返す（現在有効な版があるスポットは除く）
+func closedSpotmasters(option rdb.SearchOptions) ([]rdb.Spotmaster, error) {
+	option = option.Sort(rdb.Asc(rdb.ColumnArea), rdb.Asc(rdb.ColumnSpot), rdb.Asc(rdb.ColumnStarttime))
+	masters, err := Store.SearchSpotmaster(option)
+	if err != nil {
+		return nil, err
+	}
+	var closed []rdb.Spotmaster
+	for _, history := range groupSpotmasters(masters) {
+		if last := history[len(history)-1]; !last.Endtime.IsZero() {
+			closed = append(closed, last)
+		}
+	}
+	return closed, nil
+}
+
+//spotmasterChanges history[i]が直前の版から何が変わったか
+func spotmasterChanges(history []rdb.Spotmaster, i int) []string {
+	if i == 0 || closedBefore(history[i-1], history[i]) {
+		return []string{ChangeOpened}
+	}
+	prev, cur := history[i-1], history[i]
+	changes := []string{}
+	if prev.Name != cur.Name {
+		changes = append(changes, ChangeRenamed)
+	}
+	if !prev.SameLocation(cur) {
+		changes = append(changes, ChangeMoved)
+	}
+	return changes
+}
+
+//closedBefore prevがnextの開始前に終了していたか
+//名前や位置の変更では旧データの終了時刻は新データの開始時刻の1秒前になる
+func closedBefore(prev, next rdb.Spotmaster) bool {
+	return !prev.Endtime.IsZero() && next.Starttime.Sub(prev.Endtime) > time.Second
+}
@@ -0,0 +1,417 @@
+package apiserver
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：OpenAPI 3の仕様（/openapi.json）
+//
+//　ルート表（apiRoutes）とrouteDocsから起動時に組み立てる
+//　レスポンスのスキーマはstaticの構造体からreflectで作る（jsonタグ、omitempty、ポインタを反映）
+//　ドキュメントのないルートがあると起動しない（ルートを追加したらrouteDocsにも追加すること）
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//routeDoc ルートのドキュメント
+type routeDoc struct {
+	summary string
+	tag     string
+	//scope 必要なAPIキーのスコープ（空なら認証しない）
+	scope  rdb.Scope
+	params []paramDoc
+	//body リクエストボディの型（なければnil）
+	body interface{}
+	//response レスポンスの型（ボディがなければnil）
+	response interface{}
+	//contentType レスポンスのContent-Type（空ならapplication/json）
+	contentType string
+}
+
+//paramDoc パラメータのドキュメント
+type paramDoc struct {
+	name        string
+	in          string
+	typ         string
+	description string
+	required    bool
+	enum        []string
+}
+
+//schemaBuilder 構造体からスキーマを作る（名前付きの構造体はcomponentsに登録して参照する）
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+//openAPISpec /openapi.jsonで返す仕様（mainで組み立てる）
+var openAPISpec map[string]interface{}
+
+//パラメータ（v1とv2で共通）
+var (
+	countsParams = []paramDoc{
+		requiredQuery("area", "string", "エリアコード"),
+		requiredQuery("spot", "string", "スポットコード"),
+		query("day", "string", "検索の対象とする日付（yyyymmdd）。省略時は最新の台数のみ"),
+		query("from", "string", "期間検索の開始日時（yyyymmdd, yyyymmddhhmm, yyyymmddhhmmss）。指定するとdayは無視される"),
+		query("to", "string", "期間検索の終了日時（fromと同じ形式）。省略時は現在時刻"),
+	}
+	placesParams = []paramDoc{
+		query("area", "string", "エリアコード"),
+		query("spot", "string", "スポットコード"),
+		query("q", "string", "自由検索（スポット名など）"),
+		query("places", "string", "エリアコード-スポットコードのカンマ区切り（qより優先）"),
+		query("sort", "string", "ソート順", string(OrderByCountAsc), string(OrderByCountDesc),
+			string(OrderByAreaSpotAsc), string(OrderByAreaSpotDesc)),
+		query("limit", "integer", "最大の件数"),
+		query("include_closed", "boolean", "trueなら終了したスポットも返す"),
+	}
+	allPlacesParams = []paramDoc{
+		query("include_closed", "boolean", "trueなら終了したスポットも返す"),
+	}
+	suggestParams = []paramDoc{
+		requiredQuery("q", "string", "スポット名か最寄り駅の先頭"),
+		query("by", "string", "一致させる項目（省略時は名前、次に最寄り駅）", "name", "station"),
+		query("limit", "integer", "最大の件数（1〜100、省略時は10）"),
+	}
+	distancesParams = []paramDoc{
+		query("lat", "number", "緯度（bboxを指定しない場合は必須）"),
+		query("lon", "number", "経度（bboxを指定しない場合は必須）"),
+		query("bbox", "string", "表示範囲（minLon,minLat,maxLon,maxLat）"),
+		query("radius", "integer", "この距離（メートル）以内のスポットのみ返す"),
+		query("limit", "integer", "最大の件数（1〜1000）"),
+		query("min_count", "integer", "台数がこれ以上のスポットのみ返す"),
+	}
+	apikeyIDParams = []paramDoc{
+		{name: "id", in: "path", typ: "string", description: "APIキーのID", required: true},
+	}
+)
+
+//routeDocs ルートのドキュメント（キーは "メソッド パス"）
+var routeDocs = map[string]routeDoc{
+	"GET /counts": {summary: "1つのスポットの台数を検索する", tag: "v1", scope: rdb.ScopeRead,
+		params: countsParams, response: static.JCountsBody{}},
+	"GET /places": {summary: "スポットと最新の台数を検索する", tag: "v1", scope: rdb.ScopeRead,
+		params: placesParams, response: static.JPlacesBody{}},
+	"GET /places/history": {summary: "スポットマスタの全ての版を有効期間とともに返す", tag: "v1", scope: rdb.ScopeRead,
+		params:   []paramDoc{requiredQuery("area", "string", "エリアコード"), requiredQuery("spot", "string", "スポットコード")},
+		response: static.JPlacesHistoryBody{}},
+	"GET /places/changes": {summary: "sinceより後に登録・名前の変更・移動・終了があったスポットを返す", tag: "v1", scope: rdb.ScopeRead,
+		params: []paramDoc{requiredQuery("since", "string", "この日時より後の変更を返す（yyyymmdd, yyyymmddhhmm, yyyymmddhhmmss）"),
+			query("area", "string", "エリアコード")},
+		response: static.JPlacesChangesBody{}},
+	"GET /all_places": {summary: "全ての有効なスポットを返す", tag: "v1", scope: rdb.ScopeRead,
+		params: allPlacesParams, response: static.JAllPlacesBody{}},
+	"GET /distances": {summary: "近いスポットを近い順に返す", tag: "v1", scope: rdb.ScopeRead,
+		params: distancesParams, response: static.JDistancesBody{}},
+	"GET /stats/rollup": {summary: "時間・日単位の集計を返す", tag: "v1", scope: rdb.ScopeRead,
+		params: []paramDoc{requiredQuery("area", "string", "エリアコード"), requiredQuery("spot", "string", "スポットコード"),
+			query("granularity", "string", "集計の単位（省略時はhour）", "hour", "day"),
+			requiredQuery("from", "string", "開始日時（yyyymmdd, yyyymmddhhmm, yyyymmddhhmmss）"),
+			query("to", "string", "終了日時（fromと同じ形式）。省略時は現在時刻")},
+		response: static.JRollupBody{}},
+	"GET /forecast": {summary: "過去の同じ曜日・時刻の台数から台数を予測する", tag: "v1", scope: rdb.ScopeRead,
+		params: []paramDoc{requiredQuery("area", "string", "エリアコード"), requiredQuery("spot", "string", "スポットコード"),
+			requiredQuery("at", "string", "予測する日時（yyyymmddhhmm）")},
+		response: static.JForecast{}},
+	"GET /stream": {summary: "台数の変化をServer-Sent Eventsで配信する（countイベントのdata）", tag: "v1", scope: rdb.ScopeRead,
+		params: []paramDoc{query("places", "string", "エリアコード-スポットコードのカンマ区切り（省略時は全て）"),
+			{name: "Last-Event-ID", in: "header", typ: "string", description: "再接続時に最後に受け取ったイベントのID"}},
+		response: static.JStreamEvent{}, contentType: "text/event-stream"},
+	"GET /status": {summary: "システム稼働状況を返す（問題があれば503）", tag: "v1",
+		response: static.JServiceStatus{}},
+	"GET /openapi.json": {summary: "このAPIのOpenAPI 3の仕様を返す", tag: "v1",
+		response: map[string]interface{}{}},
+	"GET /v2/counts": {summary: "1つのスポットの台数を検索する（v2）", tag: "v2", scope: rdb.ScopeRead,
+		params: countsParams, response: static.JCountsBodyV2{}},
+	"GET /v2/places": {summary: "スポットと最新の台数を検索する（v2）", tag: "v2", scope: rdb.ScopeRead,
+		params: placesParams, response: static.JPlacesBodyV2{}},
+	"GET /v2/all_places": {summary: "全ての有効なスポットを返す（v2）", tag: "v2", scope: rdb.ScopeRead,
+		params: allPlacesParams, response: static.JAllPlacesBodyV2{}},
+	"GET /v2/distances": {summary: "近いスポットを近い順に返す（v2）", tag: "v2", scope: rdb.ScopeRead,
+		params: distancesParams, response: static.JDistancesBodyV2{}},
+	"GET /v2/places/suggest": {summary: "名前か最寄り駅の前方一致でスポットの候補を返す（v2）", tag: "v2", scope: rdb.ScopeRead,
+		params: suggestParams, response: static.JPlacesBodyV2{}},
+	"GET /gbfs/gbfs.json": {summary: "GBFSのフィード一覧", tag: "gbfs", scope: rdb.ScopeRead,
+		response: static.JGBFSDiscovery{}},
+	"GET /gbfs/system_information.json": {summary: "GBFSのシステム情報", tag: "gbfs", scope: rdb.ScopeRead,
+		response: static.JGBFSSystemInformation{}},
+	"GET /gbfs/station_information.json": {summary: "GBFSの有効なスポットの位置と名前", tag: "gbfs", scope: rdb.ScopeRead,
+		response: static.JGBFSStationInformation{}},
+	"GET /gbfs/station_status.json": {summary: "GBFSのスポットごとの最新の台数", tag: "gbfs", scope: rdb.ScopeRead,
+		response: static.JGBFSStationStatus{}},
+	"GET /private/config": {summary: "設定を返す", tag: "private", scope: rdb.ScopeConfig,
+		params: []paramDoc{query("hostid", "string", "ホストID")}, response: static.JConfig{}},
+	"GET /private/users": {summary: "ユーザー設定を返す", tag: "private", scope: rdb.ScopeUsers,
+		response: static.JUsers{}},
+	"POST /private/counts": {summary: "スクレイパーから台数を受け取る", tag: "private", scope: rdb.ScopeIngest,
+		body: static.JSpotinfo{}},
+	"POST /private/places": {summary: "スクレイパーからスポットマスタを受け取る", tag: "private", scope: rdb.ScopeIngest,
+		body: static.JSpotmaster{}},
+	"POST /private/user": {summary: "ユーザー設定を更新する", tag: "private", scope: rdb.ScopeUsers,
+		body: static.JUser{}, response: static.JUsers{}},
+	"GET /private/keys": {summary: "APIキーの一覧を返す", tag: "private", scope: rdb.ScopeAdmin,
+		response: static.JApikeysBody{}},
+	"POST /private/keys": {summary: "APIキーを発行する（秘密文字列はこのときだけ返す）", tag: "private", scope: rdb.ScopeAdmin,
+		body: static.JApikeyRequest{}, response: static.JApikeySecret{}},
+	"POST /private/keys/:id/rotate": {summary: "APIキーの秘密文字列を作り直す（前の秘密文字列もgrace分間は使える）", tag: "private",
+		scope: rdb.ScopeAdmin, response: static.JApikeySecret{},
+		params: append([]paramDoc{query("grace", "integer", "前の秘密文字列を受け付ける時間（分）")}, apikeyIDParams...)},
+	"DELETE /private/keys/:id": {summary: "APIキーを無効にする", tag: "private", scope: rdb.ScopeAdmin,
+		params: apikeyIDParams, response: static.JApikey{}},
+	"POST /private/reload": {summary: "app.iniの設定とマスタなどのキャッシュを読み込み直す", tag: "private", scope: rdb.ScopeAdmin,
+		response: static.JReload{}},
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  エンドポイント関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//GetOpenAPI OpenAPI 3の仕様を返す公開API
+func GetOpenAPI(w rest.ResponseWriter, r *rest.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(openAPISpec)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//query 任意のクエリパラメータ
+func query(name, typ, description string, enum ...string) paramDoc {
+	return paramDoc{name: name, in: "query", typ: typ, description: description, enum: enum}
+}
+
+//requiredQuery 必須のクエリパラメータ
+func requiredQuery(name, typ, description string, enum ...string) paramDoc {
+	p := query(name, typ, description, enum...)
+	p.required = true
+	return p
+}
+
+//routeKey routeDocsのキー
+func routeKey(route *rest.Route) string {
+	return route.HttpMethod + " " + route.PathExp
+}
+
+//checkRouteDocs 全てのルートにドキュメントがあり、ルートのないドキュメントがないか確認する
+func checkRouteDocs(routes []*rest.Route) error {
+	var missing, unused []string
+	keys := make(map[string]bool)
+	for _, route := range routes {
+		keys[routeKey(route)] = true
+		if _, ok := routeDocs[routeKey(route)]; !ok {
+			missing = append(missing, routeKey(route))
+		}
+	}
+	for key := range routeDocs {
+		if !keys[key] {
+			unused = append(unused, key)
+		}
+	}
+	sort.Strings(unused)
+	if len(missing) > 0 || len(unused) > 0 {
+		return fmt.Errorf("routeDocsがルート表と一致しません（ドキュメントなし：%v、ルートなし：%v）", missing, unused)
+	}
+	return nil
+}
+
+//buildOpenAPI ルート表からOpenAPI 3の仕様を作る
+func buildOpenAPI(routes []*rest.Route) (map[string]interface{}, error) {
+	if err := checkRouteDocs(routes); err != nil {
+		return nil, err
+	}
+	builder := &schemaBuilder{components: make(map[string]interface{})}
+	errorSchema := builder.schema(reflect.TypeOf(static.JError{}))
+	paths := make(map[string]interface{})
+	for _, route := range routes {
+		doc := routeDocs[routeKey(route)]
+		operation := map[string]interface{}{
+			"summary":     doc.summary,
+			"tags":        []string{doc.tag},
+			"operationId": strings.ToLower(route.HttpMethod) + operationName(route.PathExp),
+			"responses": map[string]interface{}{
+				"200": builder.response(doc),
+				"default": map[string]interface{}{
+					"description": "エラー（4xx/5xx）",
+					"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchema}},
+				},
+			},
+		}
+		if doc.scope != "" {
+			operation["description"] = "必要なAPIキーのスコープ：" + string(doc.scope)
+			operation["security"] = []interface{}{map[string]interface{}{"apiKey": []string{}}}
+			if doc.scope == rdb.ScopeRead {
+				//公開APIはREQUIRE_KEYが有効なときのみキーが必要
+				operation["security"] = []interface{}{map[string]interface{}{}, map[string]interface{}{"apiKey": []string{}}}
+			}
+		}
+		if len(doc.params) > 0 {
+			var params []interface{}
+			for _, p := range doc.params {
+				schema := map[string]interface{}{"type": p.typ}
+				if len(p.enum) > 0 {
+					schema["enum"] = p.enum
+				}
+				params = append(params, map[string]interface{}{
+					"name": p.name, "in": p.in, "description": p.description, "required": p.required, "schema": schema,
+				})
+			}
+			operation["parameters"] = params
+		}
+		if doc.body != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": builder.schema(reflect.TypeOf(doc.body))},
+				},
+			}
+		}
+		path := openAPIPath(route.PathExp)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(route.HttpMethod)] = operation
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Docomo Bikeshare API",
+			"description": "Docomoシェアサイクルの台数を返す非公式のAPI",
+			"version":     "1.0.0",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": conf.API.PublicURL}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": builder.components,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": apikeyHeader},
+			},
+		},
+	}, nil
+}
+
+//openAPIPath go-json-restのパス（:name）をOpenAPIのパス（{name}）にする
+func openAPIPath(pathExp string) string {
+	parts := strings.Split(pathExp, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+//operationName パスからoperationIdの名前部分を作る（/places/history → PlacesHistory）
+func operationName(pathExp string) string {
+	var name string
+	for _, part := range strings.FieldsFunc(pathExp, func(r rune) bool {
+		return r == '/' || r == '_' || r == '.' || r == ':'
+	}) {
+		name += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return name
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//response 正常時のレスポンス
+func (b *schemaBuilder) response(doc routeDoc) map[string]interface{} {
+	if doc.response == nil {
+		return map[string]interface{}{"description": "成功（ボディなし）"}
+	}
+	contentType := doc.contentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	return map[string]interface{}{
+		"description": "成功",
+		"content": map[string]interface{}{
+			contentType: map[string]interface{}{"schema": b.schema(reflect.TypeOf(doc.response))},
+		},
+	}
+}
+
+//schema 型のスキーマ（名前付きの構造体は$refで参照する）
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := b.schema(t.Elem())
+		if _, ok := schema["$ref"]; ok {
+			//$refには他のキーを並べられないためallOfで包む
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		if _, ok := b.components[t.Name()]; !ok {
+			//再帰に備えて先に登録する
+			b.components[t.Name()] = map[string]interface{}{}
+			b.components[t.Name()] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
+
+//object 構造体のスキーマ（埋め込みの構造体は展開する）
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	b.fields(t, properties, &required)
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+//fields 構造体のフィールドをpropertiesに追加する（omitempty以外は必須、ポインタはnullable）
+func (b *schemaBuilder) fields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			b.fields(field.Type, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.schema(field.Type)
+		if !strings.Contains(tag, ",omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package apiserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+//TestRouteDocs ルート表の全てのルートにrouteDocsがあり、OpenAPIの仕様に載ること
+func TestRouteDocs(t *testing.T) {
+	routes := apiRoutes()
+	for _, route := range routes {
+		if _, ok := routeDocs[routeKey(route)]; !ok {
+			t.Errorf("%sのrouteDocsがありません", routeKey(route))
+		}
+	}
+	spec, err := buildOpenAPI(routes)
+	if err != nil {
+		t.Fatalf("buildOpenAPI : %v", err)
+	}
+	paths := spec["paths"].(map[string]interface{})
+	for _, route := range routes {
+		item, ok := paths[openAPIPath(route.PathExp)].(map[string]interface{})
+		if !ok {
+			t.Errorf("%sがpathsにありません", route.PathExp)
+			continue
+		}
+		if _, ok := item[strings.ToLower(route.HttpMethod)]; !ok {
+			t.Errorf("%sがpathsにありません", routeKey(route))
+		}
+	}
+}
+
+//TestRouteDocsMissing ドキュメントのないルートがあればbuildOpenAPIが失敗すること
+func TestRouteDocsMissing(t *testing.T) {
+	routes := append(apiRoutes(), rest.Get("/undocumented", GetOpenAPI))
+	_, err := buildOpenAPI(routes)
+	if err == nil || !strings.Contains(err.Error(), "GET /undocumented") {
+		t.Fatalf("ドキュメントのないルートでエラーになりません : %v", err)
+	}
+}
@@ -1,8 +1,9 @@
-package main
+package apiserver
 
 import (
-	"net/http"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/8245snake/bikeshare_api/src/lib/logger"
@@ -11,11 +12,15 @@ import (
 	"github.com/ant0ine/go-json-rest/rest"
 )
 
+//saveSpotmasterMu マスタの保存を直列にする（スクレイパーのPOSTとGBFSの取り込みが重なることがある）
+var saveSpotmasterMu sync.Mutex
+
+//spotMissing 有効なスポットがエリアの全てを含むPOSTに続けて含まれていなかった回数（キーはarea-spot）
+//保存しないので再起動すると数え直す（終了が遅れるだけで誤って終了することはない）
+var spotMissing = make(map[string]int)
+
 //GetConfig 設定を返す
 func GetConfig(w rest.ResponseWriter, r *rest.Request) {
-	if !checkHeader(r) {
-		return
-	}
 	//レスポンス用
 	var jBody static.JConfig
 
@@ -24,11 +29,11 @@ func GetConfig(w rest.ResponseWriter, r *rest.Request) {
 	params := r.Form
 	hostid := params.Get("hostid")
 	//検索
-	option := rdb.SearchOptions{AddWhere: "trim(hostid) in ('', '" + hostid + "')", OrderBy: "hostid"}
-	configs, err := rdb.SearchConfig(Db, option)
+	option := rdb.SearchOptions{}.Where(rdb.In(rdb.ColumnHostID, "", hostid)).Sort(rdb.Asc(rdb.ColumnHostID))
+	configs, err := Store.SearchConfig(option)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteJson("設定の検索に失敗しました")
+		logger.FromContext(r.Context()).Errorf("GetConfig SearchConfigでエラー : %v", err)
+		writeError(w, errInternal("設定の検索に失敗しました"))
 		return
 	}
 	for _, config := range configs {
@@ -57,37 +62,34 @@ func GetConfig(w rest.ResponseWriter, r *rest.Request) {
 
 //SetSpotinfo スクレイパーからのPOSTに対応
 func SetSpotinfo(w rest.ResponseWriter, r *rest.Request) {
-	if !checkHeader(r) {
-		return
-	}
 	body := static.JSpotinfo{}
 	if err := r.DecodeJsonPayload(&body); err != nil {
-		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, errInvalidParameter("JSONの形式が不正です : %v", err))
 		return
 	}
 
 	//body.Spotinfo をconn.Spotinfoの配列にする
 	var rows []rdb.Spotinfo
 	for _, row := range body.Spotinfo {
-		time, _ := time.Parse(rdb.TimeLayout, row.Time)
+		time, err := time.Parse(rdb.TimeLayout, row.Time)
+		if err != nil {
+			writeError(w, errInvalidParameter("%s-%sの日時%sが不正です（%s）", row.Area, row.Spot, row.Time, rdb.TimeLayout))
+			return
+		}
 		temp := rdb.Spotinfo{Area: row.Area, Spot: row.Spot, Time: time, Count: row.Count}
 		rows = append(rows, temp)
 	}
-
-	if _, err := rdb.BulkInsertSpotinfo(Db, rows); err != nil {
-		Db.Close()
-		Db, err = rdb.GetConnectionPsql()
+	if err := saveSpotinfo(rows); err != nil {
+		logger.FromContext(r.Context()).Errorf("SetSpotinfo %v", err)
+		writeError(w, errInternal("台数の保存に失敗しました"))
 	}
 }
 
 //SetSpotMaster スクレイパーからのPOSTに対応
 func SetSpotMaster(w rest.ResponseWriter, r *rest.Request) {
-	if !checkHeader(r) {
-		return
-	}
 	body := static.JSpotmaster{}
 	if err := r.DecodeJsonPayload(&body); err != nil {
-		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, errInvalidParameter("JSONの形式が不正です : %v", err))
 		return
 	}
 	//body.Spotmaster をrdb.Spotmasterの配列にする
@@ -99,48 +101,17 @@ func SetSpotMaster(w rest.ResponseWriter, r *rest.Request) {
 			Lat:  strings.TrimSpace(row.Lat),
 			Lon:  strings.TrimSpace(row.Lon)})
 	}
-
-	//更新があるかチェック
-	var updateList []rdb.Spotmaster
-	now := time.Now()
-	//ミリ秒はいらない
-	now = time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), 0, time.Local)
-	for _, row := range rows {
-		olds, err := GetSpotmasterFromCache(row.Area, row.Spot)
-		if err != nil {
-			//見つからなかったら無条件で追加
-			row.Starttime = now
-			updateList = append(updateList, row)
-		} else {
-			//見つかったら名前を比較し変わっていたら更新
-			if row.Name != olds.Name {
-				row.Starttime = now
-				updateList = append(updateList, row)
-				//旧データは－1秒して更新
-				olds.Endtime = now.Add(-1 * time.Second)
-				updateList = append(updateList, olds)
-			}
-		}
-	}
-	//Upsert
-	for _, item := range updateList {
-		err := rdb.UpsertSpotmaster(Db, item)
-		if err != nil {
-			logger.Debugf("UpsertSpotmaster_Error %v \n", err)
-		}
+	if err := saveSpotmaster(rows, body.Full, body.FullAreas); err != nil {
+		logger.FromContext(r.Context()).Errorf("SetSpotMaster %v", err)
+		writeError(w, errInternal("マスタの保存に失敗しました"))
 	}
-	//キャッシュ最新化
-	GetCacheSpotMaster()
 }
 
 //GetUser ユーザー設定を返す
 func GetUser(w rest.ResponseWriter, r *rest.Request) {
-	if !checkHeader(r) {
-		return
-	}
-	users, err := rdb.GetAllUsers(Db)
+	users, err := Store.GetAllUsers()
 	if err != nil {
-		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, errInternal("%v", err))
 		return
 	}
 
@@ -151,25 +122,22 @@ func GetUser(w rest.ResponseWriter, r *rest.Request) {
 
 //UpdateUser ユーザー設定を更新する
 func UpdateUser(w rest.ResponseWriter, r *rest.Request) {
-	if !checkHeader(r) {
-		return
-	}
 
 	body := static.JUser{}
 	if err := r.DecodeJsonPayload(&body); err != nil {
-		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, errInvalidParameter("JSONの形式が不正です : %v", err))
 		return
 	}
 
-	if err := rdb.UpsertUser(Db, &body); err != nil {
-		rest.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := Store.UpsertUser(&body); err != nil {
+		writeError(w, errInternal("%v", err))
 		return
 	}
 
 	//最新情報を返す
-	users, err := rdb.GetAllUsers(Db)
+	users, err := Store.GetAllUsers()
 	if err != nil {
-		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, errInternal("%v", err))
 		return
 	}
 
@@ -177,3 +145,130 @@ func UpdateUser(w rest.ResponseWriter, r *rest.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteJson(static.JUsers{Users: users})
 }
+
+//saveSpotinfo 台数を保存する（スクレイパーのPOSTとGBFSの取り込みで共通）
+func saveSpotinfo(rows []rdb.Spotinfo) error {
+	if _, err := Store.BulkInsertSpotinfo(rows); err != nil {
+		ingestErrors.Inc("counts")
+		return fmt.Errorf("BulkInsertSpotinfoでエラー : %v", err)
+	}
+	ingestRows.Add(float64(len(rows)), "counts")
+	ingestLastSuccess.SetToCurrentTime("counts")
+	//台数が変わったスポットを/streamに配信
+	Hub.Publish(rows)
+	return nil
+}
+
+//saveSpotmaster スクレイパーがPOSTしたマスタを保存する
+//fullなら含まれていない有効なスポットにすぐ終了時刻を入れる
+//fullAreasのエリアの有効なスポットはfullAreasに含めたPOSTにMASTER_MISSING_COUNT回続けて含まれていないときに終了時刻を入れる
+//エリアの一部だけのPOST（分割して送るスクレイパー）ではスポットを終了しない
+func saveSpotmaster(rows []rdb.Spotmaster, full bool, fullAreas []string) error {
+	return storeSpotmaster(rows, true, full, fullAreas)
+}
+
+//importSpotmaster GBFSから取り込んだマスタを保存する
+//対応表で絞り込んだりスクレイパーと同じエリアを取り込んだりするので、送られてこなかったスポットは数えない
+func importSpotmaster(rows []rdb.Spotmaster) error {
+	return storeSpotmaster(rows, false, false, nil)
+}
+
+//storeSpotmaster マスタを保存する（saveSpotmasterとimportSpotmasterで共通）
+//名前や位置が変わったスポットは旧データに終了時刻を入れて新しい行を追加する
+//detectがfalseなら送られてこなかったスポットの回数を数えも数え直しもしない
+//Upsertに失敗したスポットがあれば残りを保存してから最初のエラーを返す
+func storeSpotmaster(rows []rdb.Spotmaster, detect bool, full bool, fullAreas []string) error {
+	saveSpotmasterMu.Lock()
+	defer saveSpotmasterMu.Unlock()
+	//スクレイパーの失敗で空のまま送られてきたときに全スポットを終了しないようにする
+	full = full && len(rows) > 0
+	//更新があるかチェック
+	var updateList []rdb.Spotmaster
+	now := time.Now()
+	//ミリ秒はいらない
+	now = time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), 0, time.Local)
+	areas := make(map[string]bool)
+	posted := make(map[string]bool)
+	for _, row := range rows {
+		areas[row.Area] = true
+		posted[row.Area+"-"+row.Spot] = true
+		olds, err := GetSpotmasterFromCache(row.Area, row.Spot)
+		if err != nil {
+			//見つからなかったら無条件で追加
+			row.Starttime = now
+			updateList = append(updateList, row)
+			continue
+		}
+		//見つかったら名前と位置を比較し変わっていたら更新
+		renamed := row.Name != olds.Name
+		moved := !row.SameLocation(olds)
+		if renamed || moved {
+			logger.Infof("saveSpotmaster %s-%sが変更されました(名前:%s→%s 位置:%s,%s→%s,%s)",
+				row.Area, row.Spot, olds.Name, row.Name, olds.Lat, olds.Lon, row.Lat, row.Lon)
+			row.Starttime = now
+			if row.Capacity == 0 {
+				row.Capacity = olds.Capacity
+			}
+			updateList = append(updateList, row)
+			//旧データは－1秒して更新
+			olds.Endtime = now.Add(-1 * time.Second)
+			updateList = append(updateList, olds)
+		} else if row.Capacity > 0 && row.Capacity != olds.Capacity {
+			//ラック数は履歴を残さずそのまま更新
+			olds.Capacity = row.Capacity
+			updateList = append(updateList, olds)
+		}
+	}
+	//送られてこなかったスポット
+	if detect {
+		updateList = append(updateList, missingSpotmaster(now, areas, posted, full, fullAreas)...)
+	}
+	//Upsert
+	var firstErr error
+	for _, item := range updateList {
+		err := Store.UpsertSpotmaster(item)
+		if err != nil {
+			ingestErrors.Inc("places")
+			if firstErr == nil {
+				firstErr = fmt.Errorf("UpsertSpotmaster %s-%sでエラー : %v", item.Area, item.Spot, err)
+			}
+		}
+	}
+	ingestRows.Add(float64(len(rows)), "places")
+	if firstErr == nil {
+		ingestLastSuccess.SetToCurrentTime("places")
+	}
+	//キャッシュ最新化
+	GetCacheSpotMaster()
+	return firstErr
+}
+
+//missingSpotmaster 送られてこなかった有効なスポットを数え、終了するものに終了時刻を入れて返す
+//areasとpostedは送られてきたエリアとスポット（スクレイパーの失敗で空のまま送られてきたエリアは数えない）
+func missingSpotmaster(now time.Time, areas, posted map[string]bool, full bool, fullAreas []string) []rdb.Spotmaster {
+	var ended []rdb.Spotmaster
+	complete := make(map[string]bool)
+	for _, area := range fullAreas {
+		area = strings.TrimSpace(area)
+		complete[area] = areas[area]
+	}
+	threshold := currentConf().API.MasterMissingCount
+	for _, master := range Masters.All() {
+		key := master.Area + "-" + master.Spot
+		if posted[key] {
+			delete(spotMissing, key)
+			continue
+		}
+		if !full && !complete[master.Area] {
+			continue
+		}
+		spotMissing[key]++
+		if full || (threshold > 0 && spotMissing[key] >= threshold) {
+			logger.Infof("saveSpotmaster %sが%d回続けて含まれていないため終了します", key, spotMissing[key])
+			master.Endtime = now
+			ended = append(ended, master)
+			delete(spotMissing, key)
+		}
+	}
+	return ended
+}
@@ -0,0 +1,105 @@
+package apiserver
+
+import (
+	"testing"
+
+	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+)
+
+//useMasterStore マスタを空のMemoryStoreに保存するようにする（MASTER_MISSING_COUNTはmissing）
+func useMasterStore(t *testing.T, missing int) {
+	savedStore, savedMasters, savedConf := Store, Masters, currentConf()
+	Store = rdb.NewMemoryStore()
+	Masters = &rdb.MasterCache{}
+	spotMissing = make(map[string]int)
+	confMu.Lock()
+	conf.API.MasterMissingCount = missing
+	confMu.Unlock()
+	t.Cleanup(func() {
+		Store, Masters = savedStore, savedMasters
+		spotMissing = make(map[string]int)
+		confMu.Lock()
+		conf = savedConf
+		confMu.Unlock()
+	})
+}
+
+//masterRows スポットのコードからマスタを作る
+func masterRows(codes ...string) []rdb.Spotmaster {
+	var rows []rdb.Spotmaster
+	for _, code := range codes {
+		area, spot := code[:2], code[3:]
+		rows = append(rows, rdb.Spotmaster{Area: area, Spot: spot, Name: code, Lat: "35.0", Lon: "139.0"})
+	}
+	return rows
+}
+
+//saveMasters saveSpotmasterを呼ぶ（エラーならテストを止める）
+func saveMasters(t *testing.T, rows []rdb.Spotmaster, full bool, fullAreas ...string) {
+	t.Helper()
+	if err := saveSpotmaster(rows, full, fullAreas); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//checkActive スポットが有効か（Endtimeが入っていないか）をStoreで確認する
+func checkActive(t *testing.T, step string, want map[string]bool) {
+	t.Helper()
+	masters, err := Store.SearchSpotmaster(rdb.SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := make(map[string]bool)
+	for _, m := range masters {
+		if m.Endtime.IsZero() {
+			active[m.Area+"-"+m.Spot] = true
+		}
+	}
+	for code, w := range want {
+		if active[code] != w {
+			t.Errorf("%s : %sの有効 %v（期待値 %v）", step, code, active[code], w)
+		}
+	}
+}
+
+//TestSaveSpotmasterPartial エリアの一部だけのPOSTではスポットを終了しない
+func TestSaveSpotmasterPartial(t *testing.T) {
+	useMasterStore(t, 3)
+	saveMasters(t, masterRows("A1-001", "A1-002", "A2-001"), false)
+	for i := 0; i < 5; i++ {
+		saveMasters(t, masterRows("A1-001"), false)
+	}
+	checkActive(t, "一部だけのPOSTを5回", map[string]bool{"A1-001": true, "A1-002": true, "A2-001": true})
+}
+
+//TestSaveSpotmasterFullAreas full_areasのPOSTにMASTER_MISSING_COUNT回続けて含まれていなければ終了する
+func TestSaveSpotmasterFullAreas(t *testing.T) {
+	useMasterStore(t, 3)
+	saveMasters(t, masterRows("A1-001", "A1-002", "A1-003", "A2-001"), false)
+
+	saveMasters(t, masterRows("A1-001"), false, "A1")
+	saveMasters(t, masterRows("A1-001"), false, "A1")
+	//間に一部だけのPOSTがあっても数は変わらない（含まれていれば数え直す）
+	saveMasters(t, masterRows("A1-003"), false)
+	checkActive(t, "full_areasのPOSTを2回", map[string]bool{"A1-002": true, "A1-003": true})
+
+	saveMasters(t, masterRows("A1-001"), false, "A1")
+	checkActive(t, "full_areasのPOSTを3回", map[string]bool{"A1-001": true, "A1-002": false, "A1-003": true, "A2-001": true})
+
+	//スポットが1つもないエリアはスクレイパーの失敗とみなして数えない
+	for i := 0; i < 3; i++ {
+		saveMasters(t, masterRows("A1-001"), false, "A1", "A2")
+	}
+	checkActive(t, "空のエリアをfull_areasに含めたPOSTを3回", map[string]bool{"A1-001": true, "A1-003": false, "A2-001": true})
+}
+
+//TestSaveSpotmasterFull fullのPOSTに含まれていないスポットはすぐ終了する
+func TestSaveSpotmasterFull(t *testing.T) {
+	useMasterStore(t, 3)
+	saveMasters(t, masterRows("A1-001", "A1-002", "A2-001"), false)
+	//空のPOSTでは何も終了しない
+	saveMasters(t, nil, true)
+	checkActive(t, "空のfullのPOST", map[string]bool{"A1-001": true, "A1-002": true, "A2-001": true})
+	saveMasters(t, masterRows("A1-001"), true)
+	checkActive(t, "fullのPOST", map[string]bool{"A1-001": true, "A1-002": false, "A2-001": false})
+}
@@ -0,0 +1,103 @@
+package apiserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/config"
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/reload"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：設定とキャッシュの読み込み直し
+//
+//　SIGHUPかPOST /private/reloadでapp.iniの設定とキャッシュを、[API] RELOAD_INTERVAL毎にキャッシュだけを読み込み直す
+//　リクエストの処理ではcurrentConfで設定を読むので、読み込み直すと次のリクエストから反映される
+//　ポートやDBなど起動時にしか使わない設定（restartタグ）は変えても再起動するまで反映されない
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//reloader 設定とキャッシュの読み込み直し
+var reloader = reload.New(reloadAll)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  エンドポイント関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//PostReload 設定とキャッシュを読み込み直す（app.iniに誤りがあれば今の設定のまま500を返す）
+func PostReload(w rest.ResponseWriter, r *rest.Request) {
+	if err := reloader.Reload(reload.TriggerAPI); err != nil {
+		writeError(w, errInternal("%v", err))
+		return
+	}
+	current := currentConf()
+	_, pending := config.Changed(&startConf, &current)
+	if pending == nil {
+		pending = []string{}
+	}
+	jBody := static.JReload{Reloaded: time.Now().Format(JsonTimeLayout), Places: Masters.Len(), Pending: pending}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  その他関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//currentConf 今の設定
+func currentConf() Config {
+	confMu.RLock()
+	defer confMu.RUnlock()
+	return conf
+}
+
+//reloadAll reload.Funcの実装
+func reloadAll(settings bool) error {
+	var errs []string
+	if settings {
+		if err := reloadSettings(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if err := reloadMasters(); err != nil {
+		errs = append(errs, fmt.Sprintf("マスタを読み込めません : %v", err))
+	}
+	if err := reloadApikeys(); err != nil {
+		errs = append(errs, fmt.Sprintf("APIキーを読み込めません : %v", err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+//reloadSettings app.iniを読み込み直して設定を入れ替える（誤りがあれば今の設定のまま）
+func reloadSettings() error {
+	var next Config
+	if err := config.Reload(&next); err != nil {
+		return err
+	}
+	confMu.Lock()
+	changed, _ := config.Changed(&conf, &next)
+	conf = next
+	confMu.Unlock()
+	logger.Reconfigure(next.Log.Logger())
+	if len(changed) > 0 {
+		logger.Infof("reloadSettings 変更された設定 : %s", strings.Join(changed, ", "))
+	}
+	if _, pending := config.Changed(&startConf, &next); len(pending) > 0 {
+		logger.Warnf("reloadSettings 再起動するまで反映されない設定 : %s", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+//reloadMasters マスタのキャッシュを読み込み直す（マスタの保存中なら終わるまで待つ）
+func reloadMasters() error {
+	saveSpotmasterMu.Lock()
+	defer saveSpotmasterMu.Unlock()
+	return GetCacheSpotMaster()
+}
@@ -0,0 +1,304 @@
+package apiserver
+
+import (
+	"errors"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：公開APIの検索（v1とv2で共通）
+//
+//　パラメータの解釈と検索だけを行い、JSONへの変換は各バージョンのハンドラで行う
+//　エラーはwriteErrorでそのまま返せるapiErrorで返す
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//countsResult /countsの検索結果
+type countsResult struct {
+	rows    []rdb.Spotinfo
+	history []rdb.Spotmaster
+	//master 返す台数のうち最新の時点で有効だったマスタ
+	master rdb.Spotmaster
+}
+
+//spotDistance /distancesの検索結果
+type spotDistance struct {
+	view     rdb.CurrentFull
+	distance int
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//searchCounts 台数を検索する（area, spot, day, from, to）
+func searchCounts(params url.Values) (result countsResult, err error) {
+	area := params.Get("area")
+	spot := params.Get("spot")
+	day := params.Get("day")
+	from := params.Get("from")
+	to := params.Get("to")
+	if area == "" || spot == "" {
+		return result, errInvalidParameter("台数検索の際にはareaとspotの両方を指定する必要があります")
+	}
+
+	if from != "" || to != "" {
+		//期間指定（dayより優先される）
+		var fromTime, toTime time.Time
+		fromTime, toTime, err = parseRangeParams(from, to, MaxCountsRange)
+		if err == nil {
+			result.rows, err = rdb.SearchCountsByRange(Store, area, spot, fromTime, toTime)
+		}
+	} else {
+		if day != "" {
+			if _, errDay := time.Parse("20060102", day); errDay != nil {
+				return result, errInvalidParameter("dayはyyyymmddの形式で指定してください")
+			}
+		}
+		result.rows, err = rdb.SearchCountsByDay(Store, area, spot, day)
+		if errors.Is(err, rdb.ErrArchiveNotFound) {
+			return result, errNotFound("%sの台数はありません", day)
+		}
+	}
+	if err != nil {
+		if _, ok := err.(*apiError); ok {
+			return result, err
+		}
+		logger.Errorf("searchCounts 台数の検索でエラー : %v", err)
+		return result, errInternal("台数の検索に失敗しました")
+	}
+
+	//マスタ検索（過去のデータにはその時点の名前を付ける）
+	result.history, err = rdb.SearchSpotmasterHistory(Store, area, spot)
+	if err != nil {
+		logger.Errorf("searchCounts SearchSpotmasterHistoryでエラー : %v", err)
+		return result, errInternal("マスターの検索に失敗しました")
+	}
+	if len(result.history) == 0 {
+		return result, errNotFound("スポットが見つかりません(area=%s, spot=%s)", area, spot)
+	}
+	labelTime := time.Now()
+	if len(result.rows) > 0 {
+		//期間検索は昇順、日付指定は降順なので新しい方の時刻を使う
+		labelTime = result.rows[0].Time
+		if last := result.rows[len(result.rows)-1].Time; last.After(labelTime) {
+			labelTime = last
+		}
+	}
+	result.master, _ = rdb.SpotmasterAt(result.history, labelTime)
+	return result, nil
+}
+
+//searchPlaces スポットを検索する（area, spot, q, places, sort, limit, include_closed）
+//closedは終了したスポット（include_closedのときのみ、limitの残りの件数まで）
+func searchPlaces(params url.Values) (views []rdb.CurrentFull, closed []rdb.Spotmaster, err error) {
+	area := params.Get("area")
+	spot := params.Get("spot")
+	query := params.Get("q")
+	var filters []rdb.Filter
+	//自由検索
+	if query != "" {
+		filters = []rdb.Filter{rdb.Contains(rdb.ColumnSearchText, query)}
+	}
+	//スポット指定（自由検索より優先される）
+	places := params.Get("places")
+	if places != "" {
+		filters = []rdb.Filter{rdb.In(rdb.ColumnAreaSpot, strings.Split(places, ",")...)}
+	}
+	//ソート順
+	var orders []rdb.Order
+	switch OrderByType(params.Get("sort")) {
+	case OrderByAreaSpotAsc:
+		orders = []rdb.Order{rdb.Asc(rdb.ColumnArea), rdb.Asc(rdb.ColumnSpot)}
+	case OrderByAreaSpotDesc:
+		orders = []rdb.Order{rdb.Desc(rdb.ColumnArea), rdb.Desc(rdb.ColumnSpot)}
+	case OrderByCountAsc:
+		orders = []rdb.Order{rdb.Asc(rdb.ColumnCount)}
+	case OrderByCountDesc:
+		orders = []rdb.Order{rdb.Desc(rdb.ColumnCount)}
+	}
+	//リミット
+	limitStr := params.Get("limit")
+	var limit int
+	if limitStr != "" {
+		val, err := strconv.Atoi(limitStr)
+		if err != nil || val < 0 {
+			return nil, nil, errInvalidParameter("limitには0以上の整数を指定する必要があります(%s)", limitStr)
+		}
+		limit = val
+	}
+	//検索条件セット
+	option := rdb.SearchOptions{
+		Area:  area,
+		Spot:  spot,
+		Limit: limit,
+	}.Where(filters...).Sort(orders...)
+	//検索
+	views, err = Store.SearchCurrentFull(option)
+	if err != nil {
+		logger.Errorf("searchPlaces SearchCurrentFullでエラー : %v", err)
+		return nil, nil, errInternal("マスターの検索に失敗しました")
+	}
+	//終了したスポット
+	if params.Get("include_closed") == "true" && (limit == 0 || len(views) < limit) {
+		closed, err = closedSpotmasters(rdb.SearchOptions{Area: area, Spot: spot}.Where(filters...))
+		if err != nil {
+			logger.Errorf("searchPlaces closedSpotmastersでエラー : %v", err)
+			return nil, nil, errInternal("マスターの検索に失敗しました")
+		}
+		if limit != 0 && len(views)+len(closed) > limit {
+			closed = closed[:limit-len(views)]
+		}
+	}
+	return views, closed, nil
+}
+
+//searchAllPlaces 全ての有効なスポットを返す（include_closedなら終了したスポットも後ろに付ける）
+func searchAllPlaces(params url.Values) ([]rdb.Spotmaster, error) {
+	masters := Masters.All()
+	if params.Get("include_closed") == "true" {
+		closed, err := closedSpotmasters(rdb.SearchOptions{})
+		if err != nil {
+			logger.Errorf("searchAllPlaces closedSpotmastersでエラー : %v", err)
+			return nil, errInternal("マスターの検索に失敗しました")
+		}
+		masters = append(masters, closed...)
+	}
+	return masters, nil
+}
+
+//searchSuggestions 名前か最寄り駅がqで始まる有効なスポットをキャッシュから検索する（q, by, limit）
+//byがなければ名前で一致したものの後に最寄り駅で一致したものを付ける
+func searchSuggestions(params url.Values) ([]rdb.Spotmaster, error) {
+	query := strings.TrimSpace(params.Get("q"))
+	if query == "" {
+		return nil, errInvalidParameter("qを指定する必要があります")
+	}
+	limit, err := parseOptionalInt(params.Get("limit"), DefaultSuggestLimit)
+	if err != nil || limit < 1 {
+		return nil, errInvalidParameter("limitには1以上の整数を指定する必要があります")
+	}
+	if limit > MaxSuggestLimit {
+		limit = MaxSuggestLimit
+	}
+	switch by := params.Get("by"); by {
+	case "name":
+		return Masters.ByName(query, limit), nil
+	case "station":
+		return Masters.ByStation(query, limit), nil
+	case "":
+	default:
+		return nil, errInvalidParameter("byにはnameかstationを指定する必要があります")
+	}
+	masters := Masters.ByName(query, limit)
+	found := map[string]bool{}
+	for _, m := range masters {
+		found[m.Area+"-"+m.Spot] = true
+	}
+	for _, m := range Masters.ByStation(query, 0) {
+		if len(masters) >= limit {
+			break
+		}
+		if !found[m.Area+"-"+m.Spot] {
+			masters = append(masters, m)
+		}
+	}
+	return masters, nil
+}
+
+//searchDistances 近いスポットを近い順に検索する（lat, lon, bbox, radius, limit, min_count）
+func searchDistances(params url.Values) ([]spotDistance, error) {
+	lat := params.Get("lat")
+	lon := params.Get("lon")
+	bbox := params.Get("bbox")
+	if (lat == "" || lon == "") && bbox == "" {
+		return nil, errInvalidParameter("latとlonの両方、またはbboxを指定する必要があります")
+	}
+
+	var box *boundingBox
+	var baseLat, baseLon float64
+	if bbox != "" {
+		parsed, err := parseBoundingBox(bbox)
+		if err != nil {
+			return nil, err
+		}
+		box = &parsed
+		baseLat, baseLon = box.center()
+	}
+	if lat != "" || lon != "" {
+		var errLat, errLon error
+		baseLat, errLat = strconv.ParseFloat(lat, 64)
+		baseLon, errLon = strconv.ParseFloat(lon, 64)
+		if errLat != nil || errLon != nil {
+			return nil, errInvalidParameter("latとlonには数値を指定する必要があります")
+		}
+	}
+	//件数（bboxのときは範囲内をすべて返せるよう既定値を大きくする）
+	limit := DefaultDistancesLimit
+	if box != nil {
+		limit = MaxDistancesLimit
+	}
+	radius, errRadius := parseOptionalInt(params.Get("radius"), 0)
+	limit, errLimit := parseOptionalInt(params.Get("limit"), limit)
+	minCount, errMinCount := parseOptionalInt(params.Get("min_count"), 0)
+	if errRadius != nil || errLimit != nil || errMinCount != nil || radius < 0 || limit < 1 || minCount < 0 {
+		return nil, errInvalidParameter("radius, limit, min_countには0以上の整数を指定する必要があります（limitは1以上）")
+	}
+	if limit > MaxDistancesLimit {
+		limit = MaxDistancesLimit
+	}
+
+	arr, err := Store.SearchCurrentFull(rdb.SearchOptions{})
+	if err != nil {
+		logger.Errorf("searchDistances SearchCurrentFullでエラー : %v", err)
+		return nil, errInternal("DBの検索に失敗しました")
+	}
+	//距離を計算して近い順に並べる
+	var distances []spotDistance
+	for _, view := range arr {
+		viewLat, errLat := strconv.ParseFloat(view.Lat, 64)
+		viewLon, errLon := strconv.ParseFloat(view.Lon, 64)
+		if errLat != nil || errLon != nil {
+			continue
+		}
+		if box != nil && !box.contains(viewLat, viewLon) {
+			continue
+		}
+		if minCount > 0 {
+			if count, err := strconv.Atoi(strings.TrimSpace(view.Count)); err != nil || count < minCount {
+				continue
+			}
+		}
+		distance := int(math.Round(haversine(baseLat, baseLon, viewLat, viewLon)))
+		if radius > 0 && distance > radius {
+			continue
+		}
+		distances = append(distances, spotDistance{view: view, distance: distance})
+	}
+	sort.SliceStable(distances, func(i, j int) bool {
+		return distances[i].distance < distances[j].distance
+	})
+	if len(distances) > limit {
+		distances = distances[:limit]
+	}
+	return distances, nil
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//nameAt tの時点のスポット名（masterと同じなら空文字）
+func (c countsResult) nameAt(t time.Time) string {
+	if at, _ := rdb.SpotmasterAt(c.history, t); at.Name != c.master.Name {
+		return at.Name
+	}
+	return ""
+}
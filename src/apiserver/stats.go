@@ -0,0 +1,145 @@
+package apiserver
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  エンドポイント関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//GetRollup 時間・日単位の集計を返す公開API（archiverがアーカイブした日のみ）
+func GetRollup(w rest.ResponseWriter, r *rest.Request) {
+	//パース
+	r.ParseForm()
+	params := r.Form
+	area := params.Get("area")
+	spot := params.Get("spot")
+	granularity := rdb.Granularity(params.Get("granularity"))
+	if granularity == "" {
+		granularity = rdb.GranularityHour
+	}
+	if area == "" || spot == "" {
+		writeError(w, errInvalidParameter("集計の検索の際にはareaとspotの両方を指定する必要があります"))
+		return
+	}
+	var maxRange time.Duration
+	switch granularity {
+	case rdb.GranularityHour:
+		maxRange = MaxHourlyRollupRange
+	case rdb.GranularityDay:
+		maxRange = MaxDailyRollupRange
+	default:
+		writeError(w, errInvalidParameter("granularityにはhourかdayを指定してください"))
+		return
+	}
+	fromTime, toTime, err := parseRangeParams(params.Get("from"), params.Get("to"), maxRange)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	//検索（toの時刻も含める）
+	option := rdb.SearchOptions{Area: area, Spot: spot, From: fromTime, To: toTime.Add(time.Second)}.
+		Where(rdb.Equal(rdb.ColumnGranularity, string(granularity))).
+		Sort(rdb.Asc(rdb.ColumnTime))
+	rollups, err := Store.SearchRollups(option)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorf("GetRollup SearchRollupsでエラー : %v", err)
+		writeError(w, errInternal("集計の検索に失敗しました"))
+		return
+	}
+
+	//JSON構造体に変換
+	jBody := static.JRollupBody{Area: area, Spot: spot, Granularity: string(granularity), Items: []static.JRollup{}}
+	for _, rollup := range rollups {
+		item := static.JRollup{
+			Datetime:     rollup.Time.Format(JsonTimeLayout),
+			Min:          rollup.Min,
+			Max:          rollup.Max,
+			Avg:          math.Round(rollup.Avg*100) / 100,
+			Last:         rollup.Last,
+			Samples:      rollup.Samples,
+			EmptyMinutes: rollup.EmptyMinutes,
+		}
+		if rollup.Capacity > 0 {
+			full := rollup.FullMinutes
+			item.FullMinutes = &full
+		}
+		jBody.Items = append(jBody.Items, item)
+	}
+	jBody.Num = len(jBody.Items)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
+
+//GetForecast 過去の同じ曜日・時刻の台数から指定時刻の台数を予測する公開API
+func GetForecast(w rest.ResponseWriter, r *rest.Request) {
+	//パース
+	r.ParseForm()
+	params := r.Form
+	area := params.Get("area")
+	spot := params.Get("spot")
+	if area == "" || spot == "" || params.Get("at") == "" {
+		writeError(w, errInvalidParameter("予測の際にはarea, spot, atを指定する必要があります"))
+		return
+	}
+	at, err := parseDatetimeParam(params.Get("at"), false)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	master, err := GetSpotmasterFromCache(area, spot)
+	if err != nil {
+		writeError(w, errNotFound("有効なスポットが見つかりません(area=%s, spot=%s)", area, spot))
+		return
+	}
+
+	//予測
+	setting := currentConf().API
+	weeks := setting.ForecastWeeks
+	window := time.Duration(setting.ForecastWindow) * time.Minute
+	forecast, err := rdb.ForecastCount(Store, area, spot, at, weeks, window)
+	if errors.Is(err, rdb.ErrNoSamples) {
+		writeError(w, errNotFound("予測に使える過去の台数がありません"))
+		return
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Errorf("GetForecast ForecastCountでエラー : %v", err)
+		writeError(w, errInternal("台数の予測に失敗しました"))
+		return
+	}
+	//ラック数が分かっていればそれ以上にはならない
+	if master.Capacity > 0 {
+		forecast.Upper = math.Min(forecast.Upper, float64(master.Capacity))
+	}
+
+	//JSON構造体に変換
+	jBody := static.JForecast{
+		Area:        master.Area,
+		Spot:        master.Spot,
+		Name:        master.Name,
+		Datetime:    at.Format(JsonTimeLayout),
+		Count:       math.Round(forecast.Count*10) / 10,
+		Lower:       math.Round(forecast.Lower*10) / 10,
+		Upper:       math.Round(forecast.Upper*10) / 10,
+		Probability: math.Round(forecast.Probability*100) / 100,
+		Samples:     []static.Recent{},
+	}
+	for _, sample := range forecast.Samples {
+		jBody.Samples = append(jBody.Samples,
+			static.Recent{Count: strings.TrimSpace(sample.Count), Datetime: sample.Time.Format(JsonTimeLayout)})
+	}
+	jBody.Num = len(jBody.Samples)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
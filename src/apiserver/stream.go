@@ -0,0 +1,267 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：台数の変化をServer-Sent Eventsで配信する
+//
+//　saveSpotinfoで保存した台数を直前の台数と比較し、変わっていたら購読者に送る
+//　送ったイベントは直近streamBufferSize件だけ保持し、Last-Event-IDで再接続したときに再送する
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	//streamBufferSize 再送用に保持するイベント数
+	streamBufferSize = 1000
+	//streamChannelSize 購読者ごとの送信待ちの上限（溢れたら切断し再接続してもらう）
+	streamChannelSize = 64
+	//streamHeartbeat 接続維持のためのコメントを送る間隔
+	streamHeartbeat = 30 * time.Second
+	//streamRetry 切断されたときにクライアントが再接続するまでの時間（ミリ秒）
+	streamRetry = 3000
+)
+
+const (
+	//streamEventCount 台数が変わった
+	streamEventCount = "count"
+	//streamEventReset 再送できないイベントがあるので/placesを取り直す必要がある
+	streamEventReset = "reset"
+)
+
+//streamEvent 配信するイベント
+type streamEvent struct {
+	ID   uint64
+	Key  string
+	Data static.JStreamEvent
+}
+
+//streamSubscriber 購読者
+type streamSubscriber struct {
+	//places 購読するスポット（area-spot、空なら全スポット）
+	places map[string]bool
+	events chan streamEvent
+}
+
+//streamHub 台数の変化のpub/sub
+type streamHub struct {
+	mu          sync.Mutex
+	nextID      uint64
+	buffer      []streamEvent
+	subscribers map[*streamSubscriber]bool
+	//last スポットごとの直前の台数
+	last map[string]rdb.Spotinfo
+	//closed 終了するので購読を受け付けない
+	closed bool
+}
+
+//Hub 台数の変化の配信
+var Hub = newStreamHub()
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  エンドポイント関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//GetStream 台数の変化をServer-Sent Eventsで配信する公開API
+func GetStream(w rest.ResponseWriter, r *rest.Request) {
+	writer, ok := w.(http.ResponseWriter)
+	flusher, canFlush := w.(http.Flusher)
+	if !ok || !canFlush {
+		writeError(w, errInternal("ストリーミングに対応していません"))
+		return
+	}
+	//パース
+	r.ParseForm()
+	places := make(map[string]bool)
+	for _, place := range strings.Split(r.Form.Get("places"), ",") {
+		if place = strings.TrimSpace(place); place != "" {
+			places[place] = true
+		}
+	}
+	var lastID uint64
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		lastID, _ = strconv.ParseUint(id, 10, 64)
+	}
+
+	sub, backlog, complete := Hub.Subscribe(places, lastID)
+	defer Hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	//nginxのバッファリングを止める
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(writer, "retry: %d\n\n", streamRetry)
+	if !complete {
+		fmt.Fprintf(writer, "event: %s\ndata: {}\n\n", streamEventReset)
+	}
+	for _, event := range backlog {
+		writeStreamEvent(writer, event)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.events:
+			if !ok {
+				//送信が追いつかず切断された
+				return
+			}
+			writeStreamEvent(writer, event)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(writer, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//newStreamHub 作成（IDは再起動しても前回より大きくなるよう起動時刻から始める）
+func newStreamHub() *streamHub {
+	return &streamHub{
+		nextID:      uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		subscribers: make(map[*streamSubscriber]bool),
+		last:        make(map[string]rdb.Spotinfo),
+	}
+}
+
+//writeStreamEvent イベントを1件書き込む
+func writeStreamEvent(w http.ResponseWriter, event streamEvent) {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, streamEventCount, data)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Seed 直前の台数を設定する（起動時に最新の台数を入れておき、再起動直後に全スポットを配信しないようにする）
+func (h *streamHub) Seed(currents []rdb.CurrentFull) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, current := range currents {
+		key := strings.TrimSpace(current.Area) + "-" + strings.TrimSpace(current.Spot)
+		h.last[key] = rdb.Spotinfo{Area: current.Area, Spot: current.Spot, Time: current.Time, Count: current.Count}
+	}
+}
+
+//Publish 保存した台数を直前の台数と比較し、変わっていれば配信する
+func (h *streamHub) Publish(rows []rdb.Spotinfo) {
+	sorted := append([]rdb.Spotinfo{}, rows...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, row := range sorted {
+		area, spot, count := strings.TrimSpace(row.Area), strings.TrimSpace(row.Spot), strings.TrimSpace(row.Count)
+		key := area + "-" + spot
+		prev, found := h.last[key]
+		//古いデータの再送は無視する
+		if found && !row.Time.After(prev.Time) {
+			continue
+		}
+		h.last[key] = row
+		if found && strings.TrimSpace(prev.Count) == count {
+			continue
+		}
+		h.nextID++
+		event := streamEvent{ID: h.nextID, Key: key, Data: static.JStreamEvent{
+			Area: area, Spot: spot, Count: count, Previous: strings.TrimSpace(prev.Count),
+			Datetime: row.Time.Format(JsonTimeLayout)}}
+		h.buffer = append(h.buffer, event)
+		if len(h.buffer) > streamBufferSize {
+			h.buffer = h.buffer[len(h.buffer)-streamBufferSize:]
+		}
+		for sub := range h.subscribers {
+			if len(sub.places) > 0 && !sub.places[key] {
+				continue
+			}
+			select {
+			case sub.events <- event:
+			default:
+				//送信が追いつかない購読者は切断する（Last-Event-IDで再接続してもらう）
+				logger.Warnf("streamHub 送信待ちが溢れたため購読者を切断しました")
+				delete(h.subscribers, sub)
+				close(sub.events)
+			}
+		}
+	}
+}
+
+//Subscribe 購読を開始し、lastIDより後の保持しているイベントを返す
+//lastIDより後のイベントがすでに破棄されている場合はcomplete = false
+func (h *streamHub) Subscribe(places map[string]bool, lastID uint64) (sub *streamSubscriber, backlog []streamEvent, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub = &streamSubscriber{places: places, events: make(chan streamEvent, streamChannelSize)}
+	if h.closed {
+		//すぐに切断する
+		close(sub.events)
+		return sub, nil, true
+	}
+	h.subscribers[sub] = true
+	complete = true
+	if lastID == 0 {
+		return
+	}
+	if len(h.buffer) == 0 || h.buffer[0].ID > lastID+1 {
+		//再起動した、または古すぎる
+		complete = lastID == h.nextID
+		return
+	}
+	for _, event := range h.buffer {
+		if event.ID <= lastID || (len(places) > 0 && !places[event.Key]) {
+			continue
+		}
+		backlog = append(backlog, event)
+	}
+	return
+}
+
+//Close 全ての購読者を切断し、これ以上購読を受け付けない（終了するときに使う）
+func (h *streamHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+//Unsubscribe 購読を終了する
+func (h *streamHub) Unsubscribe(sub *streamSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[sub] {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
@@ -0,0 +1,180 @@
+package apiserver
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：/v2/ の公開API
+//
+//　パラメータと検索はv1と同じ（search.go）で、レスポンスの型だけが違う
+//　台数・距離は整数、緯度経度は小数、時刻はLocationのタイムゾーン付きのRFC 3339で返す
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  エンドポイント関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//GetCountsV2 台数を返す公開API（v2）
+func GetCountsV2(w rest.ResponseWriter, r *rest.Request) {
+	r.ParseForm()
+	result, err := searchCounts(r.Form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	master := result.master
+	jBody := static.JCountsBodyV2{Area: master.Area, Spot: master.Spot, Name: master.Name,
+		Description: master.Description, Lat: coordinateV2(master.Lat), Lon: coordinateV2(master.Lon),
+		Capacity: capacityV2(master.Capacity), Counts: []static.JCountV2{}}
+	for _, row := range result.rows {
+		count, ok := countV2(row.Count)
+		if !ok {
+			continue
+		}
+		jBody.Counts = append(jBody.Counts,
+			static.JCountV2{Count: count, Time: timeV2(row.Time), Name: result.nameAt(row.Time)})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
+
+//GetPlacesV2 スポットマスタを返す公開API（v2）
+func GetPlacesV2(w rest.ResponseWriter, r *rest.Request) {
+	r.ParseForm()
+	views, closed, err := searchPlaces(r.Form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	jBody := static.JPlacesBodyV2{Items: []static.JPlaceV2{}}
+	for _, view := range views {
+		jBody.Items = append(jBody.Items, placeV2(view))
+	}
+	for _, master := range closed {
+		jBody.Items = append(jBody.Items, masterPlaceV2(master))
+	}
+	jBody.Num = len(jBody.Items)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
+
+//GetAllPlacesV2 全てのスポットマスタを返す公開API（v2）
+func GetAllPlacesV2(w rest.ResponseWriter, r *rest.Request) {
+	r.ParseForm()
+	masters, err := searchAllPlaces(r.Form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	jBody := static.JAllPlacesBodyV2{Items: []static.JAllPlaceV2{}}
+	for _, master := range masters {
+		jBody.Items = append(jBody.Items, static.JAllPlaceV2{Area: master.Area, Spot: master.Spot,
+			Name: master.Name, Endtime: endtimeV2(master.Endtime)})
+	}
+	jBody.Num = len(jBody.Items)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
+
+//GetPlaceSuggestionsV2 名前か最寄り駅の前方一致でスポットの候補を返す公開API（v2）
+func GetPlaceSuggestionsV2(w rest.ResponseWriter, r *rest.Request) {
+	r.ParseForm()
+	masters, err := searchSuggestions(r.Form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	jBody := static.JPlacesBodyV2{Items: []static.JPlaceV2{}}
+	for _, master := range masters {
+		jBody.Items = append(jBody.Items, masterPlaceV2(master))
+	}
+	jBody.Num = len(jBody.Items)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
+
+//GetDistancesV2 距離を返す公開API（v2）
+func GetDistancesV2(w rest.ResponseWriter, r *rest.Request) {
+	r.ParseForm()
+	distances, err := searchDistances(r.Form)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	jBody := static.JDistancesBodyV2{Items: []static.JDistanceV2{}}
+	for _, d := range distances {
+		jBody.Items = append(jBody.Items, static.JDistanceV2{JPlaceV2: placeV2(d.view), Meters: d.distance})
+	}
+	jBody.Num = len(jBody.Items)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteJson(jBody)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  その他関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//placeV2 有効なスポットをv2の型に変換する
+func placeV2(view rdb.CurrentFull) static.JPlaceV2 {
+	place := static.JPlaceV2{Area: view.Area, Spot: view.Spot, Name: view.Name, Description: view.Description,
+		Lat: coordinateV2(view.Lat), Lon: coordinateV2(view.Lon)}
+	if master, err := GetSpotmasterFromCache(view.Area, view.Spot); err == nil {
+		place.Capacity = capacityV2(master.Capacity)
+	}
+	if count, ok := countV2(view.Count); ok {
+		place.Recent = &static.RecentV2{Count: count, Time: timeV2(view.Time)}
+	}
+	return place
+}
+
+//masterPlaceV2 マスタだけでv2の型に変換する（台数なし、終了したスポットはendtimeあり）
+func masterPlaceV2(master rdb.Spotmaster) static.JPlaceV2 {
+	return static.JPlaceV2{Area: master.Area, Spot: master.Spot, Name: master.Name, Description: master.Description,
+		Lat: coordinateV2(master.Lat), Lon: coordinateV2(master.Lon), Capacity: capacityV2(master.Capacity),
+		Endtime: endtimeV2(master.Endtime)}
+}
+
+//timeV2 DBの時刻をタイムゾーン付きのRFC 3339にする
+func timeV2(t time.Time) string {
+	return dbTime(t).Format(time.RFC3339)
+}
+
+//endtimeV2 終了日時（なければnil）
+func endtimeV2(t time.Time) *string {
+	if t.IsZero() {
+		return nil
+	}
+	s := timeV2(t)
+	return &s
+}
+
+//countV2 台数を数値にする
+func countV2(count string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	return n, err == nil
+}
+
+//coordinateV2 緯度経度を数値にする（不明ならnil）
+func coordinateV2(value string) *float64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+//capacityV2 ラック数（不明ならnil）
+func capacityV2(capacity int) *int {
+	if capacity <= 0 {
+		return nil
+	}
+	return &capacity
+}
@@ -1,16 +1,18 @@
-package main
+package archiver
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
-	"runtime"
 	"time"
 
+	"github.com/8245snake/bikeshare_api/src/lib/command"
+	"github.com/8245snake/bikeshare_api/src/lib/config"
 	"github.com/8245snake/bikeshare_api/src/lib/filer"
 	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/metrics"
 	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/8245snake/bikeshare_api/src/lib/shutdown"
 	"github.com/carlescere/scheduler"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -18,14 +20,21 @@ import (
 //
 //　機能：1. postgresのデータを日毎にSQLiteに変換する
 //　　　　2. postgresの古いデータを削除する
+//　　　　3. スポットごとの時間・日単位の集計（rollup）を作成する
+//
+//　SIGTERMを受けたら書き込み中のバッチを終えてから中断する（その日の分は次回やり直す）
 //
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-//ini_section iniのセクション
-const ini_section = "ARCHIVE"
+//Config archiverの設定
+type Config struct {
+	DB      config.DB      `section:"DB"`
+	Log     config.Log     `section:"LOG"`
+	Archive config.Archive `section:"ARCHIVE"`
+}
 
-//sql_key SQLで日付検索するキー部分
-const sql_key = "to_char(date(time),'YYYY-MM-DD')"
+//conf 起動時に読み込んだ設定
+var conf Config
 
 //max_insert バルクインサートの最大件数
 var max_insert int
@@ -36,62 +45,110 @@ var delete_interval int
 //archive_time アーカイブ実行時刻
 var archive_time string
 
-//RunArchive postgresから検索してSQLiteに保存しpostgresから削除する
-func RunArchive() {
+//jobs 実行中のアーカイブと削除（終了するときに待つ）
+var jobs shutdown.Jobs
+
+//メトリクス
+var (
+	//archiveDuration アーカイブ1回の処理時間
+	archiveDuration = metrics.NewHistogram("bikeshare_archive_duration_seconds", "アーカイブ1回の処理時間（秒）",
+		[]float64{60, 300, 600, 1800, 3600, 7200, 14400})
+	//archiveRows 移動・削除した行数（operationはarchived, analyze_deleted, spotinfo_deleted）
+	archiveRows = metrics.NewCounter("bikeshare_archive_rows_total", "アーカイブで移動・削除した行数", "operation")
+	//archiveErrors 失敗した回数（jobはarchiveまたはdelete_old）
+	archiveErrors = metrics.NewCounter("bikeshare_archive_errors_total", "アーカイブの処理に失敗した回数", "job")
+	//archiveLastSuccess 最後に全ての対象日を処理できた日時
+	archiveLastSuccess = metrics.NewGauge("bikeshare_archive_last_success_timestamp_seconds", "最後にアーカイブが成功した日時（UNIX時間）")
+)
+
+//RunArchive liveのStoreから検索してSQLiteに保存しliveから削除する
+//ctxがキャンセルされたら次の対象日・バッチに進まずに終わる
+func RunArchive(ctx context.Context) {
 	logger.Debugf("RunArchive_start")
-	db_psql, err := rdb.GetConnectionPsql()
+	defer archiveDuration.ObserveSince(time.Now())
+	store, err := rdb.OpenStore()
 	if err != nil {
+		archiveErrors.Inc("archive")
 		return
 	}
-	defer db_psql.Close()
-	//対象日を取得
-	sql := "select " +
-		sql_key +
-		" from public.analyze" +
-		" where date(time) <= date(CURRENT_TIMESTAMP - INTERVAL '2 DAY') group by date(time)"
-	rows, err := db_psql.Query(sql)
+	defer store.Close()
+	//対象日を取得（2日前以前）
+	today := time.Now()
+	border := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location()).AddDate(0, 0, -1)
+	dates, err := store.SearchAnalyzeDates(border)
 	if err != nil {
+		archiveErrors.Inc("archive")
 		return
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var value string
-		_ = rows.Scan(&value)
-		logger.Debugf("RunArchive アーカイブ対象日=%s", value)
-		targetdate, err := time.Parse(filer.ModTimeLayout("yyyy-mm-dd"), value)
+	succeeded := true
+	for _, targetdate := range dates {
+		if ctx.Err() != nil {
+			logger.Infof("RunArchive 終了するため残りの対象日は次回アーカイブします")
+			return
+		}
+		logger.Debugf("RunArchive アーカイブ対象日=%s", targetdate.Format(filer.ModTimeLayout("yyyy-mm-dd")))
+		builder := rdb.NewRollupBuilder(targetdate, capacities(store))
+		err = insert(ctx, store, targetdate, builder)
+		if err == context.Canceled {
+			//集計とanalyzeの削除はせず次回やり直す（SQLiteの重複は無視される）
+			logger.Infof("RunArchive 終了するため%sのアーカイブを中断しました", targetdate.Format(filer.ModTimeLayout("yyyy-mm-dd")))
+			return
+		}
 		if err != nil {
+			logger.Errorf("RunArchive insert失敗 : %v", err)
+			archiveErrors.Inc("archive")
+			succeeded = false
 			continue
 		}
-		err = insert(db_psql, targetdate)
+		logger.Debugf("RunArchive insert成功")
+		//集計を保存（失敗したらanalyzeを残して次回やり直す）
+		rollups := builder.Build()
+		err = store.UpsertRollups(rollups)
 		if err != nil {
-			logger.Debugf("RunArchive insert失敗 : %v", err)
+			logger.Errorf("RunArchive 集計の保存に失敗 : %v", err)
+			archiveErrors.Inc("archive")
+			succeeded = false
 			continue
 		}
-		logger.Debugf("RunArchive insert成功")
-		err = delete(db_psql, targetdate)
+		logger.Debugf("RunArchive 集計を%d件保存しました", len(rollups))
+		err = delete(store, targetdate)
 		if err != nil {
-			logger.Debugf("RunArchive delete失敗 : %v", err)
+			logger.Errorf("RunArchive delete失敗 : %v", err)
+			archiveErrors.Inc("archive")
+			succeeded = false
 			continue
 		}
 		logger.Debugf("RunArchive delete成功")
 	}
+	if succeeded {
+		archiveLastSuccess.SetToCurrentTime()
+	}
 	logger.Debugf("RunArchive_end")
 }
 
-//insert SQLiteに保存
-func insert(db *sql.DB, targetdate time.Time) error {
-	//postgresから検索
-	qry := fmt.Sprintf("SELECT time, trim(area), trim(spot), trim(count) FROM public.analyze where %s = '%s'",
-		sql_key, targetdate.Format(filer.ModTimeLayout("yyyy-mm-dd")))
-	rows, err := db.Query(qry)
+//capacities スポットごとのラック数（キーはarea-spot）
+func capacities(store rdb.Store) map[string]int {
+	result := make(map[string]int)
+	masters, err := store.SearchSpotmaster(rdb.SearchOptions{}.Where(rdb.IsNull(rdb.ColumnEndtime)))
 	if err != nil {
-		return err
+		logger.Errorf("capacities SearchSpotmasterでエラー : %v", err)
+		return result
 	}
-	defer rows.Close()
+	for _, m := range masters {
+		if m.Capacity > 0 {
+			result[m.Area+"-"+m.Spot] = m.Capacity
+		}
+	}
+	return result
+}
 
+//insert SQLiteに保存（読み込んだデータは集計にも渡す）
+//ctxがキャンセルされたら書き込み中のバッチを終えてからcontext.Canceledを返す
+//保存に失敗したバッチがあれば最後まで保存してから最初のエラーを返す（呼び出し元は集計とanalyzeの削除をしない）
+func insert(ctx context.Context, store rdb.Store, targetdate time.Time, builder *rdb.RollupBuilder) error {
 	//SQLiteに接続
-	sqlite, err := rdb.GetConnectionSQLite(targetdate, true)
+	sqlite, err := rdb.OpenArchiveStore(targetdate, true)
 	if err != nil {
 		return err
 	}
@@ -100,107 +157,140 @@ func insert(db *sql.DB, targetdate time.Time) error {
 	var rowTried int64    //Insertしようとした件数
 	var rowAffected int64 //実際にInsertされた件数
 	var result int64      //一時変数
+	var insertErr error   //最初に失敗したバッチのエラー（残りのバッチは続けて保存する）
 	var rows_sqlite []rdb.Spotinfo
-	for rows.Next() {
-		var e rdb.Spotinfo
-		err := rows.Scan(&e.Time, &e.Area, &e.Spot, &e.Count)
+	//1日分を一度に読むとメモリが足りないので1時間ずつ検索する
+	for hour := 0; hour < 24; hour++ {
+		if ctx.Err() != nil {
+			return context.Canceled
+		}
+		from := targetdate.Add(time.Duration(hour) * time.Hour)
+		rows, err := store.SearchAnalyze(rdb.SearchOptions{From: from, To: from.Add(time.Hour)}.Sort(rdb.Asc(rdb.ColumnTime)))
 		if err != nil {
-			continue
+			return err
 		}
-		rows_sqlite = append(rows_sqlite, e)
-		//インサート
-		if len(rows_sqlite) >= max_insert {
-			result, err = rdb.BulkInsertSpotinfo(sqlite, rows_sqlite)
-			if err != nil {
-				logger.Debugf("BulkInsertSpotinfoでエラー %v \n", err)
+		for _, row := range rows {
+			builder.Add(row.Area, row.Spot, row.Time, row.Count)
+			rows_sqlite = append(rows_sqlite, row.ToSpotinfo())
+			//インサート
+			if len(rows_sqlite) >= max_insert {
+				result, err = sqlite.BulkInsertSpotinfo(rows_sqlite)
+				if err != nil {
+					logger.Errorf("BulkInsertSpotinfoでエラー %v \n", err)
+					if insertErr == nil {
+						insertErr = fmt.Errorf("BulkInsertSpotinfo %d件目からのバッチでエラー : %v", rowTried+1, err)
+					}
+				}
+				rowAffected += result
+				rowTried += int64(len(rows_sqlite))
+				rows_sqlite = []rdb.Spotinfo{}
+				//CPU負荷がすごいので休ませる
+				if !shutdown.Sleep(ctx, 10*time.Second) {
+					return context.Canceled
+				}
 			}
-			rowAffected += result
-			rowTried += int64(len(rows_sqlite))
-			rows_sqlite = []rdb.Spotinfo{}
-			//CPU負荷がすごいので休ませる
-			time.Sleep(10 * time.Second)
 		}
 	}
 	//インサート
 	if len(rows_sqlite) > 0 {
-		result, err = rdb.BulkInsertSpotinfo(sqlite, rows_sqlite)
+		result, err = sqlite.BulkInsertSpotinfo(rows_sqlite)
 		if err != nil {
-			logger.Debugf("BulkInsertSpotinfoでエラー %v \n", err)
+			logger.Errorf("BulkInsertSpotinfoでエラー %v \n", err)
+			if insertErr == nil {
+				insertErr = fmt.Errorf("BulkInsertSpotinfo %d件目からのバッチでエラー : %v", rowTried+1, err)
+			}
 		} else {
 			rowAffected += result
 			rowTried += int64(len(rows_sqlite))
 		}
 		//CPU負荷がすごいので休ませる
-		time.Sleep(10 * time.Second)
+		shutdown.Sleep(ctx, 10*time.Second)
 	}
 
 	logger.Debugf("%d件のInsertを試行しました", rowTried)
 	logger.Debugf("%d件Insertされました", rowAffected)
-	return err
+	archiveRows.Add(float64(rowAffected), "archived")
+	return insertErr
 }
 
 //delete 指定日のデータを削除
-func delete(db *sql.DB, targetdate time.Time) error {
-	addWhere := fmt.Sprintf(" %s = '%s'", sql_key, targetdate.Format(filer.ModTimeLayout("yyyy-mm-dd")))
-	option := rdb.SearchOptions{AddWhere: addWhere}
-	RowsAffected, err := rdb.Delete(db, "public.analyze", option)
+func delete(store rdb.Store, targetdate time.Time) error {
+	option := rdb.SearchOptions{From: targetdate, To: targetdate.AddDate(0, 0, 1)}
+	RowsAffected, err := store.DeleteAnalyze(option)
 	logger.Debugf("analyzeから%d件削除されました。", RowsAffected)
+	archiveRows.Add(float64(RowsAffected), "analyze_deleted")
 	return err
 }
 
 //RunDeleteOld Spotinfoから古いデータを削除するメイン関数
 func RunDeleteOld() {
 	logger.Debugf("RunDeleteOld_start")
-	db_psql, err := rdb.GetConnectionPsql()
+	store, err := rdb.OpenStore()
 	if err != nil {
-		logger.Debugf("RunDeleteOld GetConnectionPsqlでエラー : %v", err)
+		logger.Errorf("RunDeleteOld OpenStoreでエラー : %v", err)
+		archiveErrors.Inc("delete_old")
+		return
 	}
-	defer db_psql.Close()
+	defer store.Close()
 	//開始
-	err = deleteOldRecords(db_psql)
+	err = deleteOldRecords(store)
 	if err != nil {
-		logger.Debugf("RunDeleteOld deleteOldRecordsでエラー : %v", err)
+		logger.Errorf("RunDeleteOld deleteOldRecordsでエラー : %v", err)
+		archiveErrors.Inc("delete_old")
 	}
 	logger.Debugf("RunDeleteOld_end")
 }
 
 //deleteOldRecords spotinfoから古いデータを削除
-func deleteOldRecords(db *sql.DB) error {
-	sqlwhere := fmt.Sprintf("time < (CURRENT_TIMESTAMP - INTERVAL '%d MINUTE')", delete_interval)
-	option := rdb.SearchOptions{AddWhere: sqlwhere}
-	RowsAffected, err := rdb.Delete(db, "spotinfo", option)
+func deleteOldRecords(store rdb.Store) error {
+	border := time.Now().Add(-1 * time.Duration(delete_interval) * time.Minute)
+	option := rdb.SearchOptions{To: border}
+	RowsAffected, err := store.DeleteSpotinfo(option)
 	logger.Debugf("spotinfoから%d件削除されました。", RowsAffected)
+	archiveRows.Add(float64(RowsAffected), "spotinfo_deleted")
 	return err
 }
 
-//loadConfig 設定を読み込む
-func loadConfig() {
-	max_insert = filer.GetIniDataInt(ini_section, "MAXROWS", 5000)
-	delete_interval = filer.GetIniDataInt(ini_section, "INTERVAL", 30)
-	archive_time = filer.GetIniData(ini_section, "START", "00:00")
+//applyConfig 読み込んだ設定を反映する
+func applyConfig() {
+	max_insert = conf.Archive.MaxRows
+	delete_interval = conf.Archive.Interval
+	archive_time = conf.Archive.Start
 	logger.Info("設定を読み込みました")
 	logger.Infof("MAXROWS=%d", max_insert)
 	logger.Infof("INTERVAL=%d", delete_interval)
 	logger.Infof("START=%s", archive_time)
 }
 
-func main() {
-	//初期化
-	err := filer.InitDirSetting()
-	if err != nil {
-		fmt.Printf("InitDirSettingでエラー : %v", err)
-		return
-	}
-	exeName := filer.GetExeName()
-	logger.Info(exeName, "開始")
+//Command archiveサブコマンド
+var Command = &command.Command{
+	Name:    "archive",
+	Exe:     "archiver",
+	Summary: "postgresのデータを日毎のSQLiteに移し、古いデータの削除と集計をする",
+	Daemon:  true,
+	Config:  &conf,
+	Run:     run,
+}
 
-	//設定ロード
-	loadConfig()
+//run 定期実行を登録し、ctxがキャンセルされたら実行中の処理を待って終了する
+func run(ctx context.Context, args []string) error {
+	applyConfig()
+
+	//メトリクス
+	if err := metrics.Serve(conf.Archive.MetricsAddr); err != nil {
+		return err
+	}
 
 	//開始
-	_, _ = scheduler.Every().Day().At(archive_time).Run(RunArchive)
-	_, _ = scheduler.Every(delete_interval).Minutes().Run(RunDeleteOld)
+	_, _ = scheduler.Every().Day().At(archive_time).Run(func() {
+		jobs.Run(func() { RunArchive(ctx) })
+	})
+	_, _ = scheduler.Every(delete_interval).Minutes().Run(func() {
+		jobs.Run(RunDeleteOld)
+	})
 
-	//終了させない
-	runtime.Goexit()
+	//SIGTERMかSIGINTを受けたら実行中の処理を待って終了する
+	<-ctx.Done()
+	jobs.Wait()
+	return nil
 }
@@ -0,0 +1,197 @@
+package main
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：全てのサービスをまとめた実行ファイル
+//
+//　使い方：bikeshare <サブコマンド> [--print-config] [引数]
+//　　　　　bikeshare run-all [--print-config] <サブコマンド>...
+//
+//　サブコマンドごとに共通の初期化（app.iniとロガー、設定の読み込み、SIGTERMとSIGINTの処理）をしてから実行する
+//　run-allは指定したサブコマンドを1つのプロセスで起動し、どれかが止まったら全て終了する
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/8245snake/bikeshare_api/src/apiserver"
+	"github.com/8245snake/bikeshare_api/src/archiver"
+	"github.com/8245snake/bikeshare_api/src/grapher"
+	"github.com/8245snake/bikeshare_api/src/importer"
+	"github.com/8245snake/bikeshare_api/src/lib/command"
+	"github.com/8245snake/bikeshare_api/src/lib/config"
+	"github.com/8245snake/bikeshare_api/src/lib/filer"
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/shutdown"
+	"github.com/8245snake/bikeshare_api/src/line"
+	"github.com/8245snake/bikeshare_api/src/migrate"
+	"github.com/8245snake/bikeshare_api/src/notify"
+	"github.com/8245snake/bikeshare_api/src/stationfiller"
+)
+
+const (
+	//exeName run-allのログファイル名
+	exeName = "bikeshare"
+	//runAll 複数のサブコマンドを1つのプロセスで起動するサブコマンド
+	runAll = "run-all"
+)
+
+//commands サブコマンドの一覧（usageに表示する順）
+var commands = []*command.Command{
+	apiserver.Command,
+	grapher.Command,
+	archiver.Command,
+	importer.Command,
+	stationfiller.Command,
+	notify.Command,
+	line.Command,
+	migrate.Command,
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//usage 使い方を書く
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "使い方：bikeshare <サブコマンド> [--print-config] [引数]")
+	fmt.Fprintln(w)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", c.Name, c.Summary)
+	}
+	fmt.Fprintf(w, "  %-14s %s\n", runAll, "指定したサブコマンドを1つのプロセスで起動する（"+strings.Join(daemonNames(), ", ")+"）")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "--print-config を付けると読み込んだ設定を表示して終了する（秘密の値は伏せる）")
+}
+
+//daemonNames run-allで起動できるサブコマンド
+func daemonNames() []string {
+	var names []string
+	for _, c := range commands {
+		if c.Daemon {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
+
+//newFlagSet 共通のフラグを登録したFlagSet（--print-configが指定されたかを返す）
+func newFlagSet(name, args string) (*flag.FlagSet, *bool) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	printConfig := fs.Bool("print-config", false, "設定を表示して終了する（秘密の値は伏せる）")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "使い方：bikeshare %s [--print-config] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs, printConfig
+}
+
+//runCommand 1つのサブコマンドを実行する
+func runCommand(c *command.Command, args []string) error {
+	fs, printConfig := newFlagSet(c.Name, c.Usage)
+	if c.Flags != nil {
+		c.Flags(fs)
+	}
+	fs.Parse(args)
+
+	//共通の初期化（app.iniを使わないサブコマンドは設定からロガーを初期化する）
+	if c.InitLogger == nil {
+		filer.SetExeName(c.Exe)
+		if err := filer.InitDirSetting(); err != nil {
+			return err
+		}
+	}
+	//設定の誤りがあれば起動しない
+	if err := config.Load(c.Config); err != nil {
+		return err
+	}
+	if *printConfig {
+		config.Print(os.Stdout, c.Config)
+		return nil
+	}
+	if c.InitLogger != nil {
+		if err := c.InitLogger(); err != nil {
+			return err
+		}
+	}
+	return c.Execute(shutdown.Context(), fs.Args())
+}
+
+//runSupervised run-allを実行する
+func runSupervised(args []string) error {
+	fs, printConfig := newFlagSet(runAll, "<サブコマンド>...")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("起動するサブコマンドを指定してください（%s）", strings.Join(daemonNames(), ", "))
+	}
+	var selected []*command.Command
+	for _, name := range fs.Args() {
+		c, ok := command.Find(commands, name)
+		if !ok || !c.Daemon {
+			return fmt.Errorf("%sはrun-allで起動できません（%s）", name, strings.Join(daemonNames(), ", "))
+		}
+		if _, dup := command.Find(selected, name); dup {
+			return fmt.Errorf("%sが2回指定されています", name)
+		}
+		selected = append(selected, c)
+	}
+
+	//共通の初期化（ロガーはapp.iniの[LOG]を全てのサブコマンドで共有する）
+	filer.SetExeName(exeName)
+	if err := filer.InitDirSetting(); err != nil {
+		return err
+	}
+	//どれかの設定に誤りがあればどれも起動しない
+	var errs []string
+	for _, c := range selected {
+		if err := config.Load(c.Config); err != nil {
+			errs = append(errs, fmt.Sprintf("%s : %v", c.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	if *printConfig {
+		w := os.Stdout
+		for _, c := range selected {
+			//どのサブコマンドの設定かをapp.iniのコメントとして書く
+			fmt.Fprintf(w, "; %s\n", c.Name)
+			config.Print(w, c.Config)
+		}
+		return nil
+	}
+	logger.Infof("%s %sを起動します", runAll, strings.Join(fs.Args(), ", "))
+	return command.Supervise(shutdown.Context(), selected)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	name, args := os.Args[1], os.Args[2:]
+	var err error
+	switch name {
+	case "help", "-h", "--help":
+		usage(os.Stdout)
+		return
+	case runAll:
+		err = runSupervised(args)
+	default:
+		c, ok := command.Find(commands, name)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "サブコマンド%sはありません\n\n", name)
+			usage(os.Stderr)
+			os.Exit(2)
+		}
+		err = runCommand(c, args)
+	}
+	if err != nil {
+		logger.Errorf("%v", err)
+		os.Exit(1)
+	}
+}
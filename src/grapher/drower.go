@@ -1,7 +1,6 @@
-package main
+package grapher
 
 import (
-	"database/sql"
 	"fmt"
 	"io/ioutil"
 	"math"
@@ -13,12 +12,14 @@ import (
 	"github.com/8245snake/bikeshare_api/src/lib/static"
 	"github.com/fogleman/gg"
 	"github.com/golang/freetype/truetype"
-	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/image/font"
 )
 
-//Db データベースコネクション
-var Db *sql.DB
+//Store データの保存先
+var Store rdb.Store
+
+//Masters 有効なスポットマスタのキャッシュ（グラフのタイトルに使う）
+var Masters = &rdb.MasterCache{}
 
 //ErrorImageName エラー画像
 const ErrorImageName = "error.png"
@@ -147,6 +148,13 @@ func (g *Graph) SetData(area, spot, day string) {
 	plot.Day = t.Day()
 	plot.ColorIndex = len(g.Plots)
 	plot.LegendCaption = fmt.Sprintf("%s (%s)", t.Format("2006/01/02"), WeekDays[t.Weekday()])
+	//名前が変わる前の日にはその日の終わりの時点の名前を付ける
+	if history, err := rdb.SearchSpotmasterHistory(Store, area, spot); err == nil && len(history) > 0 {
+		current := history[len(history)-1]
+		if past, _ := rdb.SpotmasterAt(history, t.AddDate(0, 0, 1).Add(-1*time.Second)); past.Name != current.Name {
+			plot.LegendCaption += " " + past.Name
+		}
+	}
 	g.Plots = append(g.Plots, plot)
 
 }
@@ -154,7 +162,7 @@ func (g *Graph) SetData(area, spot, day string) {
 //createPoints 指定日(yyyymmdd)のデータを検索しPoint構造体配列を作成する
 func createPoints(area, spot, day string) (points []Point, err error) {
 
-	spotinfos, err := rdb.SearchCountsByDay(Db, area, spot, day)
+	spotinfos, err := rdb.SearchCountsByDay(Store, area, spot, day)
 	if err != nil {
 		return points, err
 	}
@@ -168,11 +176,12 @@ func createPoints(area, spot, day string) (points []Point, err error) {
 
 //SetTitle グラフタイトルをセットする
 func (g *Graph) SetTitle(area, spot string) {
-	master, err := rdb.SearchSpotmaster(Db, rdb.SearchOptions{Area: area, Spot: spot})
-	if err != nil || len(master) < 1 {
+	//最新の版の名前を使う
+	history, err := rdb.SearchSpotmasterHistory(Store, area, spot)
+	if err != nil || len(history) < 1 {
 		return
 	}
-	name := master[0].Name
+	name := history[len(history)-1].Name
 	g.Title = fmt.Sprintf("[%s-%s] %s", area, spot, name)
 }
 
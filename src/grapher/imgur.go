@@ -1,14 +1,17 @@
-package main
+package grapher
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
+	"sync"
 
+	"github.com/8245snake/bikeshare_api/src/lib/config"
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
 	"github.com/8245snake/bikeshare_api/src/lib/rdb"
 	"github.com/mattn/go-scan"
 )
@@ -21,25 +24,18 @@ const (
 )
 
 var (
-	//Configs 設定
+	//configsMu ConfigsとImgurIDの読み込み直しとの排他
+	configsMu sync.RWMutex
+	//Configs 設定（DBのconfigテーブル）
 	Configs []rdb.ConfigDB
 	//ImgurID APIのキー
 	ImgurID string
 )
 
-//getConfig DBから設定を取得
+//getConfig DBから読み込んだ設定を取得
 func getConfig(key string) string {
-	if Configs == nil {
-		db, err := rdb.GetConnectionPsql()
-		if err != nil {
-			return ""
-		}
-		defer db.Close()
-		Configs, err = rdb.SearchConfig(db, rdb.SearchOptions{})
-		if err != nil {
-			return ""
-		}
-	}
+	configsMu.RLock()
+	defer configsMu.RUnlock()
 	for _, conf := range Configs {
 		if conf.Key == key {
 			return conf.Value
@@ -48,12 +44,42 @@ func getConfig(key string) string {
 	return ""
 }
 
-//UploadImgur 画像アップロード
-func UploadImgur(imgPath string) string {
+//getImgurID imgurのクライアントID
+func getImgurID() string {
+	configsMu.RLock()
+	defer configsMu.RUnlock()
+	return ImgurID
+}
+
+//loadConfigs DBのconfigテーブルを読み込み、imgurのクライアントIDを決める（失敗したら前のまま）
+//[GRAPHER] IMGUR_IDがなければconfigテーブルのimgur_idを使う
+func loadConfigs(setting config.Grapher) error {
+	configs, err := Store.SearchConfig(rdb.SearchOptions{})
+	if err != nil {
+		return err
+	}
+	id := setting.ImgurID
+	for _, conf := range configs {
+		if id == "" && conf.Key == "imgur_id" {
+			id = conf.Value
+		}
+	}
+	if id == "" {
+		return fmt.Errorf("imgur_idが設定されていません")
+	}
+	configsMu.Lock()
+	defer configsMu.Unlock()
+	Configs, ImgurID = configs, id
+	return nil
+}
+
+//UploadImgur 画像アップロード（ctxのリクエストIDを付けてログを書く）
+func UploadImgur(ctx context.Context, imgPath string) string {
+	log := logger.FromContext(ctx)
 
 	b, err := ioutil.ReadFile(imgPath)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "open:", err.Error())
+		log.Errorf("UploadImgur open: %v", err)
 		return ErrorImageURL
 	}
 	params := url.Values{"image": {base64.StdEncoding.EncodeToString(b)}}
@@ -62,15 +88,15 @@ func UploadImgur(imgPath string) string {
 
 	req, err := http.NewRequest("POST", endpoint, strings.NewReader(params.Encode()))
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "post:", err.Error())
+		log.Errorf("UploadImgur post: %v", err)
 		return ErrorImageURL
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Authorization", "Client-ID "+ImgurID)
+	req.Header.Set("Authorization", "Client-ID "+getImgurID())
 
 	res, err = http.DefaultClient.Do(req)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "post:", err.Error())
+		log.Errorf("UploadImgur post: %v", err)
 		return ErrorImageURL
 	}
 	if res.StatusCode != 200 {
@@ -79,7 +105,7 @@ func UploadImgur(imgPath string) string {
 		if err != nil {
 			message = res.Status
 		}
-		fmt.Fprintln(os.Stderr, "post:", message)
+		log.Errorf("UploadImgur post: %s", message)
 		return ErrorImageURL
 	}
 	defer res.Body.Close()
@@ -87,7 +113,7 @@ func UploadImgur(imgPath string) string {
 	var link string
 	err = scan.ScanJSON(res.Body, "data/link", &link)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "post:", err.Error())
+		log.Errorf("UploadImgur post: %v", err)
 		return ErrorImageURL
 	}
 	return link
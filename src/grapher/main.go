@@ -1,20 +1,28 @@
-package main
+package grapher
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/8245snake/bikeshare_api/src/lib/command"
+	"github.com/8245snake/bikeshare_api/src/lib/config"
 	"github.com/8245snake/bikeshare_api/src/lib/filer"
 	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/metrics"
+	"github.com/8245snake/bikeshare_api/src/lib/middleware"
 	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/8245snake/bikeshare_api/src/lib/reload"
+	"github.com/8245snake/bikeshare_api/src/lib/shutdown"
 	"github.com/8245snake/bikeshare_api/src/lib/static"
 	"github.com/ant0ine/go-json-rest/rest"
 )
@@ -33,6 +41,35 @@ const (
 	JsonTimeLayout              = "2006/01/02 15:04"
 )
 
+//Config grapherの設定
+type Config struct {
+	DB      config.DB      `section:"DB"`
+	Log     config.Log     `section:"LOG"`
+	Grapher config.Grapher `section:"GRAPHER"`
+}
+
+var (
+	//appConfigMu appConfigの読み込み直しとの排他
+	appConfigMu sync.RWMutex
+	//appConfig 設定（リクエストの処理ではcurrentConfigで読む、GetGraphのconfはグラフの設定）
+	appConfig Config
+	//startConfig 起動時の設定（再起動するまで反映されない設定の変更を調べる）
+	startConfig Config
+)
+
+//renderSlots 同時に描画できるグラフの数（[GRAPHER] MAX_RENDERS）
+//描画は重いため、空きがなければ待たせずに429を返す
+var renderSlots chan struct{}
+
+//メトリクス
+var (
+	//renderDuration グラフの描画時間
+	renderDuration = metrics.NewHistogram("bikeshare_graph_render_duration_seconds", "グラフの描画時間（秒）",
+		[]float64{.1, .25, .5, 1, 2.5, 5, 10, 30})
+	//renderRejected 描画の枠が空いていなかったため断ったリクエスト数
+	renderRejected = metrics.NewCounter("bikeshare_graph_render_rejected_total", "描画の枠が空いていなかったため断ったリクエスト数")
+)
+
 //GraphConfig グラフリクエスト情報
 type GraphConfig struct {
 	Area         string
@@ -125,6 +162,7 @@ func createTitle(area, spot, name string) string {
 
 //drawGraphImage グラフ作成
 func drawGraphImage(conf *GraphConfig, fileName string, title string) {
+	defer renderDuration.ObserveSince(time.Now())
 	graph := NewGraph(conf.Width, conf.Height, conf.MarginLeft, conf.MarginRight, conf.MarginTop, conf.MarginBottom)
 	for _, day := range conf.Days {
 		graph.SetData(conf.Area, conf.Spot, day)
@@ -135,6 +173,26 @@ func drawGraphImage(conf *GraphConfig, fileName string, title string) {
 	graph.Draw(fileName)
 }
 
+//searchCurrent 有効なスポットのマスタと最新の台数を探す
+//マスタはキャッシュから引き、キャッシュを読み込んだ後に追加されたスポットはcurrent_fullから探す
+func searchCurrent(area, spot string) (rdb.CurrentFull, bool, error) {
+	master, ok := Masters.Find(area, spot)
+	if !ok {
+		fulldata, err := Store.SearchCurrentFull(rdb.SearchOptions{Area: area, Spot: spot})
+		if err != nil || len(fulldata) < 1 {
+			return rdb.CurrentFull{}, false, err
+		}
+		return fulldata[0], true, nil
+	}
+	latest, err := Store.SearchSpotinfo(rdb.SearchOptions{Area: area, Spot: spot, Limit: 1}.Sort(rdb.Desc(rdb.ColumnTime)))
+	if err != nil || len(latest) < 1 {
+		return rdb.CurrentFull{}, false, err
+	}
+	return rdb.CurrentFull{Area: master.Area, Spot: master.Spot, Name: master.Name, Count: latest[0].Count,
+		Time: latest[0].Time, Lat: master.Lat, Lon: master.Lon, Description: master.Description,
+		Station: master.Station}, true, nil
+}
+
 //GetGraph グラフ作成
 func GetGraph(w rest.ResponseWriter, r *rest.Request) {
 	//パース
@@ -142,40 +200,58 @@ func GetGraph(w rest.ResponseWriter, r *rest.Request) {
 	param := r.Form
 	conf, err := LoadGraphConfig(&param)
 	if err != nil {
-		w.WriteJson(err.Error())
+		middleware.WriteError(w, http.StatusBadRequest, static.ErrorInvalidParameter, err.Error())
 		return
 	}
 
 	//先にファイル名やタイトルを決定しておく
-	var spotFullData rdb.CurrentFull
-	if fulldata, err := rdb.SearchCurrentFull(Db, rdb.SearchOptions{Area: conf.Area, Spot: conf.Spot}); err != nil || len(fulldata) < 1 {
-		w.WriteJson(err.Error())
+	spotFullData, found, err := searchCurrent(conf.Area, conf.Spot)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorf("GetGraph searchCurrentでエラー : %v", err)
+		middleware.WriteError(w, http.StatusInternalServerError, static.ErrorInternal, "DBの検索に失敗しました")
+		return
+	} else if !found {
+		//終了したスポットは現在の台数がない
+		middleware.WriteError(w, http.StatusNotFound, static.ErrorNotFound, "有効なスポットが見つかりません")
 		return
-	} else {
-		spotFullData = fulldata[0]
 	}
 	fileName := createImgName(conf.Area, conf.Spot)
 	title := createTitle(conf.Area, conf.Spot, spotFullData.Name)
 
+	//描画の枠を確保する（描画が終わったら返す）
+	select {
+	case renderSlots <- struct{}{}:
+	default:
+		renderRejected.Inc()
+		middleware.WriteRateLimited(w, 1)
+		return
+	}
+	release := func() { <-renderSlots }
+
 	//URLを取得
 	var link string
 	if conf.UploadImgur {
 		//imgurにアップロードする（同期）
 		drawGraphImage(&conf, fileName, title)
+		release()
 		path := filepath.Join(static.DirImage, fileName)
-		link = UploadImgur(path)
+		link = UploadImgur(r.Context(), path)
 		os.Remove(path)
 	} else {
 		//ローカルのファイルを見せる
 		if conf.EarlyReturn {
 			//非同期
-			go drawGraphImage(&conf, fileName, title)
+			go func() {
+				defer release()
+				drawGraphImage(&conf, fileName, title)
+			}()
 		} else {
 			//同期
 			drawGraphImage(&conf, fileName, title)
+			release()
 		}
 
-		link = "https://hanetwi.ddns.net/bikeshare/graph/img/" + fileName
+		link = currentConfig().Grapher.ImageURL + fileName
 	}
 
 	//URLを返却
@@ -208,6 +284,9 @@ func handleFile(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		body, err = serveErrorImage(err.Error(), fileName)
 		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(static.JError{Result: static.ResultFailed, Code: static.ErrorNotFound, Reason: "画像が見つかりません"})
 			return
 		}
 	}
@@ -257,51 +336,82 @@ func serveErrorImage(errString string, fileName string) ([]byte, error) {
 	return body, nil
 }
 
-func init() {
-	//初期化
-	err := filer.InitDirSetting()
+//Command serve-graphサブコマンド
+var Command = &command.Command{
+	Name:    "serve-graph",
+	Exe:     "grapher",
+	Summary: "台数のグラフを描画するサーバ",
+	Daemon:  true,
+	Config:  &appConfig,
+	Run:     serve,
+}
+
+//serve 初期化してサーバを開始する（ctxがキャンセルされたら処理中のリクエストと描画を待って終了する）
+func serve(ctx context.Context, args []string) error {
+	startConfig = appConfig
+	var err error
+	Store, err = rdb.OpenStore()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	ImgurID = getConfig("imgur_id")
-	if ImgurID == "" {
-		panic("imgur_idが設定されていません")
+	defer Store.Close()
+	if err := loadConfigs(appConfig.Grapher); err != nil {
+		return err
 	}
-	Db, err = rdb.GetConnectionPsql()
-	if err != nil {
-		panic(err)
+	if _, err := Masters.Load(Store); err != nil {
+		return err
 	}
-}
-
-func main() {
-	exeName := filer.GetExeName()
-	logger.Info(exeName, "開始")
-	defer logger.Info(exeName, "終了")
+	renderSlots = make(chan struct{}, appConfig.Grapher.MaxRenders)
 
 	api := rest.NewApi()
 	api.Use(rest.DefaultDevStack...)
-	api.Use(&rest.CorsMiddleware{
-		RejectNonCorsRequests: false,
-		OriginValidator: func(origin string, request *rest.Request) bool {
-			return true
-		},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
-		AllowedHeaders: []string{
-			"Accept", "Content-Type", "X-Custom-Header", "Origin"},
-		AccessControlAllowCredentials: true,
-		AccessControlMaxAge:           3600,
-	})
-	router, err := rest.MakeRouter(
+	//エラーのレスポンスにもリクエストIDを付けるため先に入れる
+	api.Use(&middleware.RequestIDMiddleware{})
+	//ルーターの404/405やCORSの403、panicもapiserverと同じエラーの形式にする
+	api.Use(&middleware.ErrorMiddleware{})
+	api.Use(middleware.NewCorsMiddleware(appConfig.Grapher.CorsOrigins))
+	//IPアドレスごとにリクエスト数を制限する
+	ipLimit := middleware.Limit{PerMinute: appConfig.Grapher.RateLimitIP, Burst: appConfig.Grapher.RateLimitIPBurst}
+	api.Use(middleware.NewRateLimitMiddleware(ipLimit, appConfig.Grapher.TrustProxy))
+	routes := []*rest.Route{
 		rest.Get("/graph", GetGraph),
-	)
+	}
+	middleware.InstrumentRoutes(routes)
+	router, err := rest.MakeRouter(routes...)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	api.SetApp(router)
 
-	//ハンドラ追加
-	http.Handle("/", api.MakeHandler())
-	http.Handle("/graph/img/", http.HandlerFunc(handleFile))
-	//サーバ開始
-	log.Fatal(http.ListenAndServe(":5010", nil))
+	//ハンドラ追加（run-allでは他のサーバと同じプロセスなのでDefaultServeMuxは使わない）
+	mux := http.NewServeMux()
+	mux.Handle("/", api.MakeHandler())
+	mux.Handle("/graph/img/", http.HandlerFunc(handleFile))
+	//メトリクスは公開用とは別のポートで待ち受ける
+	if err := metrics.Serve(appConfig.Grapher.MetricsAddr); err != nil {
+		return err
+	}
+	//SIGHUPとRELOAD_INTERVAL毎に設定とDBの設定を読み込み直す
+	reload.New(reloadAll).Watch(ctx, time.Duration(appConfig.Grapher.ReloadInterval)*time.Minute)
+	//サーバ開始（終了するときは処理中のリクエストと非同期の描画を待つ）
+	timeout := time.Duration(appConfig.Grapher.ShutdownTimeout) * time.Second
+	err = shutdown.Serve(ctx, shutdown.NewServer(appConfig.Grapher.Port, mux), timeout)
+	if !waitRenders(timeout) {
+		logger.Warnf("描画中のグラフが%v以内に終わりませんでした", timeout)
+	}
+	return err
+}
+
+//waitRenders 描画中のグラフ（非同期のものも含む）が終わるのをtimeoutまで待つ
+//全ての枠を確保するので、この後は描画できない
+func waitRenders(timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for i := 0; i < cap(renderSlots); i++ {
+		select {
+		case renderSlots <- struct{}{}:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
 }
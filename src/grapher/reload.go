@@ -0,0 +1,63 @@
+package grapher
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/8245snake/bikeshare_api/src/lib/config"
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：設定の読み込み直し
+//
+//　SIGHUPでapp.iniの設定とDB（configテーブルとスポットマスタ）を、[GRAPHER] RELOAD_INTERVAL毎にDBだけを読み込み直す
+//　ポートや同時に描画できる数など起動時にしか使わない設定は再起動するまで反映されない
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//currentConfig 今の設定
+func currentConfig() Config {
+	appConfigMu.RLock()
+	defer appConfigMu.RUnlock()
+	return appConfig
+}
+
+//reloadAll reload.Funcの実装
+func reloadAll(settings bool) error {
+	var errs []string
+	if settings {
+		if err := reloadSettings(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if err := loadConfigs(currentConfig().Grapher); err != nil {
+		errs = append(errs, fmt.Sprintf("DBの設定を読み込めません : %v", err))
+	}
+	if _, err := Masters.Load(Store); err != nil {
+		errs = append(errs, fmt.Sprintf("マスタを読み込めません : %v", err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+//reloadSettings app.iniを読み込み直して設定を入れ替える（誤りがあれば今の設定のまま）
+func reloadSettings() error {
+	var next Config
+	if err := config.Reload(&next); err != nil {
+		return err
+	}
+	appConfigMu.Lock()
+	changed, _ := config.Changed(&appConfig, &next)
+	appConfig = next
+	appConfigMu.Unlock()
+	logger.Reconfigure(next.Log.Logger())
+	if len(changed) > 0 {
+		logger.Infof("reloadSettings 変更された設定 : %s", strings.Join(changed, ", "))
+	}
+	if _, pending := config.Changed(&startConfig, &next); len(pending) > 0 {
+		logger.Warnf("reloadSettings 再起動するまで反映されない設定 : %s", strings.Join(pending, ", "))
+	}
+	return nil
+}
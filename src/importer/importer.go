@@ -1,4 +1,4 @@
-package main
+package importer
 
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 //  概要：過去にバックアップしたCSVファイルをDBに戻す
@@ -8,18 +8,30 @@ package main
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 import (
-	"database/sql"
+	"context"
 	"encoding/csv"
 	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/8245snake/bikeshare_api/src/lib/command"
+	"github.com/8245snake/bikeshare_api/src/lib/config"
 	"github.com/8245snake/bikeshare_api/src/lib/filer"
 	"github.com/8245snake/bikeshare_api/src/lib/logger"
 	"github.com/8245snake/bikeshare_api/src/lib/rdb"
 )
 
+//Config importerの設定
+type Config struct {
+	DB     config.DB     `section:"DB"`
+	Log    config.Log    `section:"LOG"`
+	Import config.Import `section:"IMPORT"`
+}
+
+//conf 起動時に読み込んだ設定
+var conf Config
+
 var _colArea int
 var _colSpot int
 var _colTime int
@@ -27,14 +39,15 @@ var _colCount int
 var _readMax int
 var _timeFormatCsv string
 
-var Db *sql.DB
+//Store データの保存先
+var Store rdb.Store
 
 //execImport インポート
 func execImport(path string) error {
-	fmt.Printf("CSVを読み込みます %s\n", path)
+	logger.Infof("CSVを読み込みます %s", path)
 	file, err := os.Open(path)
 	if err != nil {
-		fmt.Printf("CSV読み込みでエラー error=%v\n", err)
+		logger.Errorf("CSV読み込みでエラー error=%v", err)
 		return err
 	}
 	defer file.Close()
@@ -42,7 +55,7 @@ func execImport(path string) error {
 	reader := csv.NewReader(file)
 	var line []string
 	var spotinfos []rdb.Analyze
-	fmt.Printf("インサート開始\n")
+	logger.Debugf("インサート開始")
 	//1行ずつ読み込みながら逐次実行する
 	for {
 		line, err = reader.Read()
@@ -59,7 +72,7 @@ func execImport(path string) error {
 		}
 		spotinfos = append(spotinfos, buff)
 		if len(spotinfos) >= _readMax {
-			err := rdb.BulkInsertAnalyze(Db, spotinfos)
+			err := Store.BulkInsertAnalyze(spotinfos)
 			if err != nil {
 				return err
 			}
@@ -68,12 +81,12 @@ func execImport(path string) error {
 	}
 	//ループを抜けたあとに残っていたら
 	if len(spotinfos) > 0 {
-		err := rdb.BulkInsertAnalyze(Db, spotinfos)
+		err := Store.BulkInsertAnalyze(spotinfos)
 		if err != nil {
 			return err
 		}
 	}
-	fmt.Printf("インサート終了\n")
+	logger.Debugf("インサート終了")
 	return nil
 }
 
@@ -86,42 +99,45 @@ func ConvertToSpotInfo(line []string) (rdb.Analyze, error) {
 	return rdb.Analyze{Area: line[_colArea], Spot: line[_colSpot], Time: datetime, Count: line[_colCount]}, nil
 }
 
-func main() {
-	//初期化
-	err := filer.InitDirSetting()
-	if err != nil {
-		return
-	}
-	exeName := filer.GetExeName()
-	logger.Info(exeName, "開始")
-	defer logger.Info(exeName, "終了")
+//Command importサブコマンド
+var Command = &command.Command{
+	Name:    "import",
+	Exe:     "importer",
+	Summary: "app/csvのCSVファイルをDBに戻す",
+	Config:  &conf,
+	Run:     run,
+}
 
-	//設定読み込み
-	section := "IMPORT"
-	_readMax = filer.GetIniDataInt(section, "MAXROWS", 5000)
-	_colArea = filer.GetIniDataInt(section, "COL_AREA", 1)
-	_colSpot = filer.GetIniDataInt(section, "COL_SPOT", 2)
-	_colTime = filer.GetIniDataInt(section, "COL_TIME", 0)
-	_colCount = filer.GetIniDataInt(section, "COL_COUNT", 3)
-	_timeFormatCsv = filer.ModTimeLayout(filer.GetIniData(section, "TIME_FORMAT", "yyyy-mm-dd HH:MM:SS"))
+//run app/csvのCSVファイルを順にインポートする（ctxがキャンセルされたら次のファイルに進まない）
+func run(ctx context.Context, args []string) error {
+	_readMax = conf.Import.MaxRows
+	_colArea = conf.Import.ColArea
+	_colSpot = conf.Import.ColSpot
+	_colTime = conf.Import.ColTime
+	_colCount = conf.Import.ColCount
+	_timeFormatCsv = filer.ModTimeLayout(conf.Import.TimeFormat)
 
-	Db, err = rdb.GetConnectionPsql()
+	var err error
+	Store, err = rdb.OpenStore()
 	if err != nil {
-		fmt.Printf("DB接続でエラー error=%v\n", err)
-		return
+		return fmt.Errorf("DB接続でエラー error=%v", err)
 	}
-	defer Db.Close()
+	defer Store.Close()
 
 	//ファイル検索
 	files, _ := filepath.Glob("../../app/csv/*.csv")
 	for _, path := range files {
+		if ctx.Err() != nil {
+			logger.Infof("終了するため残りのファイルはインポートしません")
+			break
+		}
 		err := execImport(path)
 		if err != nil {
-			fmt.Printf("%v\n", err)
+			logger.Errorf("%s のインポートでエラー error=%v", filepath.Base(path), err)
 			_ = filer.FileMove(path, "../../app/csv/NG")
 		} else {
 			_ = filer.FileMove(path, "../../app/csv/OK")
 		}
 	}
-
+	return nil
 }
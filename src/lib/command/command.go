@@ -0,0 +1,98 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：bikeshareのサブコマンド
+//
+//　各サービスはCommandを1つ公開し、bikeshareのmainが共通の初期化（app.ini、ロガー、設定、シグナル）をしてから実行する
+//　Daemonのサブコマンドはrun-allで1つのプロセスにまとめて起動できる
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Command サブコマンド
+type Command struct {
+	//Name サブコマンド名（serve-apiなど）
+	Name string
+	//Exe ログファイル名とログの開始・終了に使う名前（以前の実行ファイル名）
+	Exe string
+	//Summary 一覧に表示する説明
+	Summary string
+	//Usage 引数の説明（なければ空）
+	Usage string
+	//Daemon 終了させるまで動き続ける（run-allで起動できる）
+	Daemon bool
+	//Config 読み込む設定（sectionタグを付けた構造体のポインタ）
+	Config interface{}
+	//Flags サブコマンド固有のフラグを登録する（なければnil）
+	Flags func(fs *flag.FlagSet)
+	//InitLogger app.iniを使わずに設定からロガーを初期化する（nilならapp.iniの[LOG]、run-allでは呼ばない）
+	InitLogger func() error
+	//Run 実行する（ctxがキャンセルされたら処理中のものを終えて戻る）
+	Run func(ctx context.Context, args []string) error
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Find nameのサブコマンドを探す
+func Find(commands []*Command, name string) (*Command, bool) {
+	for _, c := range commands {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+//Supervise commandsを並行して実行し、どれかが止まったら（エラーかpanicか、ctxがキャンセルされていないのに戻ったら）残りも止める
+//全て止まるのを待ってから最初のエラーを返す（再起動はコンテナのrestartに任せる）
+func Supervise(ctx context.Context, commands []*Command) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for _, c := range commands {
+		wg.Add(1)
+		go func(c *Command) {
+			defer wg.Done()
+			err := c.Execute(ctx, nil)
+			if err == nil && ctx.Err() == nil {
+				err = fmt.Errorf("%sが終了しました", c.Name)
+			}
+			if err != nil {
+				logger.Errorf("Supervise %sが止まったので全て終了します : %v", c.Name, err)
+				once.Do(func() { firstErr = err })
+				cancel()
+			}
+		}(c)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Execute 開始と終了をログに書いて実行する（panicはエラーにして返す）
+func (c *Command) Execute(ctx context.Context, args []string) (err error) {
+	logger.Info(c.Exe, "開始")
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%sでpanicが発生しました : %v", c.Name, r)
+		}
+		logger.Info(c.Exe, "終了")
+	}()
+	return c.Run(ctx, args)
+}
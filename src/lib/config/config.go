@@ -0,0 +1,323 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：サービスの設定
+//
+//　サービスごとの設定はセクションの構造体（sectionタグ）を並べた構造体で表す
+//　値は 既定値（defaultタグ）→ app.ini（iniタグ）→ 環境変数 の順に上書きする
+//　環境変数の名前は envタグ、なければ <セクション>_<キー>（例 DB_PASSWORD、API_TIMEZONE）
+//　app.iniの知らないセクション・キーや数値でない値は既定値に戻さずエラーにする
+//　起動後に読み込み直しても反映されない設定はセクションかフィールドにrestart:"true"を付ける
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Validator 値の検証を行うセクション
+type Validator interface {
+	Validate() error
+}
+
+//field セクションの1つの設定
+type field struct {
+	key    string
+	env    string
+	def    string
+	secret bool
+	//restart 読み込み直しても再起動するまで反映されない
+	restart bool
+	value   reflect.Value
+}
+
+const (
+	//maskedValue 秘密の値を表示するときの文字列
+	maskedValue = "********"
+)
+
+var (
+	//_ini app.ini（Openしていなければnil）
+	_ini *ini.File
+	//_path Openしたapp.iniのパス
+	_path string
+	//knownSections app.iniに書いてよいセクション
+	knownSections = []string{
+		"DB", "LOG", "API", "GRAPHER", "GBFS", "GBFS_IMPORT", "GBFS_MAP", "STATION", "IMPORT", "ARCHIVE", "NOTIFY",
+	}
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Open app.iniを読み込む
+func Open(path string) error {
+	file, err := ini.Load(path)
+	if err != nil {
+		return fmt.Errorf("%sを読み込めません : %v", path, err)
+	}
+	_ini, _path = file, path
+	return nil
+}
+
+//Reload Openしたapp.iniを読み込み直してdstに設定を読み込む
+//誤りがあればdstは変えずにエラーを返す（app.iniは読み込み直したものを使い続ける）
+func Reload(dst interface{}) error {
+	if _path != "" {
+		if err := Open(_path); err != nil {
+			return err
+		}
+	}
+	next := reflect.New(reflect.TypeOf(dst).Elem())
+	if err := Load(next.Interface()); err != nil {
+		return err
+	}
+	reflect.ValueOf(dst).Elem().Set(next.Elem())
+	return nil
+}
+
+//Load dstに設定を読み込んで検証する（誤りは全て集めて1つのエラーで返す）
+//dstはsectionタグを付けた構造体かmap[string]stringをフィールドに持つ構造体のポインタ
+func Load(dst interface{}) error {
+	var errs []string
+	errs = append(errs, checkSections()...)
+	eachSection(dst, func(name string, section reflect.Value) {
+		if section.Kind() == reflect.Map {
+			section.Set(reflect.ValueOf(iniSection(name)))
+			return
+		}
+		fields := sectionFields(name, section)
+		errs = append(errs, checkKeys(name, fields)...)
+		parsed := true
+		for _, f := range fields {
+			value, source := lookup(name, f)
+			if err := set(f.value, value); err != nil {
+				errs = append(errs, fmt.Sprintf("[%s] %s=%s（%s）: %v", name, printKey(f), value, source, err))
+				parsed = false
+			}
+		}
+		//変換できなかった値があれば検証しない
+		if validator, ok := section.Addr().Interface().(Validator); ok && parsed {
+			if err := validator.Validate(); err != nil {
+				for _, line := range strings.Split(err.Error(), "\n") {
+					errs = append(errs, fmt.Sprintf("[%s] %s", name, line))
+				}
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("設定に誤りがあります\n%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+//Print dstの設定をapp.iniの形式で書く（秘密の値は伏せ、値の出所をコメントで付ける）
+func Print(w io.Writer, dst interface{}) {
+	eachSection(dst, func(name string, section reflect.Value) {
+		fmt.Fprintf(w, "[%s]\n", name)
+		if section.Kind() == reflect.Map {
+			var keys []string
+			for _, key := range section.MapKeys() {
+				keys = append(keys, key.String())
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Fprintf(w, "%s = %v\n", key, section.MapIndex(reflect.ValueOf(key)))
+			}
+		} else {
+			for _, f := range sectionFields(name, section) {
+				value := fmt.Sprint(f.value.Interface())
+				if f.secret && value != "" {
+					value = maskedValue
+				}
+				_, source := lookup(name, f)
+				fmt.Fprintf(w, "%s = %s  ;%s\n", printKey(f), value, source)
+			}
+		}
+		fmt.Fprintln(w)
+	})
+}
+
+//Changed oldとnewで値が変わった設定（[セクション] キー）と、そのうち再起動するまで反映されないもの
+//秘密の値も含めて値そのものは返さない
+func Changed(old, new interface{}) (changed []string, restart []string) {
+	oldValue, newValue := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	t := oldValue.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := t.Field(i).Tag.Lookup("section")
+		if !ok {
+			continue
+		}
+		sectionRestart := t.Field(i).Tag.Get("restart") == "true"
+		before, after := oldValue.Field(i), newValue.Field(i)
+		if before.Kind() == reflect.Map {
+			if !reflect.DeepEqual(before.Interface(), after.Interface()) {
+				key := fmt.Sprintf("[%s]", name)
+				changed = append(changed, key)
+				if sectionRestart {
+					restart = append(restart, key)
+				}
+			}
+			continue
+		}
+		afterFields := sectionFields(name, after)
+		for j, f := range sectionFields(name, before) {
+			if f.value.Interface() == afterFields[j].value.Interface() {
+				continue
+			}
+			key := fmt.Sprintf("[%s] %s", name, printKey(f))
+			changed = append(changed, key)
+			if sectionRestart || f.restart {
+				restart = append(restart, key)
+			}
+		}
+	}
+	return changed, restart
+}
+
+//eachSection dstのセクションのフィールドごとにfnを呼ぶ
+func eachSection(dst interface{}, fn func(name string, section reflect.Value)) {
+	v := reflect.ValueOf(dst).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := t.Field(i).Tag.Lookup("section")
+		if !ok {
+			continue
+		}
+		fn(name, v.Field(i))
+	}
+}
+
+//sectionFields セクションの構造体の設定の一覧
+func sectionFields(name string, section reflect.Value) []field {
+	var fields []field
+	t := section.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag
+		key, env := tag.Get("ini"), tag.Get("env")
+		if key == "" && env == "" {
+			continue
+		}
+		if key == "-" {
+			key = ""
+		}
+		if env == "" {
+			env = name + "_" + key
+		}
+		fields = append(fields, field{
+			key:     key,
+			env:     env,
+			def:     tag.Get("default"),
+			secret:  tag.Get("secret") == "true",
+			restart: tag.Get("restart") == "true",
+			value:   section.Field(i),
+		})
+	}
+	return fields
+}
+
+//lookup 設定の値とその出所
+func lookup(section string, f field) (string, string) {
+	if value, ok := os.LookupEnv(f.env); ok {
+		return value, "環境変数 " + f.env
+	}
+	if f.key != "" && _ini != nil && _ini.Section(section).HasKey(f.key) {
+		return _ini.Section(section).Key(f.key).String(), "app.ini"
+	}
+	return f.def, "既定値"
+}
+
+//set 文字列の値をフィールドの型に変換して入れる
+func set(v reflect.Value, value string) error {
+	value = strings.TrimSpace(value)
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		if value == "" {
+			v.SetInt(0)
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("数値ではありません")
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		if value == "" {
+			v.SetBool(false)
+			return nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("true/falseではありません")
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("%sの設定には対応していません", v.Kind())
+	}
+	return nil
+}
+
+//checkSections app.iniに知らないセクションがないか
+func checkSections() []string {
+	if _ini == nil {
+		return nil
+	}
+	var errs []string
+	for _, name := range _ini.SectionStrings() {
+		if name == ini.DefaultSection {
+			continue
+		}
+		known := false
+		for _, section := range knownSections {
+			known = known || section == name
+		}
+		if !known {
+			errs = append(errs, fmt.Sprintf("[%s] は不明なセクションです", name))
+		}
+	}
+	return errs
+}
+
+//checkKeys app.iniのセクションに知らないキーがないか
+func checkKeys(name string, fields []field) []string {
+	if _ini == nil || !_ini.HasSection(name) {
+		return nil
+	}
+	var errs []string
+	for _, key := range _ini.Section(name).KeyStrings() {
+		known := false
+		for _, f := range fields {
+			known = known || f.key == key
+		}
+		if !known {
+			errs = append(errs, fmt.Sprintf("[%s] %s は不明なキーです", name, key))
+		}
+	}
+	return errs
+}
+
+//iniSection app.iniのセクション内の全てのキーと値
+func iniSection(name string) map[string]string {
+	if _ini == nil || !_ini.HasSection(name) {
+		return map[string]string{}
+	}
+	return _ini.Section(name).KeysHash()
+}
+
+//printKey 表示するキー（app.iniにないものは環境変数の名前）
+func printKey(f field) string {
+	if f.key == "" {
+		return f.env
+	}
+	return f.key
+}
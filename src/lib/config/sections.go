@@ -0,0 +1,371 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  構造体
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//DB [DB] 保存先
+type DB struct {
+	//Driver 保存先の種類（postgres, sqlite3, memory）
+	Driver string `ini:"DRIVER" default:"postgres" restart:"true"`
+	//Path DRIVER=sqlite3のときのファイルパス
+	Path     string `ini:"PATH" default:"../../data/bikeshare.db" restart:"true"`
+	Host     string `ini:"HOST" restart:"true"`
+	Port     int    `ini:"PORT" default:"5432" restart:"true"`
+	User     string `ini:"USER" restart:"true"`
+	Password string `ini:"PASSWORD" secret:"true" restart:"true"`
+	DBName   string `ini:"DB_NAME" restart:"true"`
+	//MaxOpenConns 同時に使う接続の上限（0なら無制限）
+	MaxOpenConns int `ini:"MAX_OPEN_CONNS" default:"10" restart:"true"`
+	//MaxIdleConns 使い終わっても残しておく接続の数
+	MaxIdleConns int `ini:"MAX_IDLE_CONNS" default:"5" restart:"true"`
+	//ConnMaxLifetime 接続を作り直すまでの時間（minute  0なら作り直さない）
+	ConnMaxLifetime int `ini:"CONN_MAX_LIFETIME" default:"30" restart:"true"`
+	//ConnMaxIdleTime 使われていない接続を閉じるまでの時間（minute  0なら閉じない）
+	ConnMaxIdleTime int `ini:"CONN_MAX_IDLE_TIME" default:"5" restart:"true"`
+}
+
+//Log [LOG] ログ
+type Log struct {
+	Level  string `ini:"LEVEL" default:"info"`
+	Format string `ini:"FORMAT" default:"text"`
+	//MaxSize ローテーションするサイズ（MB）
+	MaxSize int `ini:"MAX_SIZE" default:"10"`
+	//MaxAge ローテーションしたファイルを残す日数
+	MaxAge     int `ini:"MAX_AGE" default:"30"`
+	MaxBackups int `ini:"MAX_BACKUPS" default:"10"`
+}
+
+//API [API] apiserver
+type API struct {
+	Port int `ini:"PORT" default:"5001" restart:"true"`
+	//PublicURL 公開URL（OpenAPIのserversに載せる）
+	PublicURL string `ini:"PUBLIC_URL" default:"https://hanetwi.ddns.net/bikeshare/api/v1" restart:"true"`
+	//Cert 管理用のAPIキー（環境変数のみ）
+	Cert     string `ini:"-" env:"API_CERT" secret:"true"`
+	Timezone string `ini:"TIMEZONE" default:"Asia/Tokyo" restart:"true"`
+	//ForecastWeeks 台数の予測に使う過去の週数
+	ForecastWeeks int `ini:"FORECAST_WEEKS" default:"8"`
+	//ForecastWindow 台数の予測で同じ時刻とみなす前後の幅（minute）
+	ForecastWindow     int  `ini:"FORECAST_WINDOW" default:"15"`
+	MasterMissingCount int  `ini:"MASTER_MISSING_COUNT" default:"3"`
+	ScrapingStale      int  `ini:"SCRAPING_STALE" default:"15"`
+	ScrapingWindow     int  `ini:"SCRAPING_WINDOW" default:"15"`
+	ScrapingMinRatio   int  `ini:"SCRAPING_MIN_RATIO" default:"80"`
+	RequireKey         bool `ini:"REQUIRE_KEY" default:"false"`
+	//ApikeyRotateGrace ローテーション後に前の秘密文字列を受け付ける時間（minute）
+	ApikeyRotateGrace int    `ini:"APIKEY_ROTATE_GRACE" default:"1440"`
+	CorsOrigins       string `ini:"CORS_ORIGINS" restart:"true"`
+	TrustProxy        bool   `ini:"TRUST_PROXY" default:"false" restart:"true"`
+	RateLimitIP       int    `ini:"RATE_LIMIT_IP" default:"120" restart:"true"`
+	RateLimitIPBurst  int    `ini:"RATE_LIMIT_IP_BURST" default:"0" restart:"true"`
+	RateLimitKey      int    `ini:"RATE_LIMIT_KEY" default:"600"`
+	RateLimitKeyBurst int    `ini:"RATE_LIMIT_KEY_BURST" default:"0"`
+	MetricsAddr       string `ini:"METRICS_ADDR" restart:"true"`
+	//ReloadInterval マスタなどのキャッシュを読み込み直す間隔（minute  0なら読み込み直さない）
+	ReloadInterval int `ini:"RELOAD_INTERVAL" default:"10" restart:"true"`
+	//ShutdownTimeout 終了するときに処理中のリクエストを待つ時間（second）
+	ShutdownTimeout int `ini:"SHUTDOWN_TIMEOUT" default:"30" restart:"true"`
+}
+
+//Grapher [GRAPHER] grapher
+type Grapher struct {
+	Port int `ini:"PORT" default:"5010" restart:"true"`
+	//ImageURL 描画した画像を公開するURL（末尾にファイル名を付ける）
+	ImageURL string `ini:"IMAGE_URL" default:"https://hanetwi.ddns.net/bikeshare/graph/img/"`
+	//ImgurID imgurのクライアントID（空ならDBのconfigテーブルのimgur_idを使う）
+	ImgurID          string `ini:"IMGUR_ID" secret:"true"`
+	CorsOrigins      string `ini:"CORS_ORIGINS" restart:"true"`
+	TrustProxy       bool   `ini:"TRUST_PROXY" default:"false" restart:"true"`
+	RateLimitIP      int    `ini:"RATE_LIMIT_IP" default:"20" restart:"true"`
+	RateLimitIPBurst int    `ini:"RATE_LIMIT_IP_BURST" default:"0" restart:"true"`
+	MaxRenders       int    `ini:"MAX_RENDERS" default:"4" restart:"true"`
+	MetricsAddr      string `ini:"METRICS_ADDR" restart:"true"`
+	//ReloadInterval DBの設定を読み込み直す間隔（minute  0なら読み込み直さない）
+	ReloadInterval int `ini:"RELOAD_INTERVAL" default:"10" restart:"true"`
+	//ShutdownTimeout 終了するときに処理中のリクエストと描画を待つ時間（second）
+	ShutdownTimeout int `ini:"SHUTDOWN_TIMEOUT" default:"30" restart:"true"`
+}
+
+//GBFS [GBFS] GBFSフィードの公開
+type GBFS struct {
+	BaseURL  string `ini:"BASE_URL"`
+	TTL      int    `ini:"TTL" default:"60"`
+	SystemID string `ini:"SYSTEM_ID" default:"docomo_bikeshare"`
+	Language string `ini:"LANGUAGE" default:"ja"`
+	Name     string `ini:"NAME" default:"ドコモ・バイクシェア"`
+	Operator string `ini:"OPERATOR"`
+	URL      string `ini:"URL"`
+}
+
+//GBFSImport [GBFS_IMPORT] GBFSフィードの取り込み
+type GBFSImport struct {
+	URL      string `ini:"URL" restart:"true"`
+	Language string `ini:"LANGUAGE" default:"ja" restart:"true"`
+	//Interval station_statusの取り込み間隔（秒）
+	Interval int `ini:"INTERVAL" default:"60" restart:"true"`
+	//MasterInterval station_informationの取り込み間隔（分）
+	MasterInterval int    `ini:"MASTER_INTERVAL" default:"60" restart:"true"`
+	DefaultArea    string `ini:"DEFAULT_AREA" restart:"true"`
+}
+
+//Station [STATION] stationfiller
+type Station struct {
+	Start       string `ini:"START" default:"00:00"`
+	MetricsAddr string `ini:"METRICS_ADDR"`
+}
+
+//Import [IMPORT] importer
+type Import struct {
+	MaxRows    int    `ini:"MAXROWS" default:"5000"`
+	ColArea    int    `ini:"COL_AREA" default:"1"`
+	ColSpot    int    `ini:"COL_SPOT" default:"2"`
+	ColTime    int    `ini:"COL_TIME" default:"0"`
+	ColCount   int    `ini:"COL_COUNT" default:"3"`
+	TimeFormat string `ini:"TIME_FORMAT" default:"yyyy-mm-dd HH:MM:SS"`
+}
+
+//Archive [ARCHIVE] archiver
+type Archive struct {
+	MaxRows int `ini:"MAXROWS" default:"5000"`
+	//Interval 古い台数を削除する間隔（minute）
+	Interval    int    `ini:"INTERVAL" default:"30"`
+	Start       string `ini:"START" default:"00:00"`
+	MetricsAddr string `ini:"METRICS_ADDR"`
+}
+
+//Notify [NOTIFY] notify
+type Notify struct {
+	//Request 通知リクエストのURL（${USER}がIDに置換される）
+	Request     string `ini:"REQUEST"`
+	MetricsAddr string `ini:"METRICS_ADDR"`
+}
+
+//problems 検証で見つかった誤り
+type problems []string
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//LoadDB [DB]を読み込む
+func LoadDB() (DB, error) {
+	var conf struct {
+		DB DB `section:"DB"`
+	}
+	err := Load(&conf)
+	return conf.DB, err
+}
+
+//LoadLog [LOG]を読み込む
+func LoadLog() (Log, error) {
+	var conf struct {
+		Log Log `section:"LOG"`
+	}
+	err := Load(&conf)
+	return conf.Log, err
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Validate Validatorの実装
+func (c *DB) Validate() error {
+	var p problems
+	switch c.Driver {
+	case "postgres":
+		p.required("HOST", c.Host)
+		p.required("USER", c.User)
+		p.required("PASSWORD", c.Password)
+		p.required("DB_NAME", c.DBName)
+		p.between("PORT", c.Port, 1, 65535)
+	case "sqlite3":
+		p.required("PATH", c.Path)
+	case "memory":
+	default:
+		p.add("DRIVER=%s には対応していません（postgres, sqlite3, memory）", c.Driver)
+	}
+	p.atLeast("MAX_OPEN_CONNS", c.MaxOpenConns, 0)
+	p.atLeast("MAX_IDLE_CONNS", c.MaxIdleConns, 0)
+	p.atLeast("CONN_MAX_LIFETIME", c.ConnMaxLifetime, 0)
+	p.atLeast("CONN_MAX_IDLE_TIME", c.ConnMaxIdleTime, 0)
+	return p.err()
+}
+
+//Validate Validatorの実装
+func (c *Log) Validate() error {
+	var p problems
+	if _, err := logger.ParseLevel(c.Level); err != nil {
+		p.add("LEVEL : %v", err)
+	}
+	if _, err := logger.ParseFormat(c.Format); err != nil {
+		p.add("FORMAT : %v", err)
+	}
+	p.atLeast("MAX_SIZE", c.MaxSize, 0)
+	p.atLeast("MAX_AGE", c.MaxAge, 0)
+	p.atLeast("MAX_BACKUPS", c.MaxBackups, 0)
+	return p.err()
+}
+
+//Logger ロガーの設定
+func (c *Log) Logger() logger.Config {
+	level, _ := logger.ParseLevel(c.Level)
+	asJSON, _ := logger.ParseFormat(c.Format)
+	return logger.Config{
+		Level:      level,
+		JSON:       asJSON,
+		MaxSize:    int64(c.MaxSize) * 1024 * 1024,
+		MaxAge:     time.Duration(c.MaxAge) * 24 * time.Hour,
+		MaxBackups: c.MaxBackups,
+	}
+}
+
+//Validate Validatorの実装
+func (c *API) Validate() error {
+	var p problems
+	p.between("PORT", c.Port, 1, 65535)
+	p.atLeast("FORECAST_WEEKS", c.ForecastWeeks, 1)
+	p.atLeast("FORECAST_WINDOW", c.ForecastWindow, 0)
+	p.atLeast("MASTER_MISSING_COUNT", c.MasterMissingCount, 0)
+	p.atLeast("SCRAPING_STALE", c.ScrapingStale, 1)
+	p.atLeast("SCRAPING_WINDOW", c.ScrapingWindow, 1)
+	p.between("SCRAPING_MIN_RATIO", c.ScrapingMinRatio, 0, 100)
+	p.atLeast("APIKEY_ROTATE_GRACE", c.ApikeyRotateGrace, 0)
+	p.atLeast("RATE_LIMIT_IP", c.RateLimitIP, 0)
+	p.atLeast("RATE_LIMIT_IP_BURST", c.RateLimitIPBurst, 0)
+	p.atLeast("RATE_LIMIT_KEY", c.RateLimitKey, 0)
+	p.atLeast("RATE_LIMIT_KEY_BURST", c.RateLimitKeyBurst, 0)
+	p.url("PUBLIC_URL", c.PublicURL)
+	p.atLeast("RELOAD_INTERVAL", c.ReloadInterval, 0)
+	p.atLeast("SHUTDOWN_TIMEOUT", c.ShutdownTimeout, 1)
+	return p.err()
+}
+
+//Validate Validatorの実装
+func (c *Grapher) Validate() error {
+	var p problems
+	p.between("PORT", c.Port, 1, 65535)
+	p.url("IMAGE_URL", c.ImageURL)
+	p.atLeast("RATE_LIMIT_IP", c.RateLimitIP, 0)
+	p.atLeast("RATE_LIMIT_IP_BURST", c.RateLimitIPBurst, 0)
+	p.atLeast("MAX_RENDERS", c.MaxRenders, 1)
+	p.atLeast("RELOAD_INTERVAL", c.ReloadInterval, 0)
+	p.atLeast("SHUTDOWN_TIMEOUT", c.ShutdownTimeout, 1)
+	return p.err()
+}
+
+//Validate Validatorの実装
+func (c *GBFS) Validate() error {
+	var p problems
+	p.atLeast("TTL", c.TTL, 0)
+	if c.BaseURL != "" {
+		p.url("BASE_URL", c.BaseURL)
+	}
+	return p.err()
+}
+
+//Validate Validatorの実装
+func (c *GBFSImport) Validate() error {
+	var p problems
+	p.atLeast("INTERVAL", c.Interval, 1)
+	p.atLeast("MASTER_INTERVAL", c.MasterInterval, 1)
+	if c.URL != "" {
+		p.url("URL", c.URL)
+	}
+	return p.err()
+}
+
+//Validate Validatorの実装
+func (c *Station) Validate() error {
+	var p problems
+	p.clock("START", c.Start)
+	return p.err()
+}
+
+//Validate Validatorの実装
+func (c *Import) Validate() error {
+	var p problems
+	p.atLeast("MAXROWS", c.MaxRows, 1)
+	p.atLeast("COL_AREA", c.ColArea, 0)
+	p.atLeast("COL_SPOT", c.ColSpot, 0)
+	p.atLeast("COL_TIME", c.ColTime, 0)
+	p.atLeast("COL_COUNT", c.ColCount, 0)
+	p.required("TIME_FORMAT", c.TimeFormat)
+	return p.err()
+}
+
+//Validate Validatorの実装
+func (c *Archive) Validate() error {
+	var p problems
+	p.atLeast("MAXROWS", c.MaxRows, 1)
+	p.atLeast("INTERVAL", c.Interval, 1)
+	p.clock("START", c.Start)
+	return p.err()
+}
+
+//Validate Validatorの実装
+func (c *Notify) Validate() error {
+	var p problems
+	p.url("REQUEST", c.Request)
+	if !strings.Contains(c.Request, "${USER}") {
+		p.add("REQUEST に ${USER} が含まれていません")
+	}
+	return p.err()
+}
+
+//add 誤りを追加する
+func (p *problems) add(format string, param ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, param...))
+}
+
+//required 空でないか
+func (p *problems) required(key, value string) {
+	if strings.TrimSpace(value) == "" {
+		p.add("%s を設定してください", key)
+	}
+}
+
+//atLeast min以上か
+func (p *problems) atLeast(key string, value, min int) {
+	if value < min {
+		p.add("%s=%d は%d以上にしてください", key, value, min)
+	}
+}
+
+//between minからmaxの間か
+func (p *problems) between(key string, value, min, max int) {
+	if value < min || value > max {
+		p.add("%s=%d は%dから%dの間にしてください", key, value, min, max)
+	}
+}
+
+//url http(s)://で始まるか
+func (p *problems) url(key, value string) {
+	if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+		p.add("%s=%s はhttp://かhttps://で始まるURLにしてください", key, value)
+	}
+}
+
+//clock hh:mm形式か
+func (p *problems) clock(key, value string) {
+	if _, err := time.Parse("15:04", value); err != nil {
+		p.add("%s=%s はhh:mm形式にしてください", key, value)
+	}
+}
+
+//err 誤りがあれば改行区切りのエラーにする
+func (p problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(p, "\n"))
+}
@@ -8,39 +8,22 @@ import (
 	"strings"
 	"time"
 
+	"github.com/8245snake/bikeshare_api/src/lib/config"
 	"github.com/8245snake/bikeshare_api/src/lib/logger"
 	"github.com/8245snake/bikeshare_api/src/lib/static"
-
-	"gopkg.in/ini.v1"
 )
 
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 //  ファイルI/O関係
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-//_ini iniオブジェクト
-var _ini *ini.File
-
-//GetIniData 設定ファイル読み込み
-func GetIniData(section string, key string, defaultval string) string {
-	if _ini == nil {
-		return defaultval
-	}
-	return _ini.Section(section).Key(key).MustString(defaultval)
-}
-
-//GetIniDataInt 設定ファイル読み込み（数値）
-func GetIniDataInt(section string, key string, defaultval int) int {
-	if _ini == nil {
-		return defaultval
-	}
-	return _ini.Section(section).Key(key).MustInt(defaultval)
-}
+//exeName SetExeNameで指定した名前（空なら実行ファイル名）
+var exeName string
 
 //CheckFileExist ファイルがあるかチェックする。ない場合はメッセージ出力しFalseを返す。
 func CheckFileExist(path string) bool {
 	if f, err := os.Stat(path); os.IsNotExist(err) || f.IsDir() {
-		fmt.Println(fmt.Sprintf("File '%s' is not exist", filepath.Clean(path)))
+		logger.Warnf("File '%s' is not exist", filepath.Clean(path))
 		return false
 	}
 	return true
@@ -49,7 +32,7 @@ func CheckFileExist(path string) bool {
 //CheckDirectoryExist ディレクトリがあるかチェックする。ない場合はメッセージ出力しFalseを返す。
 func CheckDirectoryExist(path string) bool {
 	if f, err := os.Stat(path); os.IsNotExist(err) || !f.IsDir() {
-		fmt.Println(fmt.Sprintf("Derectory '%s' is not exist", filepath.Clean(path)))
+		logger.Warnf("Derectory '%s' is not exist", filepath.Clean(path))
 		return false
 	}
 	return true
@@ -93,7 +76,7 @@ func FileMove(srcPath string, dstDir string) error {
 func SetCurDirToExeDir() {
 	exe, _ := os.Executable()
 	static.DirExe = filepath.Clean(filepath.Dir(exe))
-	fmt.Println(static.DirExe)
+	logger.Debugf("SetCurDirToExeDir %s", static.DirExe)
 	os.Chdir(static.DirExe)
 }
 
@@ -106,15 +89,16 @@ func InitDirSetting() error {
 		return fmt.Errorf("app.ini is not exist")
 	}
 	//iniをキャッシュ
-	if c, err := ini.Load(static.IniPath); err == nil {
-		_ini = c
-	} else {
-		_ini = nil
+	if err := config.Open(static.IniPath); err != nil {
 		return err
 	}
 
 	//ロガーを初期化
-	if err := logger.InitLogger(filepath.Join(static.DirLog, GetExeName()+".log")); err != nil {
+	logConf, err := config.LoadLog()
+	if err != nil {
+		return err
+	}
+	if err := logger.InitLogger(filepath.Join(static.DirLog, GetExeName()+".log"), logConf.Logger()); err != nil {
 		return err
 	}
 
@@ -140,11 +124,19 @@ func GetFileNameWithoutExt(path string) string {
 	return filepath.Base(path[:len(path)-len(filepath.Ext(path))])
 }
 
-//GetExeName 実行ファイル名から拡張子を除いた文字列を返す
+//GetExeName 実行ファイル名から拡張子を除いた文字列を返す（SetExeNameで指定していればその名前）
 func GetExeName() string {
+	if exeName != "" {
+		return exeName
+	}
 	return GetFileNameWithoutExt(os.Args[0])
 }
 
+//SetExeName ログファイル名などに使う名前を指定する（サブコマンドごとに以前の実行ファイル名を使う）
+func SetExeName(name string) {
+	exeName = name
+}
+
 //WaitForFileCreation ファイルができるまで待つ
 //監視間隔とタイムアウトを秒で指定
 //見つかったらtrueを返す
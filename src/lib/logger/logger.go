@@ -1,59 +1,312 @@
 package logger
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：ログ出力
+//
+//　レベル（DEBUG/INFO/WARN/ERROR）以上のログをファイルと標準エラー出力に書く
+//　形式はテキストかJSON（1行1オブジェクト）を選べる
+//　リクエストIDをcontextに入れておくとFromContextで取得したEntryのログにIDが付く
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Level ログのレベル
+type Level int
 
-	"github.com/mbndr/logo"
+const (
+	//LevelDebug デバッグ
+	LevelDebug Level = iota
+	//LevelInfo インフォメーション
+	LevelInfo
+	//LevelWarn 警告
+	LevelWarn
+	//LevelError エラー
+	LevelError
+)
+
+//Config ロガーの設定
+type Config struct {
+	//Level これ以上のレベルのログだけ出力する
+	Level Level
+	//JSON trueならJSON形式で出力する
+	JSON bool
+	//MaxSize ファイルがこのサイズ（byte）を超えたらローテーションする（0ならしない）
+	MaxSize int64
+	//MaxAge ローテーションしたファイルをこの期間だけ残す（0なら期間では削除しない）
+	MaxAge time.Duration
+	//MaxBackups ローテーションしたファイルをこの数だけ残す（0なら数では削除しない）
+	MaxBackups int
+}
+
+//Entry ログに付ける項目（リクエストIDなど）
+type Entry struct {
+	requestID string
+}
+
+//record JSON形式の1行
+type record struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Message   string `json:"msg"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+//output 出力先
+type output struct {
+	mu     sync.Mutex
+	config Config
+	file   *rotatingFile
+	//console 標準エラー出力
+	console io.Writer
+}
+
+//contextKey contextにリクエストIDを入れるキー
+type contextKey struct{}
+
+const (
+	//RequestIDHeader サービス間でリクエストIDを受け渡すヘッダ
+	RequestIDHeader = "X-Request-ID"
+	//maxRequestIDLength 受け付けるリクエストIDの長さ（これより長ければ生成し直す）
+	maxRequestIDLength = 64
+)
+
+var (
+	//_log 出力先（InitLoggerまでは標準エラー出力にDEBUGから出力する）
+	_log = &output{config: Config{Level: LevelDebug}, console: os.Stderr}
+	//std 項目のないEntry
+	std = &Entry{}
+	//levelNames レベルの名前
+	levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
 )
 
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
-//  ファイルI/O関係
+//  関数
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-//_log ロガー
-var _log *logo.Logger
+//InitLogger ロガー初期化（pathが空ならファイルには書かない）
+func InitLogger(path string, config Config) error {
+	var file *rotatingFile
+	if path != "" {
+		var err error
+		if file, err = openRotatingFile(path, config); err != nil {
+			return err
+		}
+	}
+	_log.mu.Lock()
+	defer _log.mu.Unlock()
+	if _log.file != nil {
+		_log.file.Close()
+	}
+	_log.config, _log.file = config, file
+	return nil
+}
+
+//Reconfigure 出力先はそのままでレベル・形式・ローテーションの設定を変える
+func Reconfigure(config Config) {
+	_log.mu.Lock()
+	defer _log.mu.Unlock()
+	_log.config = config
+	if _log.file != nil {
+		_log.file.config = config
+	}
+}
 
-//InitLogger ロガー初期化
-func InitLogger(path string) error {
-	// Receiver for the terminal which logs everything
-	cliRec := logo.NewReceiver(os.Stderr, "")
-	cliRec.Color = true
-	cliRec.Level = logo.DEBUG
+//ParseLevel 名前（debug/info/warn/error、大文字小文字は区別しない）からレベルを返す
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(strings.TrimSpace(name), levelName) {
+			return Level(i), nil
+		}
+	}
+	if strings.EqualFold(strings.TrimSpace(name), "WARNING") {
+		return LevelWarn, nil
+	}
+	return LevelInfo, fmt.Errorf("ログのレベル%sは不正です（debug/info/warn/errorのいずれか）", name)
+}
 
-	// Helper function to get a os.File with the correct options
-	logFile, err := logo.Open(path)
-	if err != nil {
-		return err
+//ParseFormat 名前（text/json）からJSON形式にするかを返す
+func ParseFormat(name string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "text":
+		return false, nil
+	case "json":
+		return true, nil
 	}
+	return false, fmt.Errorf("ログの形式%sは不正です（textかjson）", name)
+}
 
-	// Receiver for the log file
-	// This will log with level INFO (default) and have no colors activated
-	// Also the log format is simpler (f.e. ERRO: Message)
-	fileRec := logo.NewReceiver(logFile, "")
-	fileRec.Format = "%s: %s"
-	fileRec.Level = logo.DEBUG
+//NewRequestID リクエストIDを生成する（16進数16桁）
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
 
-	// Create the logger
-	_log = logo.NewLogger(fileRec, cliRec)
-	return nil
+//ValidRequestID 受け取ったリクエストIDをそのまま使ってよいか（英数字と-_.のみ、64文字以内）
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+//NewContext リクエストIDを入れたcontextを返す
+func NewContext(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestID)
+}
+
+//RequestID contextのリクエストID（なければ空文字）
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+//FromContext contextのリクエストIDを付けてログを書くEntry
+func FromContext(ctx context.Context) *Entry {
+	return WithRequestID(RequestID(ctx))
+}
+
+//WithRequestID リクエストIDを付けてログを書くEntry
+func WithRequestID(requestID string) *Entry {
+	if requestID == "" {
+		return std
+	}
+	return &Entry{requestID: requestID}
 }
 
 //Debug デバッグログ
 func Debug(text ...interface{}) {
-	_log.Debug(text...)
+	std.log(LevelDebug, fmt.Sprint(text...))
 }
 
 //Debugf デバッグログフォーマット付き
 func Debugf(format string, param ...interface{}) {
-	_log.Debugf(format, param...)
+	std.log(LevelDebug, fmt.Sprintf(format, param...))
 }
 
 //Info インフォメーションログ
 func Info(text ...interface{}) {
-	_log.Info(text...)
+	std.log(LevelInfo, fmt.Sprint(text...))
 }
 
 //Infof インフォメーションログフォーマット付き
 func Infof(format string, param ...interface{}) {
-	_log.Infof(format, param...)
+	std.log(LevelInfo, fmt.Sprintf(format, param...))
+}
+
+//Warn 警告ログ
+func Warn(text ...interface{}) {
+	std.log(LevelWarn, fmt.Sprint(text...))
+}
+
+//Warnf 警告ログフォーマット付き
+func Warnf(format string, param ...interface{}) {
+	std.log(LevelWarn, fmt.Sprintf(format, param...))
+}
+
+//Error エラーログ
+func Error(text ...interface{}) {
+	std.log(LevelError, fmt.Sprint(text...))
+}
+
+//Errorf エラーログフォーマット付き
+func Errorf(format string, param ...interface{}) {
+	std.log(LevelError, fmt.Sprintf(format, param...))
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//String レベルの名前
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+//Debugf デバッグログフォーマット付き
+func (e *Entry) Debugf(format string, param ...interface{}) {
+	e.log(LevelDebug, fmt.Sprintf(format, param...))
+}
+
+//Infof インフォメーションログフォーマット付き
+func (e *Entry) Infof(format string, param ...interface{}) {
+	e.log(LevelInfo, fmt.Sprintf(format, param...))
+}
+
+//Warnf 警告ログフォーマット付き
+func (e *Entry) Warnf(format string, param ...interface{}) {
+	e.log(LevelWarn, fmt.Sprintf(format, param...))
+}
+
+//Errorf エラーログフォーマット付き
+func (e *Entry) Errorf(format string, param ...interface{}) {
+	e.log(LevelError, fmt.Sprintf(format, param...))
+}
+
+//log レベルが設定以上なら出力する
+func (e *Entry) log(level Level, message string) {
+	_log.mu.Lock()
+	defer _log.mu.Unlock()
+	if level < _log.config.Level {
+		return
+	}
+	line := e.format(time.Now(), level, message, _log.config.JSON)
+	if _log.file != nil {
+		if _, err := _log.file.Write(line); err != nil {
+			fmt.Fprintf(_log.console, "ログファイルに書き込めません  %v\n", err)
+		}
+	}
+	_log.console.Write(line)
+}
+
+//format 1行分のログを作る
+func (e *Entry) format(now time.Time, level Level, message string, asJSON bool) []byte {
+	message = strings.TrimRight(message, "\n ")
+	if asJSON {
+		rec := record{
+			Time:      now.Format("2006-01-02T15:04:05.000Z07:00"),
+			Level:     strings.ToLower(level.String()),
+			Message:   message,
+			RequestID: e.requestID,
+		}
+		line, err := json.Marshal(rec)
+		if err == nil {
+			return append(line, '\n')
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString(now.Format("2006/01/02 15:04:05.000 "))
+	sb.WriteString(fmt.Sprintf("%-5s ", level))
+	if e.requestID != "" {
+		sb.WriteString("[" + e.requestID + "] ")
+	}
+	sb.WriteString(message)
+	sb.WriteString("\n")
+	return []byte(sb.String())
 }
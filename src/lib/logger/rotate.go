@@ -0,0 +1,158 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：ログファイルのローテーション
+//
+//　サイズがMaxSizeを超えるか、MaxAgeが設定されていて日付が変わったら
+//　<ファイル名>.yyyymmdd-HHMMSS に名前を変えて新しいファイルに書く
+//　名前を変えたファイルはMaxAgeより古いものとMaxBackupsを超えた古いものを削除する
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//rotatingFile ローテーションするファイル
+type rotatingFile struct {
+	path   string
+	config Config
+	file   *os.File
+	size   int64
+	//day ファイルに最後に書いた日付（yyyymmdd）
+	day string
+}
+
+const (
+	//backupLayout ローテーションしたファイルに付ける日時
+	backupLayout = "20060102-150405"
+	//dayLayout 日付が変わったかの判定に使う
+	dayLayout = "20060102"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//openRotatingFile ファイルを追記で開く（ディレクトリがなければ作る）
+func openRotatingFile(path string, config Config) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("ログのディレクトリを作れません : %v", err)
+	}
+	f := &rotatingFile{path: path, config: config}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	f.prune(time.Now())
+	return f, nil
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//open ファイルを開いてサイズと最終更新日を覚える
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("ログファイル%sを開けません : %v", f.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("ログファイル%sの情報を取得できません : %v", f.path, err)
+	}
+	f.file, f.size, f.day = file, info.Size(), info.ModTime().Format(dayLayout)
+	return nil
+}
+
+//Write 1行書く（必要なら先にローテーションする）
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	now := time.Now()
+	if f.size > 0 && f.needRotate(int64(len(p)), now) {
+		if err := f.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	f.day = now.Format(dayLayout)
+	return n, err
+}
+
+//needRotate 書く前にローテーションするか
+func (f *rotatingFile) needRotate(length int64, now time.Time) bool {
+	if f.config.MaxSize > 0 && f.size+length > f.config.MaxSize {
+		return true
+	}
+	return f.config.MaxAge > 0 && f.day != now.Format(dayLayout)
+}
+
+//rotate 今のファイルの名前を変えて新しいファイルを開く
+func (f *rotatingFile) rotate(now time.Time) error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	backup := f.path + "." + now.Format(backupLayout)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backup); os.IsNotExist(err) {
+			break
+		}
+		backup = fmt.Sprintf("%s.%s-%d", f.path, now.Format(backupLayout), i)
+	}
+	if err := os.Rename(f.path, backup); err != nil {
+		//名前を変えられなくても書き続ける
+		f.open()
+		return fmt.Errorf("ログファイル%sをローテーションできません : %v", f.path, err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.prune(now)
+	return nil
+}
+
+//prune 古いローテーション済みのファイルを削除する
+func (f *rotatingFile) prune(now time.Time) {
+	if f.config.MaxAge <= 0 && f.config.MaxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+	type backupFile struct {
+		path string
+		time time.Time
+	}
+	var backups []backupFile
+	for _, path := range matches {
+		suffix := strings.TrimPrefix(path, f.path+".")
+		if len(suffix) < len(backupLayout) {
+			continue
+		}
+		t, err := time.ParseInLocation(backupLayout, suffix[:len(backupLayout)], time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{path: path, time: t})
+	}
+	//新しい順
+	sort.Slice(backups, func(i, j int) bool { return backups[i].path > backups[j].path })
+	for i, backup := range backups {
+		tooMany := f.config.MaxBackups > 0 && i >= f.config.MaxBackups
+		tooOld := f.config.MaxAge > 0 && now.Sub(backup.time) > f.config.MaxAge
+		if tooMany || tooOld {
+			os.Remove(backup.path)
+		}
+	}
+}
+
+//Close ファイルを閉じる
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
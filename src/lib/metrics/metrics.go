@@ -0,0 +1,270 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：Prometheusのメトリクス
+//
+//　カウンタ・ゲージ・ヒストグラムをパッケージ変数として定義し、/metrics でテキスト形式で公開する
+//　ラベルの値はルートのパスや結果など種類が限られるものだけにする（エリアやユーザーIDは使わない）
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//family 同じ名前のメトリクス
+type family struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*series
+}
+
+//series ラベルの値ごとの値
+type series struct {
+	values []string
+	value  float64
+	//counts ヒストグラムのバケットごとの件数（累積しない）
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+//Counter 増えるだけの値
+type Counter struct{ f *family }
+
+//Gauge 増減する値
+type Gauge struct{ f *family }
+
+//Histogram 観測値の分布
+type Histogram struct{ f *family }
+
+//registry 登録されたメトリクス
+type registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+var (
+	//DefBuckets 処理時間（秒）のバケット
+	DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	//defaultRegistry 全てのメトリクス
+	defaultRegistry = &registry{}
+	//startTime プロセスの開始日時
+	startTime = time.Now()
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//NewCounter カウンタを登録する
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{register(name, help, "counter", labels, nil)}
+}
+
+//NewGauge ゲージを登録する
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{register(name, help, "gauge", labels, nil)}
+}
+
+//NewHistogram ヒストグラムを登録する（bucketsは昇順）
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{register(name, help, "histogram", labels, buckets)}
+}
+
+//register メトリクスを登録する（同じ名前を2回登録したらpanic）
+func register(name, help, typ string, labels []string, buckets []float64) *family {
+	defaultRegistry.mu.Lock()
+	defer defaultRegistry.mu.Unlock()
+	for _, f := range defaultRegistry.families {
+		if f.name == name {
+			panic(fmt.Sprintf("メトリクス%sは登録済みです", name))
+		}
+	}
+	f := &family{name: name, help: help, typ: typ, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	defaultRegistry.families = append(defaultRegistry.families, f)
+	return f
+}
+
+//Handler /metrics のハンドラ
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writer := bufio.NewWriter(w)
+		writeProcess(writer)
+		defaultRegistry.mu.Lock()
+		families := append([]*family{}, defaultRegistry.families...)
+		defaultRegistry.mu.Unlock()
+		for _, f := range families {
+			f.write(writer)
+		}
+		writer.Flush()
+	})
+}
+
+//Serve addrで/metricsだけを公開するサーバを開始する（addrが空なら何もしない）
+//ポートを開けなければエラーを返し、開けたら別のgoroutineで待ち受ける
+func Serve(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("メトリクスのポート%sを開けません : %v", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	go http.Serve(listener, mux)
+	return nil
+}
+
+//writeProcess プロセスのメトリクスを書く
+func writeProcess(w *bufio.Writer) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeSimple(w, "process_start_time_seconds", "プロセスの開始日時（UNIX時間）", "gauge", float64(startTime.Unix()))
+	writeSimple(w, "go_goroutines", "goroutineの数", "gauge", float64(runtime.NumGoroutine()))
+	writeSimple(w, "go_memstats_alloc_bytes", "使用中のヒープ（バイト）", "gauge", float64(mem.Alloc))
+}
+
+//writeSimple ラベルのないメトリクスを書く
+func writeSimple(w *bufio.Writer, name, help, typ string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, typ, name, formatValue(value))
+}
+
+//formatValue 値をテキスト形式にする
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//formatLabels {name="value",...} を作る
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	var arr []string
+	for i, name := range names {
+		arr = append(arr, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		arr = append(arr, extraName+`="`+escapeLabel(extraValue)+`"`)
+	}
+	if len(arr) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(arr, ",") + "}"
+}
+
+//escapeLabel ラベルの値をエスケープする
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Inc 1増やす
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+//Add vだけ増やす
+func (c *Counter) Add(v float64, values ...string) {
+	c.f.update(values, func(s *series) { s.value += v })
+}
+
+//Set 値を設定する
+func (g *Gauge) Set(v float64, values ...string) {
+	g.f.update(values, func(s *series) { s.value = v })
+}
+
+//SetToCurrentTime 現在日時（UNIX時間）を設定する
+func (g *Gauge) SetToCurrentTime(values ...string) {
+	g.Set(float64(time.Now().UnixNano())/1e9, values...)
+}
+
+//Observe 値を記録する
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.f.update(values, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.f.buckets))
+		}
+		for i, bound := range h.f.buckets {
+			if v <= bound {
+				s.counts[i]++
+				break
+			}
+		}
+		s.sum += v
+		s.count++
+	})
+}
+
+//ObserveSince startからの経過秒数を記録する
+func (h *Histogram) ObserveSince(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+//update ラベルの値のseriesを更新する（ラベルの数が合わなければpanic）
+func (f *family) update(values []string, fn func(s *series)) {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("メトリクス%sのラベルは%d個です(%d個指定されました)", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string{}, values...)}
+		f.series[key] = s
+	}
+	fn(s)
+}
+
+//write テキスト形式で書く（ラベルの値の順に並べる）
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+	var keys []string
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.typ != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labels, s.values, "", ""), formatValue(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			if s.counts != nil {
+				cumulative += s.counts[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.values, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.values, "", ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.values, "", ""), s.count)
+	}
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：CORS
+//
+//　設定のCORS_ORIGINS（カンマ区切り）に書いたオリジンのみ許可する
+//　「*」なら全てのオリジンを許可する（認証情報付きのリクエストは許可しない）
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//NewCorsMiddleware corsOrigins（カンマ区切り）を許可するCORSミドルウェアを作る
+func NewCorsMiddleware(corsOrigins string, allowedHeaders ...string) *rest.CorsMiddleware {
+	origins := ParseOrigins(corsOrigins)
+	anyOrigin := false
+	for _, origin := range origins {
+		anyOrigin = anyOrigin || origin == "*"
+	}
+	return &rest.CorsMiddleware{
+		RejectNonCorsRequests: false,
+		OriginValidator: func(origin string, request *rest.Request) bool {
+			return AllowOrigin(origins, origin)
+		},
+		AllowedMethods:                []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedHeaders:                append([]string{"Accept", "Content-Type", "X-Custom-Header", "Origin", logger.RequestIDHeader}, allowedHeaders...),
+		AccessControlExposeHeaders:    []string{"Retry-After", logger.RequestIDHeader},
+		AccessControlAllowCredentials: !anyOrigin,
+		AccessControlMaxAge:           3600,
+	}
+}
+
+//ParseOrigins カンマ区切りのオリジン（末尾の/は取り除く）
+func ParseOrigins(value string) []string {
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+//AllowOrigin originが許可されているか（大文字小文字は区別しない）
+func AllowOrigin(origins []string, origin string) bool {
+	for _, allowed := range origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
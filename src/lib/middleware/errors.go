@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：エラーのレスポンス
+//
+//　apiserverとgrapherのエラーは全て {"result":"failed","code":...,"reason":...} の形式で4xx/5xxのステータスとともに返す
+//　ルーターの404/405やCORSの403などrest.Errorで書かれたエラーはErrorMiddlewareで同じ形式に変換する
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//errorWriter rest.Errorの出力を書き換えるためのResponseWriter
+type errorWriter struct {
+	rest.ResponseWriter
+	status int
+}
+
+//ErrorMiddleware エラーのレスポンスを共通の形式にするミドルウェア
+type ErrorMiddleware struct{}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//WriteError エラーをJSONで返す
+func WriteError(w rest.ResponseWriter, status int, code static.ErrorCode, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.WriteJson(static.JError{Result: static.ResultFailed, Code: code, Reason: reason})
+}
+
+//ErrorCode HTTPステータスに対応するエラーコード
+func ErrorCode(status int) static.ErrorCode {
+	switch status {
+	case http.StatusUnauthorized:
+		return static.ErrorUnauthorized
+	case http.StatusForbidden:
+		return static.ErrorForbidden
+	case http.StatusTooManyRequests:
+		return static.ErrorRateLimited
+	case http.StatusNotFound:
+		return static.ErrorNotFound
+	case http.StatusMethodNotAllowed:
+		return static.ErrorMethodNotAllowed
+	}
+	if status < http.StatusInternalServerError {
+		return static.ErrorInvalidParameter
+	}
+	return static.ErrorInternal
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//MiddlewareFunc rest.Middlewareの実装（ハンドラのpanicも500のエラーとして返す）
+func (mw *ErrorMiddleware) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		writer := &errorWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			if reason := recover(); reason != nil {
+				logger.FromContext(r.Context()).Errorf("%s %s でpanicが発生しました : %v", r.Method, r.URL.Path, reason)
+				WriteError(writer, http.StatusInternalServerError, static.ErrorInternal, "サーバ内部でエラーが発生しました")
+			}
+		}()
+		handler(writer, r)
+	}
+}
+
+//WriteHeader ステータスを覚えておく
+func (w *errorWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+//WriteJson rest.Errorの出力（{"Error": ...}）ならエラーの形式に変換する
+func (w *errorWriter) WriteJson(v interface{}) error {
+	if m, ok := v.(map[string]string); ok && w.status >= http.StatusBadRequest && len(m) == 1 {
+		if reason, ok := m[rest.ErrorFieldName]; ok {
+			v = static.JError{Result: static.ResultFailed, Code: ErrorCode(w.status), Reason: reason}
+		}
+	}
+	return w.ResponseWriter.WriteJson(v)
+}
+
+//Write http.ResponseWriterの実装（apiserverの/streamで使う）
+func (w *errorWriter) Write(b []byte) (int, error) {
+	writer, ok := w.ResponseWriter.(http.ResponseWriter)
+	if !ok {
+		return 0, fmt.Errorf("http.ResponseWriterではありません")
+	}
+	return writer.Write(b)
+}
+
+//Flush http.Flusherの実装（apiserverの/streamで使う）
+func (w *errorWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
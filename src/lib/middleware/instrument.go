@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/metrics"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：リクエストのメトリクス
+//
+//　ルートごと（パスはルート表の形式、例 /private/keys/:id）に処理時間とステータスを記録する
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//statusWriter ステータスを記録するためのResponseWriter
+type statusWriter struct {
+	rest.ResponseWriter
+	status int
+}
+
+//requestDuration ルートごとの処理時間
+var requestDuration = metrics.NewHistogram("http_request_duration_seconds",
+	"リクエストの処理時間（秒）", metrics.DefBuckets, "route", "method", "code")
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//InstrumentRoutes ルートのハンドラを処理時間を記録するハンドラに包む
+func InstrumentRoutes(routes []*rest.Route) {
+	for _, route := range routes {
+		route.Func = instrument(route.PathExp, route.HttpMethod, route.Func)
+	}
+}
+
+//instrument handlerの処理時間を記録する
+func instrument(path, method string, handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		start := time.Now()
+		writer := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			//panicは外側のミドルウェアが500にする
+			reason := recover()
+			if reason != nil {
+				writer.status = http.StatusInternalServerError
+			}
+			requestDuration.ObserveSince(start, path, method, strconv.Itoa(writer.status))
+			if reason != nil {
+				panic(reason)
+			}
+		}()
+		handler(writer, r)
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//WriteHeader ステータスを覚えておく
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+//Write http.ResponseWriterの実装（Server-Sent Eventsなどで使う）
+func (w *statusWriter) Write(b []byte) (int, error) {
+	writer, ok := w.ResponseWriter.(http.ResponseWriter)
+	if !ok {
+		return 0, fmt.Errorf("http.ResponseWriterではありません")
+	}
+	return writer.Write(b)
+}
+
+//Flush http.Flusherの実装
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
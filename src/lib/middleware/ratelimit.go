@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：リクエスト数の制限
+//
+//　トークンバケットでIPアドレスごと（APIキーがあればキーごと）にリクエスト数を制限する
+//　1分あたりの上限の分だけトークンが補充され、BURSTまでは続けてリクエストできる
+//　上限を超えたリクエストには429と再試行までの秒数（Retry-Afterヘッダ）を返す
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//bucket トークンバケット
+type bucket struct {
+	tokens float64
+	last   time.Time
+	//refill 空から満タンまで補充される時間
+	refill time.Duration
+}
+
+//Limiter キーごとのトークンバケット
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+//Limit リクエスト数の上限
+type Limit struct {
+	//PerMinute 1分あたりのリクエスト数（0なら制限しない）
+	PerMinute int
+	//Burst 続けてリクエストできる数（0ならPerMinuteと同じ）
+	Burst int
+}
+
+//RateLimitMiddleware リクエスト数を制限するミドルウェア
+type RateLimitMiddleware struct {
+	Limiter *Limiter
+	//IP IPアドレスごとの上限
+	IP Limit
+	//TrustProxy trueならリバースプロキシが付けたX-Forwarded-Forのアドレスで数える
+	TrustProxy bool
+	//KeyFunc リクエストのAPIキーのIDと上限を返す（okがfalseならIPアドレスで数える、nilならキーを使わない）
+	KeyFunc func(r *rest.Request) (id string, limit Limit, ok bool)
+}
+
+const (
+	//sweepInterval 満タンまで補充されたバケットを削除する間隔
+	sweepInterval = 10 * time.Minute
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//NewLimiter Limiterを作る
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket)}
+}
+
+//NewRateLimitMiddleware IPアドレスごとの上限でミドルウェアを作る
+func NewRateLimitMiddleware(ip Limit, trustProxy bool) *RateLimitMiddleware {
+	return &RateLimitMiddleware{
+		Limiter:    NewLimiter(),
+		IP:         ip,
+		TrustProxy: trustProxy,
+	}
+}
+
+//ClientIP リクエスト元のIPアドレス
+//trustProxyならX-Forwarded-Forの最後（自分のリバースプロキシが付けた値）を使う
+func ClientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			arr := strings.Split(forwarded, ",")
+			return strings.TrimSpace(arr[len(arr)-1])
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//WriteRateLimited 429をJSONで返す
+func WriteRateLimited(w rest.ResponseWriter, retryAfter int) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	WriteError(w, http.StatusTooManyRequests, static.ErrorRateLimited, "リクエスト数の上限を超えました")
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Allow keyのトークンを1つ使う（足りなければ再試行までの秒数とfalseを返す）
+func (l *Limiter) Allow(key string, limit Limit, now time.Time) (int, bool) {
+	if limit.PerMinute <= 0 {
+		return 0, true
+	}
+	burst := float64(limit.Burst)
+	if limit.Burst <= 0 {
+		burst = float64(limit.PerMinute)
+	}
+	perSecond := float64(limit.PerMinute) / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*perSecond)
+	}
+	b.last = now
+	b.refill = time.Duration(burst / perSecond * float64(time.Second))
+	if b.tokens < 1 {
+		return int(math.Ceil((1 - b.tokens) / perSecond)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+//sweep 最後に使われてから満タンまで補充されたバケットを削除する（次に使われたときは満タンから始まるので制限は変わらない）
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= b.refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+//MiddlewareFunc rest.Middlewareの実装
+func (mw *RateLimitMiddleware) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		key, limit := "ip:"+ClientIP(r.Request, mw.TrustProxy), mw.IP
+		if mw.KeyFunc != nil {
+			if id, keyLimit, ok := mw.KeyFunc(r); ok {
+				key, limit = "key:"+id, keyLimit
+			}
+		}
+		if retryAfter, ok := mw.Limiter.Allow(key, limit, time.Now()); !ok {
+			WriteRateLimited(w, retryAfter)
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+//t0 テストの基準時刻
+var t0 = time.Date(2023, 11, 15, 7, 0, 0, 0, time.UTC)
+
+//allowN keyのトークンをn回使って許可された回数を返す
+func allowN(l *Limiter, key string, limit Limit, now time.Time, n int) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if _, ok := l.Allow(key, limit, now); ok {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+//TestAllowBurst BURSTまでは続けて許可し、キーごとに数える
+func TestAllowBurst(t *testing.T) {
+	l := NewLimiter()
+	limit := Limit{PerMinute: 60, Burst: 3}
+	if n := allowN(l, "a", limit, t0, 5); n != 3 {
+		t.Errorf("許可 %d回（期待値 3）", n)
+	}
+	if n := allowN(l, "b", limit, t0, 5); n != 3 {
+		t.Errorf("別のキーの許可 %d回（期待値 3）", n)
+	}
+	//BURSTが0ならPerMinuteと同じ
+	if n := allowN(l, "c", Limit{PerMinute: 10}, t0, 20); n != 10 {
+		t.Errorf("BURSTが0のときの許可 %d回（期待値 10）", n)
+	}
+	//PerMinuteが0なら制限しない
+	if n := allowN(l, "d", Limit{}, t0, 100); n != 100 {
+		t.Errorf("制限なしの許可 %d回（期待値 100）", n)
+	}
+}
+
+//TestAllowRefill 1分あたりの上限の割合で補充され、BURSTを超えて貯まらない
+func TestAllowRefill(t *testing.T) {
+	l := NewLimiter()
+	limit := Limit{PerMinute: 60, Burst: 3}
+	allowN(l, "a", limit, t0, 3)
+	if n := allowN(l, "a", limit, t0.Add(500*time.Millisecond), 1); n != 0 {
+		t.Error("0.5秒で補充されています")
+	}
+	if n := allowN(l, "a", limit, t0.Add(2*time.Second), 5); n != 2 {
+		t.Errorf("2秒後の許可 %d回（期待値 2）", n)
+	}
+	if n := allowN(l, "a", limit, t0.Add(time.Hour), 5); n != 3 {
+		t.Errorf("1時間後の許可 %d回（期待値 3）", n)
+	}
+}
+
+//TestAllowRetryAfter 拒否したときは次のトークンが補充されるまでの秒数（切り上げ）を返す
+func TestAllowRetryAfter(t *testing.T) {
+	l := NewLimiter()
+	limit := Limit{PerMinute: 6, Burst: 1}
+	tests := []struct {
+		after time.Duration
+		retry int
+		ok    bool
+	}{
+		{0, 0, true},
+		{0, 10, false},
+		{4 * time.Second, 6, false},
+		{9500 * time.Millisecond, 1, false},
+		{10 * time.Second, 0, true},
+		{10 * time.Second, 10, false},
+	}
+	for _, tt := range tests {
+		retry, ok := l.Allow("a", limit, t0.Add(tt.after))
+		if retry != tt.retry || ok != tt.ok {
+			t.Errorf("%v後 : %d %v（期待値 %d %v）", tt.after, retry, ok, tt.retry, tt.ok)
+		}
+	}
+}
+
+//TestSweepOnlyWhenFull 満タンまで補充される前のバケットは削除せず、使った分は戻らない
+func TestSweepOnlyWhenFull(t *testing.T) {
+	l := NewLimiter()
+	//満タンまで20分
+	limit := Limit{PerMinute: 1, Burst: 20}
+	allowN(l, "a", limit, t0, 20)
+
+	//11分後の削除では残り、補充された11回分だけ許可する
+	now := t0.Add(11 * time.Minute)
+	allowN(l, "b", limit, now, 1)
+	if _, ok := l.buckets["a"]; !ok {
+		t.Fatal("満タンになる前に削除されました")
+	}
+	if n := allowN(l, "a", limit, now, 20); n != 11 {
+		t.Errorf("11分後の許可 %d回（期待値 11）", n)
+	}
+
+	//満タンになる1秒前の削除では残る
+	swept := now.Add(20*time.Minute - time.Second)
+	allowN(l, "c", limit, swept, 1)
+	if _, ok := l.buckets["a"]; !ok {
+		t.Fatal("満タンになる前に削除されました")
+	}
+
+	//満タンになっても前回の削除からsweepInterval経たなければ削除しない
+	allowN(l, "d", limit, swept.Add(time.Second), 1)
+	if _, ok := l.buckets["a"]; !ok {
+		t.Error("sweepIntervalが経つ前に削除されました")
+	}
+
+	//最後に使われてから満タンまで補充されたバケットだけ削除する
+	allowN(l, "e", limit, swept.Add(sweepInterval), 1)
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("満タンになったバケットが残っています")
+	}
+	if _, ok := l.buckets["c"]; !ok {
+		t.Error("満タンになる前のバケットが削除されました")
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：リクエストID
+//
+//　X-Request-IDヘッダの値（なければ生成した値）をリクエストのcontextに入れてレスポンスにも返す
+//　ハンドラでlogger.FromContext(r.Context())を使うとログにリクエストIDが付く
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//RequestIDMiddleware リクエストIDを付けるミドルウェア
+type RequestIDMiddleware struct{}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//MiddlewareFunc rest.Middlewareの実装
+func (mw *RequestIDMiddleware) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		id := r.Header.Get(logger.RequestIDHeader)
+		if !logger.ValidRequestID(id) {
+			id = logger.NewRequestID()
+		}
+		w.Header().Set(logger.RequestIDHeader, id)
+		r.Request = r.Request.WithContext(logger.NewContext(r.Context(), id))
+
+		start := time.Now()
+		writer := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			//クエリにはAPIキーが含まれることがあるのでパスだけ書く
+			logger.WithRequestID(id).Debugf("%s %s %d %v", r.Method, r.URL.Path, writer.status, time.Since(start))
+		}()
+		handler(writer, r)
+	}
+}
@@ -0,0 +1,241 @@
+package rdb
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  定数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Scope APIキーで許可する操作
+type Scope string
+
+const (
+	//ScopeRead 公開APIの参照
+	ScopeRead Scope = "read"
+	//ScopeIngest スクレイパーからの台数・マスタの登録
+	ScopeIngest Scope = "ingest"
+	//ScopeUsers ユーザー設定の参照・更新（LINE bot）
+	ScopeUsers Scope = "users"
+	//ScopeConfig 設定（/private/config）の参照
+	ScopeConfig Scope = "config"
+	//ScopeAdmin APIキーの管理（全ての操作を許可する）
+	ScopeAdmin Scope = "admin"
+)
+
+//Scopes 全てのスコープ
+var Scopes = []Scope{ScopeRead, ScopeIngest, ScopeUsers, ScopeConfig, ScopeAdmin}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  構造体
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Apikey APIキー（秘密文字列はSHA-256のハッシュのみ保存する）
+//ローテーション後もPreviousUntilまでは前の秘密文字列（PreviousHash）を受け付ける
+type Apikey struct {
+	ID            string
+	Name          string
+	Hash          string
+	PreviousHash  string
+	PreviousUntil time.Time
+	Scopes        []Scope
+	//RateLimit 1分あたりのリクエスト数の上限（0ならapp.iniのRATE_LIMIT_KEY）
+	RateLimit int
+	Usage     int64
+	LastUsed  time.Time
+	Created   time.Time
+	//Revoked 無効にした日時（有効ならゼロ値）
+	Revoked time.Time
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//NewApikeySecret 秘密文字列とそのハッシュを作る
+func NewApikeySecret() (secret, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret = hex.EncodeToString(b)
+	return secret, HashApikey(secret), nil
+}
+
+//NewApikeyID 公開してよいキーの識別子を作る
+func NewApikeyID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//HashApikey 秘密文字列のハッシュ
+func HashApikey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+//ParseScopes カンマ区切りのスコープ（不明なものは無視する）
+func ParseScopes(value string) []Scope {
+	var scopes []Scope
+	for _, s := range strings.Split(value, ",") {
+		for _, scope := range Scopes {
+			if strings.TrimSpace(s) == string(scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
+}
+
+//JoinScopes スコープをカンマ区切りにする（ParseScopesで戻せる）
+func JoinScopes(scopes []Scope) string {
+	var arr []string
+	for _, scope := range scopes {
+		arr = append(arr, string(scope))
+	}
+	return strings.Join(arr, ",")
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Allows スコープが許可されているか（adminは全て許可）
+func (k Apikey) Allows(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+//Active 有効か
+func (k Apikey) Active() bool {
+	return k.Revoked.IsZero()
+}
+
+//Matches 秘密文字列のハッシュが一致するか（ローテーション前のものはPreviousUntilまで）
+func (k Apikey) Matches(hash string, now time.Time) bool {
+	if hash == k.Hash {
+		return true
+	}
+	return k.PreviousHash != "" && hash == k.PreviousHash && wallClock(now).Before(wallClock(k.PreviousUntil))
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  sqlStore
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//SearchApikeys 全てのAPIキーを取得（作成日時の昇順）
+func (s *sqlStore) SearchApikeys() ([]Apikey, error) {
+	qry := `select id, name, hash, coalesce(previous_hash, ''), previous_until, scopes,
+	rate_limit, usage, last_used, created, revoked
+	from ` + s.table("apikey") + " order by created, id"
+	rows, err := s.db.Query(qry)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var es []Apikey
+	for rows.Next() {
+		var e Apikey
+		var scopes string
+		var previousUntil, lastUsed, created, revoked nullTime
+		err := rows.Scan(&e.ID, &e.Name, &e.Hash, &e.PreviousHash, &previousUntil, &scopes,
+			&e.RateLimit, &e.Usage, &lastUsed, &created, &revoked)
+		if err != nil {
+			continue
+		}
+		e.Scopes = ParseScopes(scopes)
+		e.PreviousUntil, e.LastUsed, e.Created, e.Revoked = previousUntil.Time, lastUsed.Time, created.Time, revoked.Time
+		es = append(es, e)
+	}
+	return es, nil
+}
+
+//UpsertApikey APIキーを保存（同じIDがあれば上書き、利用回数と最終利用日時は変えない）
+func (s *sqlStore) UpsertApikey(k Apikey) error {
+	columns := "id, name, hash, previous_hash, previous_until, scopes, rate_limit, created, revoked"
+	var holders []string
+	for i := 1; i <= 9; i++ {
+		holders = append(holders, s.placeholder(i))
+	}
+	qry := "insert into " + s.table("apikey") + " (" + columns + ") values (" + strings.Join(holders, ",") + `)
+	on conflict (id) do update set
+	name = excluded.name, hash = excluded.hash, previous_hash = excluded.previous_hash,
+	previous_until = excluded.previous_until, scopes = excluded.scopes,
+	rate_limit = excluded.rate_limit, revoked = excluded.revoked`
+	var previousHash interface{}
+	if k.PreviousHash != "" {
+		previousHash = k.PreviousHash
+	}
+	_, err := s.db.Exec(qry, k.ID, k.Name, k.Hash, previousHash, nullTimeValue(k.PreviousUntil),
+		JoinScopes(k.Scopes), k.RateLimit, k.Created.Format(TimeLayout), nullTimeValue(k.Revoked))
+	return err
+}
+
+//AddApikeyUsage 利用回数を加算し最終利用日時を更新する
+func (s *sqlStore) AddApikeyUsage(id string, count int64, lastUsed time.Time) error {
+	qry := "update " + s.table("apikey") + " set usage = usage + " + s.placeholder(1) +
+		", last_used = " + s.placeholder(2) + " where id = " + s.placeholder(3)
+	_, err := s.db.Exec(qry, count, lastUsed.Format(TimeLayout), id)
+	return err
+}
+
+//nullTimeValue ゼロ値ならNULLにする
+func nullTimeValue(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Format(TimeLayout)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  MemoryStore
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//SearchApikeys 全てのAPIキーを取得（作成日時の昇順）
+func (s *MemoryStore) SearchApikeys() ([]Apikey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Apikey{}, s.apikeys...), nil
+}
+
+//UpsertApikey APIキーを保存（同じIDがあれば上書き、利用回数と最終利用日時は変えない）
+func (s *MemoryStore) UpsertApikey(k Apikey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.apikeys {
+		if e.ID == k.ID {
+			k.Usage, k.LastUsed = e.Usage, e.LastUsed
+			s.apikeys[i] = k
+			return nil
+		}
+	}
+	k.Usage, k.LastUsed = 0, time.Time{}
+	s.apikeys = append(s.apikeys, k)
+	return nil
+}
+
+//AddApikeyUsage 利用回数を加算し最終利用日時を更新する
+func (s *MemoryStore) AddApikeyUsage(id string, count int64, lastUsed time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.apikeys {
+		if e.ID == id {
+			s.apikeys[i].Usage += count
+			s.apikeys[i].LastUsed = wallClock(lastUsed)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package rdb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：台数の予測
+//
+//　過去の同じ曜日・同じ時刻の台数から、指定した時刻の台数を予測する
+//　analyzeと日毎のSQLiteの両方をSearchCountsByRangeで横断して検索する
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//forecastZ 信頼区間（80%）に使う標準正規分布の値
+const forecastZ = 1.2816
+
+//ErrNoSamples 予測に使える過去の台数がない
+var ErrNoSamples = errors.New("予測に使える過去の台数がありません")
+
+//Forecast 台数の予測結果
+type Forecast struct {
+	Area, Spot string
+	At         time.Time
+	//Count 予測した台数（過去の台数の平均）
+	Count float64
+	//Lower, Upper 80%の信頼区間（0未満にはならない）
+	Lower, Upper float64
+	//Probability 1台以上ある確率（過去に1台以上あった割合）
+	Probability float64
+	//Samples 予測に使った過去の台数（古い順）
+	Samples []Spotinfo
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//ForecastCount atの台数を過去weeks週分の同じ曜日・時刻の台数から予測する
+//各週ではatと同じ時刻以前で最も近い台数（なければ以後で最も近い台数）をwindowの範囲で探す
+func ForecastCount(live Store, area, spot string, at time.Time, weeks int, window time.Duration) (Forecast, error) {
+	forecast := Forecast{Area: area, Spot: spot, At: at}
+	var counts []float64
+	for week := weeks; week >= 1; week-- {
+		target := at.AddDate(0, 0, -7*week)
+		spotinfos, err := SearchCountsByRange(live, area, spot, target.Add(-window), target.Add(window))
+		if err != nil {
+			return forecast, err
+		}
+		sample, ok := nearestSpotinfo(spotinfos, target)
+		if !ok {
+			continue
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(sample.Count))
+		if err != nil {
+			continue
+		}
+		forecast.Samples = append(forecast.Samples, sample)
+		counts = append(counts, float64(count))
+	}
+	if len(counts) == 0 {
+		return forecast, fmt.Errorf("ForecastCount %w(area=%s, spot=%s)", ErrNoSamples, area, spot)
+	}
+
+	//平均と標準偏差
+	var sum, available float64
+	for _, count := range counts {
+		sum += count
+		if count > 0 {
+			available++
+		}
+	}
+	mean := sum / float64(len(counts))
+	var variance float64
+	for _, count := range counts {
+		variance += (count - mean) * (count - mean)
+	}
+	if len(counts) > 1 {
+		variance /= float64(len(counts) - 1)
+	}
+	margin := forecastZ * math.Sqrt(variance)
+
+	forecast.Count = mean
+	forecast.Lower = math.Max(0, mean-margin)
+	forecast.Upper = mean + margin
+	forecast.Probability = available / float64(len(counts))
+	return forecast, nil
+}
+
+//nearestSpotinfo target以前で最も近いデータ（なければtargetより後で最も近いデータ）を返す
+//spotinfosは時刻の昇順であること
+func nearestSpotinfo(spotinfos []Spotinfo, target time.Time) (Spotinfo, bool) {
+	if len(spotinfos) == 0 {
+		return Spotinfo{}, false
+	}
+	//targetより後で最初のデータ
+	i := sort.Search(len(spotinfos), func(i int) bool {
+		return spotinfos[i].Time.After(target)
+	})
+	if i > 0 {
+		return spotinfos[i-1], true
+	}
+	return spotinfos[0], true
+}
@@ -0,0 +1,62 @@
+package rdb
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：スポットマスタの履歴
+//
+//　名前や位置が変わると旧データにendtimeを入れて新しい行を追加するので
+//　同じarea, spotの行をstarttimeの順に並べたものが履歴になる
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//locationTolerance 緯度経度がこれ以上変わったら移動したとみなす（約10m）
+//取得元によって小数点以下の桁数が違うことがあるため、少しの差は無視する
+const locationTolerance = 0.0001
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//SearchSpotmasterHistory スポットのマスタの履歴を開始日時の昇順で検索
+func SearchSpotmasterHistory(store Store, area, spot string) ([]Spotmaster, error) {
+	option := SearchOptions{Area: area, Spot: spot}.Sort(Asc(ColumnStarttime))
+	return store.SearchSpotmaster(option)
+}
+
+//SpotmasterAt 履歴からtの時点で有効だったマスタを返す
+//最初の版より前なら最初の版、どの版の期間にも入らなければ直前の版を返す
+func SpotmasterAt(history []Spotmaster, t time.Time) (Spotmaster, bool) {
+	if len(history) == 0 {
+		return Spotmaster{}, false
+	}
+	t = wallClock(t)
+	found := history[0]
+	for _, m := range history {
+		if wallClock(m.Starttime).After(t) {
+			break
+		}
+		found = m
+	}
+	return found, true
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//SameLocation 位置が同じか（どちらかの緯度経度が不明なら同じとみなす）
+func (m Spotmaster) SameLocation(other Spotmaster) bool {
+	lat1, err1 := strconv.ParseFloat(strings.TrimSpace(m.Lat), 64)
+	lon1, err2 := strconv.ParseFloat(strings.TrimSpace(m.Lon), 64)
+	lat2, err3 := strconv.ParseFloat(strings.TrimSpace(other.Lat), 64)
+	lon2, err4 := strconv.ParseFloat(strings.TrimSpace(other.Lon), 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return true
+	}
+	return math.Abs(lat1-lat2) < locationTolerance && math.Abs(lon1-lon2) < locationTolerance
+}
@@ -0,0 +1,83 @@
+package rdb
+
+import (
+	"github.com/8245snake/bikeshare_api/src/lib/spotcache"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：有効なスポットマスタのキャッシュ
+//
+//　apiserverとgrapherで共有する（スポットごとの検索をDBに問い合わせずに済ませる）
+//　索引と入れ替えはspotcacheで行うので、読み込み直している間もロックなしで引ける
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//MasterCache 有効な（endtimeのない）スポットマスタのキャッシュ（ゼロ値は空のキャッシュ）
+type MasterCache struct {
+	cache spotcache.Cache
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Load storeから有効なスポットマスタをエリア・スポットの順に読み込んで入れ替える（失敗したら前のまま）
+func (c *MasterCache) Load(store Store) (int, error) {
+	option := SearchOptions{}.Where(IsNull(ColumnEndtime)).Sort(Asc(ColumnArea), Asc(ColumnSpot))
+	masters, err := store.SearchSpotmaster(option)
+	if err != nil {
+		return 0, err
+	}
+	c.Set(masters)
+	return len(masters), nil
+}
+
+//Set キャッシュを入れ替える
+func (c *MasterCache) Set(masters []Spotmaster) {
+	entries := make([]spotcache.Entry, len(masters))
+	for i, m := range masters {
+		entries[i] = spotcache.Entry{Area: m.Area, Spot: m.Spot, Name: m.Name, Station: m.Station, Value: m}
+	}
+	c.cache.Set(entries)
+}
+
+//Len キャッシュしたスポットマスタの数
+func (c *MasterCache) Len() int {
+	return c.cache.Len()
+}
+
+//All キャッシュした全てのスポットマスタ（エリア・スポットの順）
+func (c *MasterCache) All() []Spotmaster {
+	return masters(c.cache.All())
+}
+
+//Find エリアとスポットのコードで引く
+func (c *MasterCache) Find(area, spot string) (Spotmaster, bool) {
+	entry, ok := c.cache.Get(area, spot)
+	if !ok {
+		return Spotmaster{}, false
+	}
+	return entry.Value.(Spotmaster), true
+}
+
+//ByName 名前がprefixで始まるスポットマスタ（名前の順、limitが0なら全て）
+func (c *MasterCache) ByName(prefix string, limit int) []Spotmaster {
+	return masters(c.cache.ByName(prefix, limit))
+}
+
+//ByStation 最寄り駅がprefixで始まるスポットマスタ（最寄り駅の順、limitが0なら全て）
+func (c *MasterCache) ByStation(prefix string, limit int) []Spotmaster {
+	return masters(c.cache.ByStation(prefix, limit))
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  その他関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//masters キャッシュの値をスポットマスタに戻す
+func masters(entries []spotcache.Entry) []Spotmaster {
+	arr := make([]Spotmaster, len(entries))
+	for i, e := range entries {
+		arr[i] = e.Value.(Spotmaster)
+	}
+	return arr
+}
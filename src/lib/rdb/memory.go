@@ -0,0 +1,590 @@
+package rdb
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  構造体
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//MemoryStore メモリ上にデータを保持するStoreの実装（開発・テスト用）
+//プロセス内でのみ共有されるため、複数のバイナリ間でデータを共有したい場合はSQLiteを使う
+type MemoryStore struct {
+	mu        sync.RWMutex
+	spotinfos []Spotinfo
+	analyzes  []Analyze
+	masters   []Spotmaster
+	rollups   []Rollup
+	configs   []ConfigDB
+	users     []static.JUser
+	apikeys   []Apikey
+}
+
+//memoryColumn i番目のレコードから列の値を取り出す関数
+type memoryColumn func(i int, column Column) (interface{}, bool)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//NewMemoryStore 空のMemoryStoreを作成
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+//wallClock タイムゾーンを無視した時刻にする（DBのtimestamp without time zoneと同じ比較をするため）
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+//memoryValue 比較用に値を正規化する（時刻はタイムゾーンを無視し、それ以外は前後の空白を除いた文字列にする）
+func memoryValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return wallClock(v)
+	case string:
+		return strings.TrimSpace(v)
+	}
+	return strings.TrimSpace(fmt.Sprint(value))
+}
+
+//compareMemory 列の値を比較する（countは数値として比較）
+func compareMemory(column Column, a, b interface{}) int {
+	a, b = memoryValue(a), memoryValue(b)
+	switch x := a.(type) {
+	case time.Time:
+		y, ok := b.(time.Time)
+		if !ok {
+			return strings.Compare(x.Format(TimeLayout), fmt.Sprint(b))
+		}
+		if x.Before(y) {
+			return -1
+		} else if x.After(y) {
+			return 1
+		}
+		return 0
+	case string:
+		y := fmt.Sprint(b)
+		if t, ok := b.(time.Time); ok {
+			y = t.Format(TimeLayout)
+		}
+		if column == ColumnCount {
+			xi, errx := strconv.Atoi(x)
+			yi, erry := strconv.Atoi(y)
+			if errx == nil && erry == nil {
+				return xi - yi
+			}
+		}
+		return strings.Compare(x, y)
+	}
+	return 0
+}
+
+//isEmptyMemory NULL相当（時刻のゼロ値、空文字）か判定
+func isEmptyMemory(value interface{}) bool {
+	switch v := memoryValue(value).(type) {
+	case time.Time:
+		return v.IsZero()
+	case string:
+		return v == ""
+	}
+	return false
+}
+
+//matchMemory i番目のレコードが検索条件に合うか判定
+func matchMemory(option SearchOptions, i int, column memoryColumn) (bool, error) {
+	for _, filter := range option.filters() {
+		v, ok := column(i, filter.Column)
+		if !ok {
+			return false, fmt.Errorf("MemoryStore %s列がないため検索できません", filter.Column)
+		}
+		var match bool
+		switch filter.Operator {
+		case OpEqual:
+			match = compareMemory(filter.Column, v, filter.Values[0]) == 0
+		case OpLess:
+			match = compareMemory(filter.Column, v, filter.Values[0]) < 0
+		case OpGreaterEqual:
+			match = compareMemory(filter.Column, v, filter.Values[0]) >= 0
+		case OpIn:
+			for _, want := range filter.Values {
+				if compareMemory(filter.Column, v, want) == 0 {
+					match = true
+					break
+				}
+			}
+		case OpContains:
+			match = strings.Contains(fmt.Sprint(v), fmt.Sprint(filter.Values[0]))
+		case OpIsNull:
+			//メモリ上ではNULLと空文字を区別しない
+			match = isEmptyMemory(v)
+		case OpEmpty:
+			match = isEmptyMemory(v)
+		default:
+			return false, fmt.Errorf("MemoryStore 演算子%sには対応していません", filter.Operator)
+		}
+		if !match {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+//selectMemory 検索条件に合うレコードの添字を返す（並べ替え、offset, limit適用済み）
+func selectMemory(option SearchOptions, n int, column memoryColumn) ([]int, error) {
+	for _, order := range option.Orders {
+		if n > 0 {
+			if _, ok := column(0, order.Column); !ok {
+				return nil, fmt.Errorf("MemoryStore %s列がないため並べ替えできません", order.Column)
+			}
+		}
+	}
+	hits := []int{}
+	for i := 0; i < n; i++ {
+		ok, err := matchMemory(option, i, column)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			hits = append(hits, i)
+		}
+	}
+	sort.SliceStable(hits, func(a, b int) bool {
+		for _, order := range option.Orders {
+			x, _ := column(hits[a], order.Column)
+			y, _ := column(hits[b], order.Column)
+			c := compareMemory(order.Column, x, y)
+			if c == 0 {
+				continue
+			}
+			return (c < 0) != order.Desc
+		}
+		return false
+	})
+	if option.Offset > 0 {
+		if option.Offset >= len(hits) {
+			return []int{}, nil
+		}
+		hits = hits[option.Offset:]
+	}
+	if option.Limit > 0 && option.Limit < len(hits) {
+		hits = hits[:option.Limit]
+	}
+	return hits, nil
+}
+
+//countColumn spotinfo, analyze共通の列
+func countColumn(area, spot string, t time.Time, count string, column Column) (interface{}, bool) {
+	switch column {
+	case ColumnArea:
+		return area, true
+	case ColumnSpot:
+		return spot, true
+	case ColumnTime:
+		return wallClock(t), true
+	case ColumnCount:
+		return count, true
+	case ColumnAreaSpot:
+		return strings.TrimSpace(area) + "-" + strings.TrimSpace(spot), true
+	}
+	return nil, false
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Ping 接続確認（常に成功）
+func (s *MemoryStore) Ping() error {
+	return nil
+}
+
+//Close 切断（何もしない）
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+//SearchSpotinfo spotinfoを検索
+func (s *MemoryStore) SearchSpotinfo(option SearchOptions) ([]Spotinfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hits, err := selectMemory(option, len(s.spotinfos), func(i int, column Column) (interface{}, bool) {
+		e := s.spotinfos[i]
+		return countColumn(e.Area, e.Spot, e.Time, e.Count, column)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var es []Spotinfo
+	for _, i := range hits {
+		es = append(es, s.spotinfos[i])
+	}
+	return es, nil
+}
+
+//BulkInsertSpotinfo spotinfoにまとめて追加（重複は無視）
+func (s *MemoryStore) BulkInsertSpotinfo(rows []Spotinfo) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exists := make(map[string]bool)
+	for _, e := range s.spotinfos {
+		exists[e.Area+"-"+e.Spot+e.Time.Format(TimeLayout)] = true
+	}
+	analyzed := make(map[string]bool)
+	for _, e := range s.analyzes {
+		analyzed[e.Area+"-"+e.Spot+e.Time.Format(TimeLayout)] = true
+	}
+	var affected int64
+	for _, row := range rows {
+		key := row.Area + "-" + row.Spot + row.Time.Format(TimeLayout)
+		if exists[key] {
+			continue
+		}
+		exists[key] = true
+		row.Time = wallClock(row.Time)
+		s.spotinfos = append(s.spotinfos, row)
+		affected++
+		//DBのトリガーと同様にanalyzeにも複製する（analyzeになければ）
+		if !analyzed[key] {
+			analyzed[key] = true
+			s.analyzes = append(s.analyzes, row.ToAnalyze())
+		}
+	}
+	return affected, nil
+}
+
+//DeleteSpotinfo spotinfoから削除
+func (s *MemoryStore) DeleteSpotinfo(option SearchOptions) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	option.Orders, option.Offset, option.Limit = nil, 0, 0
+	hits, err := selectMemory(option, len(s.spotinfos), func(i int, column Column) (interface{}, bool) {
+		e := s.spotinfos[i]
+		return countColumn(e.Area, e.Spot, e.Time, e.Count, column)
+	})
+	if err != nil {
+		return 0, err
+	}
+	removed := make(map[int]bool)
+	for _, i := range hits {
+		removed[i] = true
+	}
+	var rest []Spotinfo
+	for i, e := range s.spotinfos {
+		if !removed[i] {
+			rest = append(rest, e)
+		}
+	}
+	s.spotinfos = rest
+	return int64(len(hits)), nil
+}
+
+//SearchAnalyze analyzeを検索
+func (s *MemoryStore) SearchAnalyze(option SearchOptions) ([]Analyze, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hits, err := selectMemory(option, len(s.analyzes), func(i int, column Column) (interface{}, bool) {
+		e := s.analyzes[i]
+		return countColumn(e.Area, e.Spot, e.Time, e.Count, column)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var es []Analyze
+	for _, i := range hits {
+		es = append(es, s.analyzes[i])
+	}
+	return es, nil
+}
+
+//SearchAnalyzeDates analyzeに指定時刻より前のデータがある日付の一覧
+func (s *MemoryStore) SearchAnalyzeDates(before time.Time) ([]time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	before = wallClock(before)
+	found := make(map[time.Time]bool)
+	var dates []time.Time
+	for _, e := range s.analyzes {
+		if !e.Time.Before(before) {
+			continue
+		}
+		date := time.Date(e.Time.Year(), e.Time.Month(), e.Time.Day(), 0, 0, 0, 0, time.UTC)
+		if !found[date] {
+			found[date] = true
+			dates = append(dates, date)
+		}
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return dates, nil
+}
+
+//BulkInsertAnalyze analyzeにまとめて追加（重複は無視）
+func (s *MemoryStore) BulkInsertAnalyze(rows []Analyze) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exists := make(map[string]bool)
+	for _, e := range s.analyzes {
+		exists[e.Area+"-"+e.Spot+e.Time.Format(TimeLayout)] = true
+	}
+	for _, row := range rows {
+		key := row.Area + "-" + row.Spot + row.Time.Format(TimeLayout)
+		if exists[key] {
+			continue
+		}
+		exists[key] = true
+		row.Time = wallClock(row.Time)
+		s.analyzes = append(s.analyzes, row)
+	}
+	return nil
+}
+
+//DeleteAnalyze analyzeから削除
+func (s *MemoryStore) DeleteAnalyze(option SearchOptions) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	option.Orders, option.Offset, option.Limit = nil, 0, 0
+	hits, err := selectMemory(option, len(s.analyzes), func(i int, column Column) (interface{}, bool) {
+		e := s.analyzes[i]
+		return countColumn(e.Area, e.Spot, e.Time, e.Count, column)
+	})
+	if err != nil {
+		return 0, err
+	}
+	removed := make(map[int]bool)
+	for _, i := range hits {
+		removed[i] = true
+	}
+	var rest []Analyze
+	for i, e := range s.analyzes {
+		if !removed[i] {
+			rest = append(rest, e)
+		}
+	}
+	s.analyzes = rest
+	return int64(len(hits)), nil
+}
+
+//masterColumn spotmasterの列
+func masterColumn(e Spotmaster, column Column) (interface{}, bool) {
+	switch column {
+	case ColumnArea:
+		return e.Area, true
+	case ColumnSpot:
+		return e.Spot, true
+	case ColumnName:
+		return e.Name, true
+	case ColumnDescription:
+		return e.Description, true
+	case ColumnStarttime:
+		return wallClock(e.Starttime), true
+	case ColumnEndtime:
+		return wallClock(e.Endtime), true
+	case ColumnAreaSpot, ColumnSearchText:
+		return searchColumn(e.Area, e.Spot, e.Name, e.Station, column), true
+	}
+	return nil, false
+}
+
+//searchColumn area-spot形式のコードと自由検索用の文字列
+func searchColumn(area, spot, name, station string, column Column) string {
+	code := strings.TrimSpace(area) + "-" + strings.TrimSpace(spot)
+	if column == ColumnAreaSpot {
+		return code
+	}
+	return code + "," + name + station
+}
+
+//SearchSpotmaster spotmasterを検索
+func (s *MemoryStore) SearchSpotmaster(option SearchOptions) ([]Spotmaster, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hits, err := selectMemory(option, len(s.masters), func(i int, column Column) (interface{}, bool) {
+		return masterColumn(s.masters[i], column)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var es []Spotmaster
+	for _, i := range hits {
+		es = append(es, s.masters[i])
+	}
+	return es, nil
+}
+
+//UpsertSpotmaster spotmasterを更新（area, spot, starttimeが同じなら上書き）
+func (s *MemoryStore) UpsertSpotmaster(m Spotmaster) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.masters {
+		if e.Area == m.Area && e.Spot == m.Spot && wallClock(e.Starttime).Equal(wallClock(m.Starttime)) {
+			s.masters[i] = m
+			return nil
+		}
+	}
+	s.masters = append(s.masters, m)
+	return nil
+}
+
+//SearchCurrentFull 有効なマスタと最新の台数を結合して検索
+func (s *MemoryStore) SearchCurrentFull(option SearchOptions) ([]CurrentFull, error) {
+	s.mu.RLock()
+	latest := make(map[string]Spotinfo)
+	for _, e := range s.spotinfos {
+		key := e.Area + "-" + e.Spot
+		if old, ok := latest[key]; !ok || e.Time.After(old.Time) {
+			latest[key] = e
+		}
+	}
+	var views []CurrentFull
+	for _, m := range s.masters {
+		if !m.Endtime.IsZero() {
+			continue
+		}
+		info, ok := latest[m.Area+"-"+m.Spot]
+		if !ok {
+			continue
+		}
+		views = append(views, CurrentFull{Area: m.Area, Spot: m.Spot, Name: m.Name,
+			Count: info.Count, Time: info.Time, Lat: m.Lat, Lon: m.Lon,
+			Description: m.Description, Station: m.Station})
+	}
+	s.mu.RUnlock()
+
+	hits, err := selectMemory(option, len(views), func(i int, column Column) (interface{}, bool) {
+		e := views[i]
+		switch column {
+		case ColumnName:
+			return e.Name, true
+		case ColumnDescription:
+			return e.Description, true
+		case ColumnAreaSpot, ColumnSearchText:
+			return searchColumn(e.Area, e.Spot, e.Name, e.Station, column), true
+		}
+		return countColumn(e.Area, e.Spot, e.Time, e.Count, column)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var arr []CurrentFull
+	for _, i := range hits {
+		arr = append(arr, views[i])
+	}
+	return arr, nil
+}
+
+//SearchRollups 集計を検索
+func (s *MemoryStore) SearchRollups(option SearchOptions) ([]Rollup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hits, err := selectMemory(option, len(s.rollups), func(i int, column Column) (interface{}, bool) {
+		e := s.rollups[i]
+		if column == ColumnGranularity {
+			return string(e.Granularity), true
+		}
+		return countColumn(e.Area, e.Spot, e.Time, strconv.Itoa(e.Last), column)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var es []Rollup
+	for _, i := range hits {
+		es = append(es, s.rollups[i])
+	}
+	return es, nil
+}
+
+//UpsertRollups 集計を保存（同じ単位・スポット・時刻があれば上書き）
+func (s *MemoryStore) UpsertRollups(rollups []Rollup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index := make(map[string]int)
+	for i, e := range s.rollups {
+		index[string(e.Granularity)+e.Area+"-"+e.Spot+e.Time.Format(TimeLayout)] = i
+	}
+	for _, r := range rollups {
+		r.Time = wallClock(r.Time)
+		key := string(r.Granularity) + r.Area + "-" + r.Spot + r.Time.Format(TimeLayout)
+		if i, ok := index[key]; ok {
+			s.rollups[i] = r
+			continue
+		}
+		index[key] = len(s.rollups)
+		s.rollups = append(s.rollups, r)
+	}
+	return nil
+}
+
+//SearchConfig configを検索
+func (s *MemoryStore) SearchConfig(option SearchOptions) ([]ConfigDB, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hits, err := selectMemory(option, len(s.configs), func(i int, column Column) (interface{}, bool) {
+		e := s.configs[i]
+		switch column {
+		case ColumnHostID:
+			return e.HostID, true
+		}
+		return nil, false
+	})
+	if err != nil {
+		return nil, err
+	}
+	var es []ConfigDB
+	for _, i := range hits {
+		es = append(es, s.configs[i])
+	}
+	return es, nil
+}
+
+//SetConfig 設定を登録する（メモリ上のみ。開発時に初期値を入れるため）
+func (s *MemoryStore) SetConfig(conf ConfigDB) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.configs {
+		if e.Key == conf.Key && e.HostID == conf.HostID {
+			s.configs[i] = conf
+			return
+		}
+	}
+	s.configs = append(s.configs, conf)
+}
+
+//GetAllUsers ユーザー設定をすべて取得
+func (s *MemoryStore) GetAllUsers() ([]static.JUser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var users []static.JUser
+	for _, u := range s.users {
+		u.Favorites = append([]string{}, u.Favorites...)
+		u.Histories = append([]string{}, u.Histories...)
+		u.Notifies = append([]string{}, u.Notifies...)
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+//UpsertUser ユーザがあればUpdate無ければInsert
+func (s *MemoryStore) UpsertUser(user *static.JUser) error {
+	if user.LineID == "" && user.SlackID == "" {
+		return fmt.Errorf("[ERROR]UpsertUser IDが不明です")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rest []static.JUser
+	for _, u := range s.users {
+		if (user.LineID != "" && u.LineID == user.LineID) || (user.LineID == "" && u.SlackID == user.SlackID) {
+			continue
+		}
+		rest = append(rest, u)
+	}
+	s.users = append(rest, *user)
+	return nil
+}
@@ -0,0 +1,220 @@
+package rdb
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/config"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  定数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//MigrationSet マイグレーションの種類
+type MigrationSet string
+
+const (
+	//MigrationSetLive 稼働中のDB（Postgres または DRIVER=sqlite3 のファイル）
+	MigrationSetLive MigrationSet = "live"
+	//MigrationSetArchive 日毎のSQLite（data/yyyy-mm-dd.db）
+	MigrationSetArchive MigrationSet = "archive"
+)
+
+//schemaVersionTable 適用済みのバージョンを記録するテーブル
+const schemaVersionTable = "schema_migrations"
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  構造体
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Migration 番号付きのスキーマ変更（Upで適用、Downで取り消す）
+type Migration struct {
+	Version  int
+	Name     string
+	Up, Down string
+}
+
+//Migrator マイグレーションの実行
+type Migrator struct {
+	db         *sql.DB
+	driver     DriverType
+	migrations []Migration
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Migrations 方言と種類に応じたマイグレーションの一覧（Versionの昇順）
+func Migrations(driver DriverType, set MigrationSet) ([]Migration, error) {
+	switch {
+	case set == MigrationSetArchive:
+		return archiveMigrations, nil
+	case set == MigrationSetLive && driver == DriverTypePostgres:
+		return psqlLiveMigrations, nil
+	case set == MigrationSetLive && driver == DriverTypeSQLite3:
+		return sqliteLiveMigrations, nil
+	}
+	return nil, fmt.Errorf("Migrations %s(%s)のマイグレーションはありません", set, driver)
+}
+
+//NewMigrator コネクションを包んだMigratorを作成
+func NewMigrator(db *sql.DB, driver DriverType, set MigrationSet) (*Migrator, error) {
+	migrations, err := Migrations(driver, set)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, driver: driver, migrations: migrations}, nil
+}
+
+//OpenMigrator app.iniの設定（[DB] DRIVER）に従ってliveのMigratorを作成する
+func OpenMigrator() (*Migrator, error) {
+	conf, err := config.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+	db, driver, err := openLiveDB(conf)
+	if err != nil {
+		return nil, err
+	}
+	m, err := NewMigrator(db, driver, MigrationSetLive)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+//OpenArchiveMigrator 日毎のSQLiteファイルのMigratorを作成する
+func OpenArchiveMigrator(path string) (*Migrator, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	return NewMigrator(db, DriverTypeSQLite3, MigrationSetArchive)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Close 切断
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+//Latest 最新のバージョン
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+//Version 適用済みのバージョン（未適用なら0）
+func (m *Migrator) Version() (int, error) {
+	if err := m.ensureVersionTable(); err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	err := m.db.QueryRow("select max(version) from " + m.table()).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+//Up 指定したバージョンまで適用する（0以下なら最新まで）
+func (m *Migrator) Up(to int) ([]Migration, error) {
+	if to <= 0 {
+		to = m.Latest()
+	}
+	current, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, migration := range m.migrations {
+		if migration.Version <= current || migration.Version > to {
+			continue
+		}
+		if err := m.apply(migration, true); err != nil {
+			return applied, err
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+//Down 指定したバージョンまで取り消す（指定したバージョン自体は残る）
+func (m *Migrator) Down(to int) ([]Migration, error) {
+	if to < 0 {
+		return nil, fmt.Errorf("Down バージョン%dは指定できません", to)
+	}
+	current, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+	var reverted []Migration
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version > current || migration.Version <= to {
+			continue
+		}
+		if err := m.apply(migration, false); err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, migration)
+	}
+	return reverted, nil
+}
+
+//apply 1件分をトランザクション内で実行しバージョンを記録する
+func (m *Migrator) apply(migration Migration, up bool) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	qry := migration.Down
+	if up {
+		qry = migration.Up
+	}
+	if _, err := tx.Exec(qry); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("マイグレーション%d(%s)の実行に失敗しました : %v", migration.Version, migration.Name, err)
+	}
+	d := dialect(m.driver)
+	if up {
+		qry = "insert into " + m.table() + " (version, name, applied_at) values (" +
+			d.placeholder(1) + "," + d.placeholder(2) + "," + d.placeholder(3) + ")"
+		_, err = tx.Exec(qry, migration.Version, migration.Name, time.Now().Format(TimeLayout))
+	} else {
+		_, err = tx.Exec("delete from "+m.table()+" where version = "+d.placeholder(1), migration.Version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//ensureVersionTable バージョン管理テーブルがなければ作る
+func (m *Migrator) ensureVersionTable() error {
+	qry := "create table if not exists " + m.table() + ` (
+		version integer not null primary key,
+		name text not null,
+		applied_at character (20) not null
+	)`
+	_, err := m.db.Exec(qry)
+	return err
+}
+
+//table 方言に合わせたバージョン管理テーブル名
+func (m *Migrator) table() string {
+	if m.driver == DriverTypePostgres {
+		return "public." + schemaVersionTable
+	}
+	return schemaVersionTable
+}
@@ -0,0 +1,130 @@
+package rdb
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+//schemaOf SQLiteのテーブル・ビュー・トリガーの定義（バージョン管理テーブルは除く）
+func schemaOf(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	rows, err := db.Query(`select type || ' ' || name || ' ' || coalesce(sql, '') from sqlite_master
+		where name not like 'sqlite_%' and name <> ? order by type, name`, schemaVersionTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var schema []string
+	for rows.Next() {
+		var def string
+		if err := rows.Scan(&def); err != nil {
+			t.Fatal(err)
+		}
+		schema = append(schema, def)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+//checkVersion 適用済みのバージョン
+func checkVersion(t *testing.T, m *Migrator, step string, want int) {
+	t.Helper()
+	version, err := m.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != want {
+		t.Errorf("%s : バージョン %d（期待値 %d）", step, version, want)
+	}
+}
+
+//TestMigratorRoundTrip SQLiteのファイルに最新まで適用→全て取り消す→もう一度適用できる
+func TestMigratorRoundTrip(t *testing.T) {
+	for _, set := range []MigrationSet{MigrationSetLive, MigrationSetArchive} {
+		t.Run(string(set), func(t *testing.T) {
+			db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "migrate.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			m, err := NewMigrator(db, DriverTypeSQLite3, set)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			applied, err := m.Up(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(applied) != len(m.migrations) {
+				t.Errorf("Up %d件適用しました（期待値 %d）", len(applied), len(m.migrations))
+			}
+			checkVersion(t, m, "Up", m.Latest())
+			schema := schemaOf(t, db)
+			if len(schema) == 0 {
+				t.Fatal("Up テーブルが作られていません")
+			}
+
+			reverted, err := m.Down(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reverted) != len(m.migrations) {
+				t.Errorf("Down %d件取り消しました（期待値 %d）", len(reverted), len(m.migrations))
+			}
+			checkVersion(t, m, "Down", 0)
+			if rest := schemaOf(t, db); len(rest) > 0 {
+				t.Errorf("Down 残っています : %v", rest)
+			}
+
+			if _, err := m.Up(0); err != nil {
+				t.Fatal(err)
+			}
+			checkVersion(t, m, "もう一度Up", m.Latest())
+			if again := schemaOf(t, db); !reflect.DeepEqual(again, schema) {
+				t.Errorf("もう一度Up\n %v\n 期待値 %v", again, schema)
+			}
+		})
+	}
+}
+
+//TestMigratorDownKeepsSpotmaster liveのVersion4を取り消してもspotmasterの行とcurrent_fullは残る
+func TestMigratorDownKeepsSpotmaster(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "migrate.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	m, err := NewMigrator(db, DriverTypeSQLite3, MigrationSetLive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(4); err != nil {
+		t.Fatal(err)
+	}
+	for _, qry := range []string{
+		`insert into spotmaster (area, spot, name, starttime, capacity) values ('A1', '001', '東京駅', '2023/11/15 07:00:00', 20)`,
+		`insert into spotinfo (area, spot, time, count) values ('A1', '001', '2023/11/15 07:00:00', '5')`,
+	} {
+		if _, err := db.Exec(qry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Down(3); err != nil {
+		t.Fatal(err)
+	}
+	var name, count string
+	if err := db.QueryRow(`select name, count from current_full where area = 'A1' and spot = '001'`).Scan(&name, &count); err != nil {
+		t.Fatal(err)
+	}
+	if name != "東京駅" || count != "5" {
+		t.Errorf("current_full %s %s", name, count)
+	}
+	if _, err := db.Exec(`select capacity from spotmaster`); err == nil {
+		t.Error("capacityが残っています")
+	}
+}
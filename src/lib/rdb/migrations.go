@@ -0,0 +1,328 @@
+package rdb
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  マイグレーション定義
+//
+//　追加するときは末尾にVersionを1つ増やして足す（適用済みのものは書き換えない）
+//　Version1は手作業で作られた既存の環境にも適用できるよう if not exists で書いている
+//　spotinfoは直近のデータのみ保持し（archiverが削除する）、履歴はトリガーでanalyzeに複製する
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//psqlLiveMigrations Postgres（live）
+var psqlLiveMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_tables",
+		Up: `
+		create table if not exists public.spotinfo (
+			time timestamp not null,
+			area character (3) not null,
+			spot character (3) not null,
+			count character (3),
+			primary key (area, spot, time)
+		);
+		create table if not exists public.analyze (
+			time timestamp not null,
+			area character (3) not null,
+			spot character (3) not null,
+			count character (3),
+			primary key (area, spot, time)
+		);
+		create table if not exists public.spotmaster (
+			area character (3) not null,
+			spot character (3) not null,
+			name text not null,
+			lat text,
+			lon text,
+			starttime timestamp not null,
+			endtime timestamp,
+			description text,
+			station text,
+			constraint spotmaster_pkey primary key (area, spot, starttime)
+		);
+		create table if not exists public.config (
+			key text not null,
+			value text,
+			hostid text not null default '',
+			primary key (key, hostid)
+		);
+		create table if not exists public.user (
+			line_id text,
+			slack_id text
+		);
+		create table if not exists public.line (
+			id text not null,
+			key text not null,
+			value text,
+			seq integer not null default 0
+		);
+		`,
+		Down: `
+		drop table if exists public.line;
+		drop table if exists public.user;
+		drop table if exists public.config;
+		drop table if exists public.spotmaster;
+		drop table if exists public.analyze;
+		drop table if exists public.spotinfo;
+		`,
+	},
+	{
+		Version: 2,
+		Name:    "create_current_full",
+		Up: `
+		create or replace view public.current_full as
+		select m.area, m.spot, m.name, s.count, s.time, m.lat, m.lon,
+			coalesce(m.description, '') as description, coalesce(m.station, '') as station
+		from public.spotmaster m
+		join (
+			select distinct on (area, spot) area, spot, time, count
+			from public.spotinfo
+			order by area, spot, time desc
+		) s on s.area = m.area and s.spot = m.spot
+		where m.endtime is null;
+		`,
+		Down: `drop view if exists public.current_full;`,
+	},
+	{
+		Version: 3,
+		Name:    "copy_spotinfo_to_analyze",
+		Up: `
+		create or replace function public.copy_spotinfo_to_analyze() returns trigger as $$
+		begin
+			insert into public.analyze (time, area, spot, count)
+			values (new.time, new.area, new.spot, new.count)
+			on conflict do nothing;
+			return new;
+		end;
+		$$ language plpgsql;
+		drop trigger if exists spotinfo_to_analyze on public.spotinfo;
+		create trigger spotinfo_to_analyze after insert on public.spotinfo
+		for each row execute procedure public.copy_spotinfo_to_analyze();
+		`,
+		Down: `
+		drop trigger if exists spotinfo_to_analyze on public.spotinfo;
+		drop function if exists public.copy_spotinfo_to_analyze();
+		`,
+	},
+	{
+		Version: 4,
+		Name:    "create_rollup",
+		Up: `
+		alter table public.spotmaster add column if not exists capacity integer;
+		create table if not exists public.rollup (
+			granularity character (4) not null,
+			area character (3) not null,
+			spot character (3) not null,
+			time timestamp not null,
+			min_count integer not null,
+			max_count integer not null,
+			avg_count double precision not null,
+			last_count integer not null,
+			samples integer not null,
+			empty_minutes integer not null,
+			full_minutes integer not null,
+			capacity integer,
+			primary key (granularity, area, spot, time)
+		);
+		`,
+		Down: `
+		drop table if exists public.rollup;
+		alter table public.spotmaster drop column if exists capacity;
+		`,
+	},
+	{
+		Version: 5,
+		Name:    "create_apikey",
+		Up: `
+		create table if not exists public.apikey (
+			id text not null primary key,
+			name text not null,
+			hash text not null,
+			previous_hash text,
+			previous_until timestamp,
+			scopes text not null,
+			rate_limit integer not null default 0,
+			usage bigint not null default 0,
+			last_used timestamp,
+			created timestamp not null,
+			revoked timestamp
+		);
+		`,
+		Down: `drop table if exists public.apikey;`,
+	},
+}
+
+//sqliteLiveMigrations SQLite（live）
+//SQLiteには日付型がないため時刻は TimeLayout の文字列で保存する
+var sqliteLiveMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_tables",
+		Up: `
+		create table if not exists spotinfo (
+			time character (20) not null,
+			area character (3) not null,
+			spot character (3) not null,
+			count character (3),
+			primary key (area, spot, time)
+		);
+		create table if not exists "analyze" (
+			time character (20) not null,
+			area character (3) not null,
+			spot character (3) not null,
+			count character (3),
+			primary key (area, spot, time)
+		);
+		create table if not exists spotmaster (
+			area character (3) not null,
+			spot character (3) not null,
+			name text not null,
+			lat text,
+			lon text,
+			starttime character (20) not null,
+			endtime character (20),
+			description text,
+			station text,
+			primary key (area, spot, starttime)
+		);
+		create table if not exists config (
+			key text not null,
+			value text,
+			hostid text not null default '',
+			primary key (key, hostid)
+		);
+		create table if not exists "user" (
+			line_id text,
+			slack_id text
+		);
+		create table if not exists line (
+			id text not null,
+			key text not null,
+			value text,
+			seq integer not null default 0
+		);
+		`,
+		Down: `
+		drop table if exists line;
+		drop table if exists "user";
+		drop table if exists config;
+		drop table if exists spotmaster;
+		drop table if exists "analyze";
+		drop table if exists spotinfo;
+		`,
+	},
+	{
+		Version: 2,
+		Name:    "create_current_full",
+		Up: `
+		create view if not exists current_full as
+		select m.area, m.spot, m.name, s.count, s.time, m.lat, m.lon,
+			coalesce(m.description, '') as description, coalesce(m.station, '') as station
+		from spotmaster m
+		join spotinfo s on s.area = m.area and s.spot = m.spot
+		where m.endtime is null
+		and s.time = (select max(x.time) from spotinfo x where x.area = s.area and x.spot = s.spot);
+		`,
+		Down: `drop view if exists current_full;`,
+	},
+	{
+		Version: 3,
+		Name:    "copy_spotinfo_to_analyze",
+		Up: `
+		create trigger if not exists spotinfo_to_analyze after insert on spotinfo
+		begin
+			insert or ignore into "analyze" (time, area, spot, count)
+			values (new.time, new.area, new.spot, new.count);
+		end;
+		`,
+		Down: `drop trigger if exists spotinfo_to_analyze;`,
+	},
+	{
+		//drop columnはSQLite 3.35以降なので、Downはspotmasterを作り直す（current_fullも作り直す）
+		Version: 4,
+		Name:    "create_rollup",
+		Up: `
+		alter table spotmaster add column capacity integer;
+		create table if not exists rollup (
+			granularity character (4) not null,
+			area character (3) not null,
+			spot character (3) not null,
+			time character (20) not null,
+			min_count integer not null,
+			max_count integer not null,
+			avg_count real not null,
+			last_count integer not null,
+			samples integer not null,
+			empty_minutes integer not null,
+			full_minutes integer not null,
+			capacity integer,
+			primary key (granularity, area, spot, time)
+		);
+		`,
+		Down: `
+		drop table if exists rollup;
+		drop view if exists current_full;
+		create table spotmaster_new (
+			area character (3) not null,
+			spot character (3) not null,
+			name text not null,
+			lat text,
+			lon text,
+			starttime character (20) not null,
+			endtime character (20),
+			description text,
+			station text,
+			primary key (area, spot, starttime)
+		);
+		insert into spotmaster_new (area, spot, name, lat, lon, starttime, endtime, description, station)
+		select area, spot, name, lat, lon, starttime, endtime, description, station from spotmaster;
+		drop table spotmaster;
+		alter table spotmaster_new rename to spotmaster;
+		create view current_full as
+		select m.area, m.spot, m.name, s.count, s.time, m.lat, m.lon,
+			coalesce(m.description, '') as description, coalesce(m.station, '') as station
+		from spotmaster m
+		join spotinfo s on s.area = m.area and s.spot = m.spot
+		where m.endtime is null
+		and s.time = (select max(x.time) from spotinfo x where x.area = s.area and x.spot = s.spot);
+		`,
+	},
+	{
+		Version: 5,
+		Name:    "create_apikey",
+		Up: `
+		create table if not exists apikey (
+			id text not null primary key,
+			name text not null,
+			hash text not null,
+			previous_hash text,
+			previous_until character (20),
+			scopes text not null,
+			rate_limit integer not null default 0,
+			usage integer not null default 0,
+			last_used character (20),
+			created character (20) not null,
+			revoked character (20)
+		);
+		`,
+		Down: `drop table if exists apikey;`,
+	},
+}
+
+//archiveMigrations 日毎のSQLite（アーカイブ）
+var archiveMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_spotinfo",
+		Up: `
+		create table if not exists spotinfo (
+			area character (3) not null,
+			spot character (3) not null,
+			time character (20) not null,
+			count character (3),
+			primary key (area, spot, time)
+		);
+		`,
+		Down: `drop table if exists spotinfo;`,
+	},
+}
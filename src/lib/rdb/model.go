@@ -2,10 +2,13 @@ package rdb
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"reflect"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/8245snake/bikeshare_api/src/lib/filer"
 	"github.com/8245snake/bikeshare_api/src/lib/static"
 )
 
@@ -21,6 +24,8 @@ const (
 	DriverTypePostgres DriverType = "postgres"
 	//DriverTypeSQLite3 SQLite
 	DriverTypeSQLite3 DriverType = "sqlite3"
+	//DriverTypeMemory メモリ（開発・テスト用）
+	DriverTypeMemory DriverType = "memory"
 )
 
 //ItemKeyLine 項目キー（LINE）
@@ -41,6 +46,9 @@ type ItemKeySlack string
 //TimeLayout 時刻フォーマット
 const TimeLayout = "2006/01/02 15:04:05"
 
+//maxBulkRows バルクインサート1回あたりの最大行数（SQLiteのバインド変数上限999を超えないようにする）
+const maxBulkRows = 200
+
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 //  構造体
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -60,17 +68,23 @@ type Analyze struct {
 }
 
 //Spotmaster スポットマスタ
+//Capacityはラック数（不明なら0）
 type Spotmaster struct {
 	Area, Spot, Name, Lat, Lon string
 	Starttime, Endtime         time.Time
 	Description, Station       string
+	Capacity                   int
 }
 
 //SearchOptions 検索オプション
+//From, Toは time >= From and time < To の範囲検索（ゼロ値なら条件なし）
+//それ以外の条件や並べ替えはWhere, Sortで追加する
 type SearchOptions struct {
-	Area, Spot, AddWhere, OrderBy string
-	Offset, Limit                 int
-	Time                          time.Time
+	Area, Spot     string
+	Offset, Limit  int
+	Time, From, To time.Time
+	Filters        []Filter
+	Orders         []Order
 }
 
 //CurrentFull 現在の台数
@@ -97,11 +111,21 @@ type ConfigSlack struct {
 	Seq            int
 }
 
+//nullTime DBから時刻を読み込むための型（SQLiteは日付型がないので文字列から変換する）
+type nullTime struct {
+	Time time.Time
+}
+
 //ServiceConfig 設定
 type ServiceConfig interface {
 	ServiceConfig()
 }
 
+//execer *sql.DBと*sql.Txの共通部分
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 //  レシーバ
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -139,34 +163,32 @@ func (s Analyze) TimeString() string {
 	return s.Time.Format(TimeLayout)
 }
 
-//GetSqlWhere 検索条件作成
-func (option SearchOptions) GetSqlWhere() string {
-	qry := ""
-	if option.Area != "" {
-		qry += fmt.Sprintf(" and area='%s' ", option.Area)
-	}
-	if option.Spot != "" {
-		qry += fmt.Sprintf(" and spot='%s' ", option.Spot)
-	}
-	if !option.Time.IsZero() {
-		qry += fmt.Sprintf(" and time='%s' ", option.Time.Format(TimeLayout))
+//Scan sql.Scannerの実装（NULLはゼロ値、文字列は時刻としてパースする）
+func (t *nullTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time = time.Time{}
+	case time.Time:
+		t.Time = v
+	case []byte:
+		return t.parse(string(v))
+	case string:
+		return t.parse(v)
+	default:
+		return fmt.Errorf("nullTime %Tは時刻に変換できません", value)
 	}
-	if option.AddWhere != "" {
-		qry += fmt.Sprintf(" and %s ", option.AddWhere)
-	}
-	if option.OrderBy != "" {
-		qry += fmt.Sprintf(" order by %s ", option.OrderBy)
-	}
-	if option.Offset != 0 {
-		qry += fmt.Sprintf(" offset %d ", option.Offset)
-	}
-	if option.Limit != 0 {
-		qry += fmt.Sprintf(" limit %d ", option.Limit)
-	}
-	if qry != "" {
-		qry = " where 1=1 " + qry
+	return nil
+}
+
+//parse SQLiteに保存されている形式の時刻をパースする
+func (t *nullTime) parse(value string) error {
+	for _, layout := range []string{TimeLayout, "2006-01-02 15:04:05", time.RFC3339, "2006/01/02"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			t.Time = parsed
+			return nil
+		}
 	}
-	return qry
+	return fmt.Errorf("nullTime %sは時刻に変換できません", value)
 }
 
 //ServiceConfig インターフェース用
@@ -179,16 +201,6 @@ func (conf ConfigSlack) ServiceConfig() {}
 //  関数
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-//IdentifyDriverType DBのタイプを取得
-func IdentifyDriverType(db *sql.DB) DriverType {
-	driverName := reflect.ValueOf(db.Driver()).Type().String()
-	if driverName == "*sqlite3.SQLiteDriver" {
-		return DriverTypeSQLite3
-	} else {
-		return DriverTypePostgres
-	}
-}
-
 //JudgeDBTypeByDate 日付を渡してどちらのDBを見るか判定する
 func JudgeDBTypeByDate(date time.Time) DriverType {
 	today := time.Now()
@@ -199,86 +211,176 @@ func JudgeDBTypeByDate(date time.Time) DriverType {
 	}
 }
 
-//SearchSpotinfoSingle 1件だけ取得
-func SearchSpotinfoSingle(db *sql.DB, option SearchOptions) (Spotinfo, error) {
-	qry := "SELECT time, trim(area), trim(spot), trim(count) FROM spotinfo "
-	qry += option.GetSqlWhere()
-	var obj Spotinfo
-	err := db.QueryRow(qry).Scan(&obj.Time, &obj.Area, &obj.Spot, &obj.Count)
-	return obj, err
+//SearchCountsByDay 指定日(yyyymmdd)のデータを検索する（live, SQLite振り分け）
+func SearchCountsByDay(live Store, area, spot, day string) ([]Spotinfo, error) {
+	var spotinfos []Spotinfo
+	//検索条件作成
+	option := SearchOptions{Area: area, Spot: spot}.Sort(Desc(ColumnTime))
+	date, err := time.Parse("20060102", day)
+	if err != nil {
+		//ゼロ値で初期化
+		date = time.Time{}
+	}
+
+	//どちらのDBを見るか
+	if JudgeDBTypeByDate(date) == DriverTypePostgres {
+		//今日か昨日ならPostgres
+		if date.IsZero() {
+			//日付未指定なら最新の1件のみ
+			option.Limit = 1
+		} else {
+			option.From = date
+			option.To = date.AddDate(0, 0, 1)
+		}
+		analyzes, err := live.SearchAnalyze(option)
+		if err != nil {
+			return spotinfos, err
+		}
+		//変換
+		for _, anal := range analyzes {
+			spotinfos = append(spotinfos, anal.ToSpotinfo())
+		}
+	} else {
+		//昨日より過去ならSQLite
+		archive, err := OpenArchiveStore(date, false)
+		if err != nil {
+			return spotinfos, err
+		}
+		defer archive.Close()
+		//SQLiteから検索
+		return archive.SearchSpotinfo(option)
+	}
+
+	return spotinfos, nil
+}
+
+//SearchCountsByRange 期間(from〜to)のデータを検索する（liveと日毎のSQLiteを横断し時刻の昇順で返す）
+func SearchCountsByRange(live Store, area, spot string, from, to time.Time) ([]Spotinfo, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("SearchCountsByRange fromがtoより後になっています")
+	}
+	//同じ時刻のデータは1件にまとめる（アーカイブ直後は両方のDBに存在することがある）
+	merged := make(map[int64]Spotinfo)
+	//toの時刻も含める
+	option := SearchOptions{Area: area, Spot: spot, From: from, To: to.Add(time.Second)}
+
+	//Postgres（アーカイブされていないデータは日付に関係なく全てここにある）
+	analyzes, err := live.SearchAnalyze(option)
+	if err != nil {
+		return nil, err
+	}
+	for _, anal := range analyzes {
+		merged[anal.Time.Unix()] = anal.ToSpotinfo()
+	}
+
+	//SQLite（ファイルがない日は飛ばす）
+	first := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	for date := first; !date.After(to); date = date.AddDate(0, 0, 1) {
+		archive, err := OpenArchiveStore(date, false)
+		if errors.Is(err, ErrArchiveNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		spotinfos, err := archive.SearchSpotinfo(option)
+		archive.Close()
+		if err != nil {
+			return nil, fmt.Errorf("SearchCountsByRange %sのアーカイブを検索できません : %v", date.Format(filer.ModTimeLayout("yyyy-mm-dd")), err)
+		}
+		for _, s := range spotinfos {
+			if _, ok := merged[s.Time.Unix()]; !ok {
+				merged[s.Time.Unix()] = s
+			}
+		}
+	}
+
+	//時刻順に並べる
+	spotinfos := make([]Spotinfo, 0, len(merged))
+	for _, s := range merged {
+		spotinfos = append(spotinfos, s)
+	}
+	sort.Slice(spotinfos, func(i, j int) bool {
+		return spotinfos[i].Time.Before(spotinfos[j].Time)
+	})
+	return spotinfos, nil
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  sqlStore（Postgres, SQLite共通のSQL）
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//table 方言に合わせてテーブル名を修飾する（analyze, userは予約語のため）
+func (s *sqlStore) table(name string) string {
+	if s.driver == DriverTypePostgres {
+		return "public." + name
+	}
+	return `"` + name + `"`
+}
+
+//placeholder n番目のバインド変数
+func (s *sqlStore) placeholder(n int) string {
+	return dialect(s.driver).placeholder(n)
+}
+
+//Ping 接続確認
+func (s *sqlStore) Ping() error {
+	return s.db.Ping()
+}
+
+//Close 切断
+func (s *sqlStore) Close() error {
+	return s.db.Close()
 }
 
 //SearchSpotinfo Spotinfoテーブルをspotとareaから検索
-func SearchSpotinfo(db *sql.DB, option SearchOptions) []Spotinfo {
+func (s *sqlStore) SearchSpotinfo(option SearchOptions) ([]Spotinfo, error) {
 	qry := "SELECT time, trim(area), trim(spot), trim(count) FROM spotinfo "
-	qry += option.GetSqlWhere()
+	where, args := option.GetSqlWhere(s.driver)
+	qry += where
 
-	rows, err := db.Query(qry)
+	rows, err := s.db.Query(qry, args...)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
 	defer rows.Close()
 
 	var es []Spotinfo
 	for rows.Next() {
 		var e Spotinfo
-		if IdentifyDriverType(db) == DriverTypeSQLite3 {
-			//SQLiteは日付型がないので特殊処理
-			var timestr string
-			err := rows.Scan(&timestr, &e.Area, &e.Spot, &e.Count)
-			if err != nil {
-				continue
-			}
-			e.Time, err = time.Parse(TimeLayout, timestr)
-			if err != nil {
-				continue
-			}
-		} else {
-			err := rows.Scan(&e.Time, &e.Area, &e.Spot, &e.Count)
-			if err != nil {
-				continue
-			}
+		var t nullTime
+		err := rows.Scan(&t, &e.Area, &e.Spot, &e.Count)
+		if err != nil {
+			continue
 		}
+		e.Time = t.Time
 		es = append(es, e)
 	}
-	return es
+	return es, nil
 }
 
 //BulkInsertSpotinfo スポット情報をバルクインサートする
-func BulkInsertSpotinfo(db *sql.DB, rows []Spotinfo) (int64, error) {
-	qry := "insert into spotinfo (time,area,spot,count) values "
-	template := "('%s','%s','%s','%s')"
-	values := ""
-
-	for i, row := range rows {
-		if i != 0 {
-			values += ","
-		}
-		values += fmt.Sprintf(template, row.Time.Format(TimeLayout), row.Area, row.Spot, row.Count)
+func (s *sqlStore) BulkInsertSpotinfo(rows []Spotinfo) (int64, error) {
+	var values [][]interface{}
+	for _, row := range rows {
+		values = append(values, []interface{}{row.Time.Format(TimeLayout), row.Area, row.Spot, row.Count})
 	}
-	qry += values + " on conflict do nothing"
-	result, err := db.Exec(qry)
-	RowsAffected, _ := result.RowsAffected()
-	return RowsAffected, err
+	return s.bulkInsert(s.db, "spotinfo", []string{"time", "area", "spot", "count"}, values)
 }
 
-//SearchAnalyzeSingle 1件だけ取得
-func SearchAnalyzeSingle(db *sql.DB, option SearchOptions) (Analyze, error) {
-	qry := "SELECT time, trim(area), trim(spot), trim(count) FROM public.analyze "
-	qry += option.GetSqlWhere()
-	var obj Analyze
-	err := db.QueryRow(qry).Scan(&obj.Time, &obj.Area, &obj.Spot, &obj.Count)
-	return obj, err
+//DeleteSpotinfo Spotinfoテーブルから削除
+func (s *sqlStore) DeleteSpotinfo(option SearchOptions) (int64, error) {
+	return s.delete("spotinfo", option)
 }
 
 //SearchAnalyze Analyzeテーブルをspotとareaから検索
-func SearchAnalyze(db *sql.DB, option SearchOptions) ([]Analyze, error) {
-	qry := "SELECT time, trim(area), trim(spot), trim(count) FROM public.analyze "
-	qry += option.GetSqlWhere()
+func (s *sqlStore) SearchAnalyze(option SearchOptions) ([]Analyze, error) {
+	qry := "SELECT time, trim(area), trim(spot), trim(count) FROM " + s.table("analyze") + " "
+	where, args := option.GetSqlWhere(s.driver)
+	qry += where
 
-	rows, err := db.Query(qry)
+	rows, err := s.db.Query(qry, args...)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -286,76 +388,65 @@ func SearchAnalyze(db *sql.DB, option SearchOptions) ([]Analyze, error) {
 	var es []Analyze
 	for rows.Next() {
 		var e Analyze
-		err := rows.Scan(&e.Time, &e.Area, &e.Spot, &e.Count)
+		var t nullTime
+		err := rows.Scan(&t, &e.Area, &e.Spot, &e.Count)
 		if err != nil {
 			continue
 		}
+		e.Time = t.Time
 		es = append(es, e)
 	}
 	return es, nil
 }
 
-//SearchCountsByDay 指定日(yyyymmdd)のデータを検索する（psql, SQLite振り分け）
-func SearchCountsByDay(psql *sql.DB, area, spot, day string) ([]Spotinfo, error) {
-	var spotinfos []Spotinfo
-	//検索条件作成
-	option := SearchOptions{Area: area, Spot: spot, OrderBy: "time desc"}
-	date, err := time.Parse("20060102", day)
+//SearchAnalyzeDates 指定時刻より前のデータが存在する日付の一覧を返す
+func (s *sqlStore) SearchAnalyzeDates(before time.Time) ([]time.Time, error) {
+	var qry, layout string
+	if s.driver == DriverTypePostgres {
+		qry = "select to_char(date(time),'YYYY-MM-DD') from public.analyze where time < $1 group by date(time) order by 1"
+		layout = "2006-01-02"
+	} else {
+		qry = `select substr(time,1,10) from "analyze" where time < ? group by substr(time,1,10) order by 1`
+		layout = "2006/01/02"
+	}
+	rows, err := s.db.Query(qry, before.Format(TimeLayout))
 	if err != nil {
-		//ゼロ値で初期化
-		date = time.Time{}
+		return nil, err
 	}
+	defer rows.Close()
 
-	//どちらのDBを見るか
-	if JudgeDBTypeByDate(date) == DriverTypePostgres {
-		//今日か昨日ならPostgres
-		if date.IsZero() {
-			//日付未指定なら最新の1件のみ
-			option.Limit = 1
-		} else {
-			option.AddWhere = fmt.Sprintf("date(time) = '%s'", date.Format("2006-01-02"))
-		}
-		analyzes, err := SearchAnalyze(psql, option)
-		if err != nil {
-			return spotinfos, err
-		}
-		//変換
-		for _, anal := range analyzes {
-			spotinfos = append(spotinfos, anal.ToSpotinfo())
+	var dates []time.Time
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			continue
 		}
-	} else {
-		//昨日より過去ならSQLite
-		db, err := GetConnectionSQLite(date, false)
+		date, err := time.Parse(layout, value)
 		if err != nil {
-			return spotinfos, err
+			continue
 		}
-		defer db.Close()
-		//SQLiteから検索
-		spotinfos = SearchSpotinfo(db, option)
+		dates = append(dates, date)
 	}
-
-	return spotinfos, nil
+	return dates, nil
 }
 
 //BulkInsertAnalyze スポット情報をバルクインサートする
-func BulkInsertAnalyze(db *sql.DB, rows []Analyze) error {
-	qry := "insert into public.analyze (time,area,spot,count) values "
-	template := "('%s','%s','%s','%s')"
-	values := ""
-
-	for i, row := range rows {
-		if i != 0 {
-			values += ","
-		}
-		values += fmt.Sprintf(template, row.Time.Format(TimeLayout), row.Area, row.Spot, row.Count)
+func (s *sqlStore) BulkInsertAnalyze(rows []Analyze) error {
+	var values [][]interface{}
+	for _, row := range rows {
+		values = append(values, []interface{}{row.Time.Format(TimeLayout), row.Area, row.Spot, row.Count})
 	}
-	qry += values + " on conflict do nothing"
-	_, err := db.Exec(qry)
+	_, err := s.bulkInsert(s.db, s.table("analyze"), []string{"time", "area", "spot", "count"}, values)
 	return err
 }
 
+//DeleteAnalyze Analyzeテーブルから削除
+func (s *sqlStore) DeleteAnalyze(option SearchOptions) (int64, error) {
+	return s.delete(s.table("analyze"), option)
+}
+
 //SearchSpotmaster マスタ検索
-func SearchSpotmaster(db *sql.DB, option SearchOptions) ([]Spotmaster, error) {
+func (s *sqlStore) SearchSpotmaster(option SearchOptions) ([]Spotmaster, error) {
 	qry := `select 
 	trim(area),
 	trim(spot),
@@ -365,13 +456,14 @@ func SearchSpotmaster(db *sql.DB, option SearchOptions) ([]Spotmaster, error) {
 	COALESCE(description, ''),
 	COALESCE(station, ''),
 	starttime,
-	COALESCE(endtime, '0001/01/01') 
+	endtime,
+	COALESCE(capacity, 0) 
 	from spotmaster `
-	qry += option.GetSqlWhere()
+	where, args := option.GetSqlWhere(s.driver)
+	qry += where
 
-	rows, err := db.Query(qry)
+	rows, err := s.db.Query(qry, args...)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -379,126 +471,170 @@ func SearchSpotmaster(db *sql.DB, option SearchOptions) ([]Spotmaster, error) {
 	var es []Spotmaster
 	for rows.Next() {
 		var e Spotmaster
-		err := rows.Scan(&e.Area, &e.Spot, &e.Name, &e.Lat, &e.Lon, &e.Description, &e.Station, &e.Starttime, &e.Endtime)
+		var start, end nullTime
+		err := rows.Scan(&e.Area, &e.Spot, &e.Name, &e.Lat, &e.Lon, &e.Description, &e.Station, &start, &end, &e.Capacity)
 		if err != nil {
 			continue
 		}
+		e.Starttime = start.Time
+		e.Endtime = end.Time
 		es = append(es, e)
 	}
 	return es, nil
 }
 
 //UpsertSpotmaster あればUpdate無ければInsert
-func UpsertSpotmaster(db *sql.DB, m Spotmaster) (err error) {
-
-	qry := `insert into spotmaster(area, spot, name, lat, lon, starttime, endtime, description, station) 
-	values ($1, $2, $3, $4, $5, $6, $7, $8, $9) 
+func (s *sqlStore) UpsertSpotmaster(m Spotmaster) (err error) {
+	qry := `insert into spotmaster(area, spot, name, lat, lon, starttime, endtime, description, station, capacity) 
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) 
 	on conflict on CONSTRAINT spotmaster_pkey do update 
-	set (area, spot, name, lat, lon, starttime, endtime, description, station) 
-	= ($1, $2, $3, $4, $5, $6, $7, $8, $9)  `
-
-	if m.Endtime.IsZero() {
-		_, err = db.Exec(qry, m.Area, m.Spot, m.Name, m.Lat, m.Lon, m.Starttime, nil, m.Description, m.Station)
-	} else {
-		_, err = db.Exec(qry, m.Area, m.Spot, m.Name, m.Lat, m.Lon, m.Starttime, m.Endtime, m.Description, m.Station)
+	set (area, spot, name, lat, lon, starttime, endtime, description, station, capacity) 
+	= ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)  `
+	if s.driver != DriverTypePostgres {
+		qry = `insert or replace into spotmaster(area, spot, name, lat, lon, starttime, endtime, description, station, capacity) 
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	}
 
+	var endtime, capacity interface{}
+	if !m.Endtime.IsZero() {
+		endtime = m.Endtime.Format(TimeLayout)
+	}
+	if m.Capacity > 0 {
+		capacity = m.Capacity
+	}
+	_, err = s.db.Exec(qry, m.Area, m.Spot, m.Name, m.Lat, m.Lon, m.Starttime.Format(TimeLayout), endtime, m.Description, m.Station, capacity)
 	return err
 }
 
-//SearchConfig 設定テーブル検索
-func SearchConfig(db *sql.DB, option SearchOptions) ([]ConfigDB, error) {
-	qry := "SELECT trim(key), trim(value), trim(hostid) FROM public.config "
-	qry += option.GetSqlWhere()
-
-	rows, err := db.Query(qry)
+//SearchCurrentFull ビュー検索
+func (s *sqlStore) SearchCurrentFull(option SearchOptions) ([]CurrentFull, error) {
+	qry := `select 
+	trim(area),trim(spot),trim(name),
+	trim(count),time,lat,lon,description,station 
+	from current_full `
+	where, args := option.GetSqlWhere(s.driver)
+	qry += where
+	rows, err := s.db.Query(qry, args...)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	var es []ConfigDB
+	var arr []CurrentFull
 	for rows.Next() {
-		var e ConfigDB
-		err := rows.Scan(&e.Key, &e.Value, &e.HostID)
+		var c CurrentFull
+		var t nullTime
+		err := rows.Scan(&c.Area, &c.Spot, &c.Name, &c.Count, &t,
+			&c.Lat, &c.Lon, &c.Description, &c.Station)
 		if err != nil {
 			continue
 		}
-		es = append(es, e)
+		c.Time = t.Time
+		arr = append(arr, c)
 	}
-	return es, nil
+	return arr, nil
 }
 
-//SearchCurrentFull ビュー検索
-func SearchCurrentFull(db *sql.DB, option SearchOptions) ([]CurrentFull, error) {
-	qry := `select 
-	trim(area),trim(spot),trim(name),
-	trim(count),time,lat,lon,description,station 
-	from current_full `
-	qry += option.GetSqlWhere()
-	rows, err := db.Query(qry)
+//SearchConfig 設定テーブル検索
+func (s *sqlStore) SearchConfig(option SearchOptions) ([]ConfigDB, error) {
+	qry := "SELECT trim(key), trim(value), trim(hostid) FROM " + s.table("config") + " "
+	where, args := option.GetSqlWhere(s.driver)
+	qry += where
+
+	rows, err := s.db.Query(qry, args...)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	var arr []CurrentFull
+	var es []ConfigDB
 	for rows.Next() {
-		var s CurrentFull
-		err := rows.Scan(&s.Area, &s.Spot, &s.Name, &s.Count, &s.Time,
-			&s.Lat, &s.Lon, &s.Description, &s.Station)
+		var e ConfigDB
+		err := rows.Scan(&e.Key, &e.Value, &e.HostID)
 		if err != nil {
 			continue
 		}
-		arr = append(arr, s)
+		es = append(es, e)
 	}
-	return arr, nil
+	return es, nil
 }
 
-//Delete 汎用的なレコード削除関数
-func Delete(db *sql.DB, table string, option SearchOptions) (int64, error) {
+//delete 汎用的なレコード削除関数
+func (s *sqlStore) delete(table string, option SearchOptions) (int64, error) {
 	qry := "delete from " + table
-	qry += option.GetSqlWhere()
-	result, err := db.Exec(qry)
-	RowsAffected, _ := result.RowsAffected()
-	return RowsAffected, err
+	where, args := option.GetSqlWhere(s.driver)
+	qry += where
+	result, err := s.db.Exec(qry, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+//bulkInsert 複数行をバインド変数付きでまとめてインサートする（重複は無視）
+//バインド変数の上限を超えないよう一定の行数ごとに分けて実行する
+func (s *sqlStore) bulkInsert(db execer, table string, columns []string, rows [][]interface{}) (int64, error) {
+	var total int64
+	for start := 0; start < len(rows); start += maxBulkRows {
+		end := start + maxBulkRows
+		if end > len(rows) {
+			end = len(rows)
+		}
+		var tuples []string
+		var args []interface{}
+		for _, row := range rows[start:end] {
+			var holders []string
+			for _, value := range row {
+				args = append(args, value)
+				holders = append(holders, s.placeholder(len(args)))
+			}
+			tuples = append(tuples, "("+strings.Join(holders, ",")+")")
+		}
+		qry := "insert into " + table + " (" + strings.Join(columns, ",") + ") values " + strings.Join(tuples, ",") + " on conflict do nothing"
+		result, err := db.Exec(qry, args...)
+		if err != nil {
+			return total, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += affected
+	}
+	return total, nil
 }
 
 //GetAllUsers ユーザー設定をすべて取得
-func GetAllUsers(db *sql.DB) ([]static.JUser, error) {
+func (s *sqlStore) GetAllUsers() ([]static.JUser, error) {
 	var users []static.JUser
-	qry := `select line_id,slack_id from public.user`
-	rows, err := db.Query(qry)
+	qry := `select line_id,slack_id from ` + s.table("user")
+	rows, err := s.db.Query(qry)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var s static.JUser
-		err := rows.Scan(&s.LineID, &s.SlackID)
+		var u static.JUser
+		err := rows.Scan(&u.LineID, &u.SlackID)
 		if err != nil {
 			continue
 		}
-		users = append(users, s)
+		users = append(users, u)
 	}
+	rows.Close()
+
+	qry = `select key,value,seq from ` + s.table("line") + ` where id = ` + s.placeholder(1) + ` order by id,key,seq`
+	stmt, err := s.db.Prepare(qry)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
 
 	var rtnUsers []static.JUser
 	for _, user := range users {
-		qry = `select key,value,seq from line where id = $1 order by id,key,seq`
-		stmt, err := db.Prepare(qry)
-		if err != nil {
-			fmt.Println(err)
-			return nil, err
-		}
-		defer stmt.Close()
-
-		rows, err = stmt.Query(user.LineID)
+		lines, err := stmt.Query(user.LineID)
 		if err != nil {
-			fmt.Println(err)
 			return nil, err
 		}
 		var key string
@@ -507,8 +643,8 @@ func GetAllUsers(db *sql.DB) ([]static.JUser, error) {
 		var Favorites []string = []string{}
 		var Histories []string = []string{}
 		var Notifies []string = []string{}
-		for rows.Next() {
-			err := rows.Scan(&key, &val, &seq)
+		for lines.Next() {
+			err := lines.Scan(&key, &val, &seq)
 			if err != nil {
 				continue
 			}
@@ -521,6 +657,7 @@ func GetAllUsers(db *sql.DB) ([]static.JUser, error) {
 				Notifies = append(Notifies, val)
 			}
 		}
+		lines.Close()
 		user.Favorites = Favorites
 		user.Histories = Histories
 		user.Notifies = Notifies
@@ -531,109 +668,60 @@ func GetAllUsers(db *sql.DB) ([]static.JUser, error) {
 }
 
 //UpsertUser ユーザがあればUpdate無ければInsert
-func UpsertUser(db *sql.DB, user *static.JUser) (err error) {
-	//トランザクション開始
-	tx, err := db.Begin()
+func (s *sqlStore) UpsertUser(user *static.JUser) (err error) {
+	//トランザクション開始（SQLiteはロックを取り合わないよう全てトランザクション内で実行する）
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
-	var qry string
+	var qry, id string
 	//user削除
 	if user.LineID != "" {
-		qry = fmt.Sprintf(`delete from public.user where line_id = '%s'`, user.LineID)
+		qry, id = "delete from "+s.table("user")+" where line_id = "+s.placeholder(1), user.LineID
 	} else if user.SlackID != "" {
-		qry = fmt.Sprintf(`delete from public.user where slack_id = '%s'`, user.SlackID)
+		qry, id = "delete from "+s.table("user")+" where slack_id = "+s.placeholder(1), user.SlackID
 	} else {
 		tx.Rollback()
 		return fmt.Errorf("[ERROR]UpsertUser IDが不明です")
 	}
-	_, err = tx.Query(qry)
+	_, err = tx.Exec(qry, id)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 	//userインサート
-	qry = fmt.Sprintf(`insert into public.user(line_id, slack_id) values('%s','%s')`, user.LineID, user.SlackID)
-	_, err = tx.Query(qry)
+	qry = "insert into " + s.table("user") + "(line_id, slack_id) values(" + s.placeholder(1) + "," + s.placeholder(2) + ")"
+	_, err = tx.Exec(qry, user.LineID, user.SlackID)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 	//line設定削除
-	qry = "delete from public.line where id = $1"
-	stmt, err := db.Prepare(qry)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	_, err = stmt.Query(user.LineID)
+	qry = "delete from " + s.table("line") + " where id = " + s.placeholder(1)
+	_, err = tx.Exec(qry, user.LineID)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	qry = "insert into public.line(id,key,value,seq) values "
-	template := "('%s','%s','%s','%d')"
-	var values string
-	values = ""
-	for i, fav := range user.Favorites {
-		if i != 0 {
-			values += ","
-		}
-		values += fmt.Sprintf(template, user.LineID, LineFavorite, fav, i)
-	}
-	if len(user.Favorites) > 0 {
-		_, err = db.Exec(qry + values)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
-
-	values = ""
-	for i, his := range user.Histories {
-		if i != 0 {
-			values += ","
+	var values [][]interface{}
+	for _, item := range []struct {
+		key    string
+		values []string
+	}{
+		{LineFavorite, user.Favorites},
+		{LineHistory, user.Histories},
+		{LineNotify, user.Notifies},
+	} {
+		for i, value := range item.values {
+			values = append(values, []interface{}{user.LineID, item.key, value, i})
 		}
-		values += fmt.Sprintf(template, user.LineID, LineHistory, his, i)
 	}
-	if len(user.Histories) > 0 {
-		_, err = db.Exec(qry + values)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
-
-	values = ""
-	for i, nitice := range user.Notifies {
-		if i != 0 {
-			values += ","
-		}
-		values += fmt.Sprintf(template, user.LineID, LineNotify, nitice, i)
-	}
-	if len(user.Notifies) > 0 {
-		_, err = db.Exec(qry + values)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
-
-	return tx.Commit()
-}
-
-//CheckScrapingStatus スクレイピングが正常に行われているかチェック
-func CheckScrapingStatus(db *sql.DB) error {
-	qry := "select count(*) from spotinfo"
-	row := db.QueryRow(qry)
-	var count int
-	err := row.Scan(&count)
+	_, err = s.bulkInsert(tx, s.table("line"), []string{"id", "key", "value", "seq"}, values)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
-	if count < 1000 {
-		return fmt.Errorf("%v", static.StatusScrapingError)
-	}
-	return nil
+
+	return tx.Commit()
 }
@@ -0,0 +1,275 @@
+package rdb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  定数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Column 検索条件や並べ替えに使う列
+type Column string
+
+const (
+	//ColumnArea エリアコード
+	ColumnArea Column = "area"
+	//ColumnSpot スポットコード
+	ColumnSpot Column = "spot"
+	//ColumnTime 時刻
+	ColumnTime Column = "time"
+	//ColumnCount 台数（数値として扱う）
+	ColumnCount Column = "count"
+	//ColumnName スポット名
+	ColumnName Column = "name"
+	//ColumnDescription スポットの説明
+	ColumnDescription Column = "description"
+	//ColumnStarttime マスタの有効開始日時
+	ColumnStarttime Column = "starttime"
+	//ColumnEndtime マスタの有効期限
+	ColumnEndtime Column = "endtime"
+	//ColumnGranularity 集計の単位
+	ColumnGranularity Column = "granularity"
+	//ColumnHostID 設定のホストID
+	ColumnHostID Column = "hostid"
+	//ColumnAreaSpot "area-spot"形式のコード
+	ColumnAreaSpot Column = "areaspot"
+	//ColumnSearchText 自由検索の対象（"area-spot,名前駅名"）
+	ColumnSearchText Column = "searchtext"
+)
+
+//Operator 比較演算子
+type Operator string
+
+const (
+	//OpEqual 等しい
+	OpEqual Operator = "="
+	//OpLess より小さい
+	OpLess Operator = "<"
+	//OpGreaterEqual 以上
+	OpGreaterEqual Operator = ">="
+	//OpIn いずれかに等しい
+	OpIn Operator = "in"
+	//OpContains 文字列を含む
+	OpContains Operator = "contains"
+	//OpIsNull NULL
+	OpIsNull Operator = "is null"
+	//OpEmpty NULLまたは空文字
+	OpEmpty Operator = "empty"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  構造体
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Filter 検索条件（値は必ずバインド変数として渡す）
+type Filter struct {
+	Column   Column
+	Operator Operator
+	Values   []interface{}
+}
+
+//Order 並べ替え条件
+type Order struct {
+	Column Column
+	Desc   bool
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Equal 列 = 値
+func Equal(column Column, value interface{}) Filter {
+	return Filter{Column: column, Operator: OpEqual, Values: []interface{}{value}}
+}
+
+//Less 列 < 値
+func Less(column Column, value interface{}) Filter {
+	return Filter{Column: column, Operator: OpLess, Values: []interface{}{value}}
+}
+
+//GreaterEqual 列 >= 値
+func GreaterEqual(column Column, value interface{}) Filter {
+	return Filter{Column: column, Operator: OpGreaterEqual, Values: []interface{}{value}}
+}
+
+//In 列 in (値...)
+func In(column Column, values ...string) Filter {
+	filter := Filter{Column: column, Operator: OpIn}
+	for _, value := range values {
+		filter.Values = append(filter.Values, value)
+	}
+	return filter
+}
+
+//Contains 列が文字列を含む
+func Contains(column Column, value string) Filter {
+	return Filter{Column: column, Operator: OpContains, Values: []interface{}{value}}
+}
+
+//IsNull 列がNULL
+func IsNull(column Column) Filter {
+	return Filter{Column: column, Operator: OpIsNull}
+}
+
+//Empty 列がNULLまたは空文字
+func Empty(column Column) Filter {
+	return Filter{Column: column, Operator: OpEmpty}
+}
+
+//Asc 昇順
+func Asc(column Column) Order {
+	return Order{Column: column}
+}
+
+//Desc 降順
+func Desc(column Column) Order {
+	return Order{Column: column, Desc: true}
+}
+
+//bindValue バインド変数に渡す値に変換する（時刻はSQLiteでも文字列比較できる形式にする）
+func bindValue(value interface{}) interface{} {
+	if t, ok := value.(time.Time); ok {
+		return t.Format(TimeLayout)
+	}
+	return value
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Where 検索条件を追加したSearchOptionsを返す
+func (option SearchOptions) Where(filters ...Filter) SearchOptions {
+	option.Filters = append(append([]Filter{}, option.Filters...), filters...)
+	return option
+}
+
+//Sort 並べ替え条件を追加したSearchOptionsを返す
+func (option SearchOptions) Sort(orders ...Order) SearchOptions {
+	option.Orders = append(append([]Order{}, option.Orders...), orders...)
+	return option
+}
+
+//filters Area, Spot, Time, From, Toを含めたすべての検索条件
+func (option SearchOptions) filters() []Filter {
+	var filters []Filter
+	if option.Area != "" {
+		filters = append(filters, Equal(ColumnArea, option.Area))
+	}
+	if option.Spot != "" {
+		filters = append(filters, Equal(ColumnSpot, option.Spot))
+	}
+	if !option.Time.IsZero() {
+		filters = append(filters, Equal(ColumnTime, option.Time))
+	}
+	if !option.From.IsZero() {
+		filters = append(filters, GreaterEqual(ColumnTime, option.From))
+	}
+	if !option.To.IsZero() {
+		filters = append(filters, Less(ColumnTime, option.To))
+	}
+	return append(filters, option.Filters...)
+}
+
+//GetSqlWhere 検索条件作成（where句以降のSQLとバインド変数を返す）
+func (option SearchOptions) GetSqlWhere(driver DriverType) (string, []interface{}) {
+	d := dialect(driver)
+	qry := ""
+	var args []interface{}
+	for _, filter := range option.filters() {
+		expr := d.column(filter.Column)
+		switch filter.Operator {
+		case OpIsNull:
+			qry += fmt.Sprintf(" and %s is null ", expr)
+		case OpEmpty:
+			qry += fmt.Sprintf(" and (%s is null or trim(%s) = '') ", expr, expr)
+		case OpIn:
+			if len(filter.Values) == 0 {
+				//何にも一致しない
+				qry += " and 1=0 "
+				continue
+			}
+			var holders []string
+			for _, value := range filter.Values {
+				args = append(args, bindValue(value))
+				holders = append(holders, d.placeholder(len(args)))
+			}
+			qry += fmt.Sprintf(" and %s in (%s) ", expr, strings.Join(holders, ","))
+		case OpContains:
+			args = append(args, bindValue(filter.Values[0]))
+			qry += fmt.Sprintf(" and %s ", d.contains(expr, d.placeholder(len(args))))
+		default:
+			args = append(args, bindValue(filter.Values[0]))
+			qry += fmt.Sprintf(" and %s %s %s ", expr, filter.Operator, d.placeholder(len(args)))
+		}
+	}
+	if qry != "" {
+		qry = " where 1=1 " + qry
+	}
+	if len(option.Orders) > 0 {
+		var orders []string
+		for _, order := range option.Orders {
+			term := d.column(order.Column)
+			if order.Desc {
+				term += " desc"
+			}
+			orders = append(orders, term)
+		}
+		qry += " order by " + strings.Join(orders, ",") + " "
+	}
+	//SQLiteはlimitの後にoffsetを書く必要がある
+	if option.Limit != 0 {
+		qry += fmt.Sprintf(" limit %d ", option.Limit)
+	} else if option.Offset != 0 && driver != DriverTypePostgres {
+		qry += " limit -1 "
+	}
+	if option.Offset != 0 {
+		qry += fmt.Sprintf(" offset %d ", option.Offset)
+	}
+	return qry, args
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  方言
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//dialect DBごとのSQLの差異を吸収する
+type dialect DriverType
+
+//placeholder n番目のバインド変数
+func (d dialect) placeholder(n int) string {
+	if DriverType(d) == DriverTypePostgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+//column 列をSQLの式に変換する
+func (d dialect) column(column Column) string {
+	switch column {
+	case ColumnCount:
+		if DriverType(d) == DriverTypePostgres {
+			return "to_number(count, '999')"
+		}
+		return "cast(count as integer)"
+	case ColumnHostID:
+		return "trim(hostid)"
+	case ColumnAreaSpot:
+		return "(trim(area) || '-' || trim(spot))"
+	case ColumnSearchText:
+		return "(trim(area) || '-' || trim(spot) || ',' || coalesce(name, '') || coalesce(station, ''))"
+	}
+	return string(column)
+}
+
+//contains 部分一致の式
+func (d dialect) contains(expr, holder string) string {
+	if DriverType(d) == DriverTypePostgres {
+		return fmt.Sprintf("position(%s in %s) > 0", holder, expr)
+	}
+	return fmt.Sprintf("instr(%s, %s) > 0", expr, holder)
+}
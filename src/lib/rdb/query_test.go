@@ -0,0 +1,110 @@
+package rdb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//sqlPayload 値がSQLに埋め込まれていないことを確かめるための文字列
+const sqlPayload = `x' or '1'='1%`
+
+//squash 空白をまとめる（GetSqlWhereは前後に空白を付けて連結する）
+func squash(qry string) string {
+	return strings.Join(strings.Fields(qry), " ")
+}
+
+//TestGetSqlWhere 方言ごとのwhere句以降のSQLとバインド変数
+func TestGetSqlWhere(t *testing.T) {
+	from := time.Date(2023, 11, 15, 7, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		option SearchOptions
+		psql   string
+		sqlite string
+		args   []interface{}
+	}{
+		{
+			name: "条件なし",
+		},
+		{
+			name:   "Area, Spot, From, To",
+			option: SearchOptions{Area: "A1", Spot: "001", From: from, To: from.Add(time.Hour)},
+			psql:   "where 1=1 and area = $1 and spot = $2 and time >= $3 and time < $4",
+			sqlite: "where 1=1 and area = ? and spot = ? and time >= ? and time < ?",
+			args:   []interface{}{"A1", "001", "2023/11/15 07:00:00", "2023/11/15 08:00:00"},
+		},
+		{
+			name:   "Time",
+			option: SearchOptions{Time: from},
+			psql:   "where 1=1 and time = $1",
+			sqlite: "where 1=1 and time = ?",
+			args:   []interface{}{"2023/11/15 07:00:00"},
+		},
+		{
+			name:   "Equal, Less, GreaterEqual（台数は数値として比較）",
+			option: SearchOptions{}.Where(Equal(ColumnHostID, sqlPayload), Less(ColumnCount, 3), GreaterEqual(ColumnGranularity, "hour")),
+			psql:   "where 1=1 and trim(hostid) = $1 and to_number(count, '999') < $2 and granularity >= $3",
+			sqlite: "where 1=1 and trim(hostid) = ? and cast(count as integer) < ? and granularity >= ?",
+			args:   []interface{}{sqlPayload, 3, "hour"},
+		},
+		{
+			name:   "In",
+			option: SearchOptions{}.Where(In(ColumnAreaSpot, "A1-001", sqlPayload)),
+			psql:   "where 1=1 and (trim(area) || '-' || trim(spot)) in ($1,$2)",
+			sqlite: "where 1=1 and (trim(area) || '-' || trim(spot)) in (?,?)",
+			args:   []interface{}{"A1-001", sqlPayload},
+		},
+		{
+			name:   "値のないIn",
+			option: SearchOptions{Area: "A1"}.Where(In(ColumnSpot)),
+			psql:   "where 1=1 and area = $1 and 1=0",
+			sqlite: "where 1=1 and area = ? and 1=0",
+			args:   []interface{}{"A1"},
+		},
+		{
+			name:   "Contains",
+			option: SearchOptions{}.Where(Contains(ColumnSearchText, sqlPayload)),
+			psql:   "where 1=1 and position($1 in (trim(area) || '-' || trim(spot) || ',' || coalesce(name, '') || coalesce(station, ''))) > 0",
+			sqlite: "where 1=1 and instr((trim(area) || '-' || trim(spot) || ',' || coalesce(name, '') || coalesce(station, '')), ?) > 0",
+			args:   []interface{}{sqlPayload},
+		},
+		{
+			name:   "IsNull, Empty",
+			option: SearchOptions{}.Where(IsNull(ColumnEndtime), Empty(ColumnDescription)),
+			psql:   "where 1=1 and endtime is null and (description is null or trim(description) = '')",
+			sqlite: "where 1=1 and endtime is null and (description is null or trim(description) = '')",
+		},
+		{
+			name:   "Orders, Limit, Offset",
+			option: SearchOptions{Area: "A1", Limit: 10, Offset: 20}.Sort(Asc(ColumnTime), Desc(ColumnCount)),
+			psql:   "where 1=1 and area = $1 order by time,to_number(count, '999') desc limit 10 offset 20",
+			sqlite: "where 1=1 and area = ? order by time,cast(count as integer) desc limit 10 offset 20",
+			args:   []interface{}{"A1"},
+		},
+		{
+			name:   "Offsetのみ（SQLiteはlimitが必要）",
+			option: SearchOptions{Offset: 5}.Sort(Desc(ColumnStarttime)),
+			psql:   "order by starttime desc offset 5",
+			sqlite: "order by starttime desc limit -1 offset 5",
+		},
+	}
+	for _, tt := range tests {
+		for _, d := range []struct {
+			driver DriverType
+			want   string
+		}{{DriverTypePostgres, tt.psql}, {DriverTypeSQLite3, tt.sqlite}} {
+			qry, args := tt.option.GetSqlWhere(d.driver)
+			if got := squash(qry); got != d.want {
+				t.Errorf("%s(%s)\n SQL    %s\n 期待値 %s", tt.name, d.driver, got, d.want)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("%s(%s) バインド変数 %#v（期待値 %#v）", tt.name, d.driver, args, tt.args)
+			}
+			if strings.Contains(qry, "'1'='1") || strings.Contains(qry, "%") {
+				t.Errorf("%s(%s) 値がSQLに埋め込まれています : %s", tt.name, d.driver, qry)
+			}
+		}
+	}
+}
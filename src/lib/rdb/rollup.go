@@ -0,0 +1,296 @@
+package rdb
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  定数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Granularity 集計の単位
+type Granularity string
+
+const (
+	//GranularityHour 1時間ごと
+	GranularityHour Granularity = "hour"
+	//GranularityDay 1日ごと
+	GranularityDay Granularity = "day"
+)
+
+//rollupMaxGap 次のデータまでこれより空いている場合は、それ以上同じ台数が続いたとはみなさない
+const rollupMaxGap = 15 * time.Minute
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  構造体
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Rollup スポットごとの台数の集計
+//FullMinutesはCapacity（ラック数）が分かっているときのみ意味を持つ
+type Rollup struct {
+	Granularity               Granularity
+	Area, Spot                string
+	Time                      time.Time
+	Min, Max, Last            int
+	Avg                       float64
+	Samples                   int
+	EmptyMinutes, FullMinutes int
+	Capacity                  int
+}
+
+//RollupBuilder 1日分の台数を時刻順に受け取って集計する
+type RollupBuilder struct {
+	day        time.Time
+	capacities map[string]int
+	spots      map[string]*spotRollup
+}
+
+//spotRollup スポットごとの集計途中の値
+type spotRollup struct {
+	area, spot string
+	hours      [24]*hourRollup
+	lastTime   time.Time
+	lastCount  int
+	hasLast    bool
+}
+
+//hourRollup 1時間分の集計途中の値
+type hourRollup struct {
+	min, max, last, samples, sum int
+	emptySeconds, fullSeconds    float64
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//NewRollupBuilder 集計対象日とスポットごとのラック数（キーはarea-spot）を指定して作成
+func NewRollupBuilder(day time.Time, capacities map[string]int) *RollupBuilder {
+	return &RollupBuilder{
+		day:        time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location()),
+		capacities: capacities,
+		spots:      make(map[string]*spotRollup),
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Add 台数を追加する（スポットごとに時刻の昇順で渡すこと）
+func (b *RollupBuilder) Add(area, spot string, t time.Time, count string) {
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil {
+		return
+	}
+	hour := int(t.Sub(b.day) / time.Hour)
+	if hour < 0 || hour >= 24 {
+		return
+	}
+	area, spot = strings.TrimSpace(area), strings.TrimSpace(spot)
+	key := area + "-" + spot
+	s, ok := b.spots[key]
+	if !ok {
+		s = &spotRollup{area: area, spot: spot}
+		b.spots[key] = s
+	}
+	//直前のデータの台数がこの時刻まで続いていたとみなす
+	if s.hasLast {
+		b.addDuration(s, key, s.lastCount, s.lastTime, t)
+	}
+	h := b.hour(s, hour, n)
+	if h.samples == 0 || n < h.min {
+		h.min = n
+	}
+	if h.samples == 0 || n > h.max {
+		h.max = n
+	}
+	h.sum += n
+	h.samples++
+	h.last = n
+	s.lastTime, s.lastCount, s.hasLast = t, n, true
+}
+
+//Build 時間ごとと日ごとの集計を作成する
+func (b *RollupBuilder) Build() []Rollup {
+	end := b.day.AddDate(0, 0, 1)
+	var keys []string
+	for key := range b.spots {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var rollups []Rollup
+	for _, key := range keys {
+		s := b.spots[key]
+		//最後のデータは日付が変わるまで（最大rollupMaxGap）続いたとみなす
+		if s.hasLast {
+			b.addDuration(s, key, s.lastCount, s.lastTime, end)
+			s.hasLast = false
+		}
+		capacity := b.capacities[key]
+		day := Rollup{Granularity: GranularityDay, Area: s.area, Spot: s.spot, Time: b.day, Capacity: capacity}
+		var sum int
+		var empty, full float64
+		for hour, h := range s.hours {
+			if h == nil {
+				continue
+			}
+			r := Rollup{Granularity: GranularityHour, Area: s.area, Spot: s.spot,
+				Time: b.day.Add(time.Duration(hour) * time.Hour),
+				Min:  h.min, Max: h.max, Last: h.last, Samples: h.samples,
+				EmptyMinutes: int(h.emptySeconds / 60), FullMinutes: int(h.fullSeconds / 60),
+				Capacity: capacity}
+			if h.samples > 0 {
+				r.Avg = float64(h.sum) / float64(h.samples)
+			} else {
+				//データがなく直前の台数が続いていた時間
+				r.Avg = float64(h.last)
+			}
+			rollups = append(rollups, r)
+
+			if day.Samples == 0 || (h.samples > 0 && h.min < day.Min) {
+				day.Min = h.min
+			}
+			if day.Samples == 0 || (h.samples > 0 && h.max > day.Max) {
+				day.Max = h.max
+			}
+			if h.samples > 0 {
+				day.Last = h.last
+			}
+			day.Samples += h.samples
+			sum += h.sum
+			empty += h.emptySeconds
+			full += h.fullSeconds
+		}
+		if day.Samples == 0 {
+			continue
+		}
+		day.Avg = float64(sum) / float64(day.Samples)
+		day.EmptyMinutes, day.FullMinutes = int(empty/60), int(full/60)
+		rollups = append(rollups, day)
+	}
+	return rollups
+}
+
+//hour 時間ごとの集計を取得（なければ作成）
+func (b *RollupBuilder) hour(s *spotRollup, hour, count int) *hourRollup {
+	if s.hours[hour] == nil {
+		s.hours[hour] = &hourRollup{min: count, max: count, last: count}
+	}
+	return s.hours[hour]
+}
+
+//addDuration start〜endの間countだった時間を時間ごとに振り分けて加算する
+func (b *RollupBuilder) addDuration(s *spotRollup, key string, count int, start, end time.Time) {
+	if limit := start.Add(rollupMaxGap); end.After(limit) {
+		end = limit
+	}
+	if dayEnd := b.day.AddDate(0, 0, 1); end.After(dayEnd) {
+		end = dayEnd
+	}
+	empty := count == 0
+	full := b.capacities[key] > 0 && count >= b.capacities[key]
+	if !empty && !full {
+		return
+	}
+	for t := start; t.Before(end); {
+		hour := int(t.Sub(b.day) / time.Hour)
+		next := b.day.Add(time.Duration(hour+1) * time.Hour)
+		if next.After(end) {
+			next = end
+		}
+		h := b.hour(s, hour, count)
+		if empty {
+			h.emptySeconds += next.Sub(t).Seconds()
+		}
+		if full {
+			h.fullSeconds += next.Sub(t).Seconds()
+		}
+		t = next
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  sqlStore
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//SearchRollups 集計を検索
+func (s *sqlStore) SearchRollups(option SearchOptions) ([]Rollup, error) {
+	qry := `select granularity, trim(area), trim(spot), time,
+	min_count, max_count, avg_count, last_count, samples,
+	empty_minutes, full_minutes, coalesce(capacity, 0)
+	from ` + s.table("rollup") + " "
+	where, args := option.GetSqlWhere(s.driver)
+	qry += where
+
+	rows, err := s.db.Query(qry, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var es []Rollup
+	for rows.Next() {
+		var e Rollup
+		var granularity string
+		var t nullTime
+		err := rows.Scan(&granularity, &e.Area, &e.Spot, &t, &e.Min, &e.Max, &e.Avg, &e.Last,
+			&e.Samples, &e.EmptyMinutes, &e.FullMinutes, &e.Capacity)
+		if err != nil {
+			continue
+		}
+		e.Granularity = Granularity(strings.TrimSpace(granularity))
+		e.Time = t.Time
+		es = append(es, e)
+	}
+	return es, nil
+}
+
+//UpsertRollups 集計を保存（同じ単位・スポット・時刻があれば上書き）
+func (s *sqlStore) UpsertRollups(rollups []Rollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+	columns := "granularity, area, spot, time, min_count, max_count, avg_count, last_count, samples, empty_minutes, full_minutes, capacity"
+	var holders []string
+	for i := 1; i <= 12; i++ {
+		holders = append(holders, s.placeholder(i))
+	}
+	qry := "insert into " + s.table("rollup") + " (" + columns + ") values (" + strings.Join(holders, ",") + ")"
+	if s.driver == DriverTypePostgres {
+		qry += ` on conflict (granularity, area, spot, time) do update set
+		(min_count, max_count, avg_count, last_count, samples, empty_minutes, full_minutes, capacity)
+		= (excluded.min_count, excluded.max_count, excluded.avg_count, excluded.last_count,
+		excluded.samples, excluded.empty_minutes, excluded.full_minutes, excluded.capacity)`
+	} else {
+		qry = strings.Replace(qry, "insert into", "insert or replace into", 1)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(qry)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range rollups {
+		var capacity interface{}
+		if r.Capacity > 0 {
+			capacity = r.Capacity
+		}
+		_, err = stmt.Exec(string(r.Granularity), r.Area, r.Spot, r.Time.Format(TimeLayout),
+			r.Min, r.Max, r.Avg, r.Last, r.Samples, r.EmptyMinutes, r.FullMinutes, capacity)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
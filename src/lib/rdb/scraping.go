@@ -0,0 +1,114 @@
+package rdb
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  構造体
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//AreaScraping エリアごとのスクレイピングの状況
+type AreaScraping struct {
+	Area string
+	//Latest 最新の台数の時刻（台数がなければゼロ値）
+	Latest time.Time
+	//Reporting 指定時刻以降に台数のあるスポット数
+	Reporting int
+	//Active 有効な（終了していない）スポット数
+	Active int
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//mergeAreaScraping 台数とマスタの集計をエリアごとにまとめる（エリアの昇順）
+func mergeAreaScraping(counts map[string]AreaScraping, actives map[string]int) []AreaScraping {
+	for area, active := range actives {
+		item := counts[area]
+		item.Area, item.Active = area, active
+		counts[area] = item
+	}
+	var result []AreaScraping
+	for area, item := range counts {
+		item.Area = area
+		result = append(result, item)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Area < result[j].Area })
+	return result
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  sqlStore
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//SearchScrapingStatus エリアごとの最新の台数の時刻、since以降に台数のあるスポット数、有効なスポット数
+func (s *sqlStore) SearchScrapingStatus(since time.Time) ([]AreaScraping, error) {
+	qry := "select area, max(time), count(distinct case when time >= " + s.placeholder(1) + " then spot end) from " +
+		s.table("spotinfo") + " group by area"
+	rows, err := s.db.Query(qry, since.Format(TimeLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]AreaScraping)
+	for rows.Next() {
+		var item AreaScraping
+		var latest nullTime
+		if err := rows.Scan(&item.Area, &latest, &item.Reporting); err != nil {
+			return nil, err
+		}
+		item.Area, item.Latest = strings.TrimSpace(item.Area), latest.Time
+		counts[item.Area] = item
+	}
+
+	qry = "select area, count(*) from " + s.table("spotmaster") + " where endtime is null group by area"
+	masterRows, err := s.db.Query(qry)
+	if err != nil {
+		return nil, err
+	}
+	defer masterRows.Close()
+	actives := make(map[string]int)
+	for masterRows.Next() {
+		var area string
+		var active int
+		if err := masterRows.Scan(&area, &active); err != nil {
+			return nil, err
+		}
+		actives[strings.TrimSpace(area)] += active
+	}
+	return mergeAreaScraping(counts, actives), nil
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  MemoryStore
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//SearchScrapingStatus エリアごとの最新の台数の時刻、since以降に台数のあるスポット数、有効なスポット数
+func (s *MemoryStore) SearchScrapingStatus(since time.Time) ([]AreaScraping, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := make(map[string]AreaScraping)
+	reporting := make(map[string]bool)
+	for _, row := range s.spotinfos {
+		item := counts[row.Area]
+		if t := wallClock(row.Time); t.After(item.Latest) {
+			item.Latest = t
+		}
+		if key := row.Area + "-" + row.Spot; !wallClock(row.Time).Before(wallClock(since)) && !reporting[key] {
+			reporting[key] = true
+			item.Reporting++
+		}
+		counts[row.Area] = item
+	}
+	actives := make(map[string]int)
+	for _, m := range s.masters {
+		if m.Endtime.IsZero() {
+			actives[m.Area]++
+		}
+	}
+	return mergeAreaScraping(counts, actives), nil
+}
@@ -2,6 +2,7 @@ package rdb
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -10,26 +11,22 @@ import (
 
 	"github.com/8245snake/bikeshare_api/src/lib/filer"
 	"github.com/8245snake/bikeshare_api/src/lib/static"
+
+	_ "github.com/mattn/go-sqlite3"
 )
 
-//CreateSQLite DBを作成する
+//CreateSQLite DBを作成する（アーカイブ用のマイグレーションを最新まで適用する）
 func CreateSQLite(path string) (*sql.DB, error) {
-	qry := `
-	create table spotinfo(  
-		area character (3) not null ,
-		spot character (3) not null ,
-		time character (20) not null ,
-		count character (3) ,
-		primary key (area,spot,time) 
-	  ) ;
-	`
 	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, err
 	}
-	_, err = db.Exec(qry)
+	migrator, err := NewMigrator(db, DriverTypeSQLite3, MigrationSetArchive)
 	if err != nil {
-		log.Printf("%q: %s\n", err, qry)
+		return nil, err
+	}
+	if _, err = migrator.Up(0); err != nil {
+		log.Printf("CreateSQLite %s: %v\n", path, err)
 		return nil, err
 	}
 
@@ -39,6 +36,9 @@ func CreateSQLite(path string) (*sql.DB, error) {
 	return db, nil
 }
 
+//ErrArchiveNotFound 指定した日のSQLiteがない（createIfNothing = falseのとき）
+var ErrArchiveNotFound = errors.New("アーカイブがありません")
+
 //GetConnectionSQLite 日付を指定してSQLiteのコネクションを取得
 //SQLiteファイルがない場合の挙動createIfNothing = True(DBを作る)
 func GetConnectionSQLite(t time.Time, createIfNothing bool) (db *sql.DB, err error) {
@@ -51,7 +51,7 @@ func GetConnectionSQLite(t time.Time, createIfNothing bool) (db *sql.DB, err err
 		if createIfNothing {
 			db, err = CreateSQLite(path)
 		} else {
-			err = fmt.Errorf("GetConnectionSQLite %s がありませんでした。", filename)
+			err = fmt.Errorf("GetConnectionSQLite %s : %w", filename, ErrArchiveNotFound)
 		}
 	}
 	return
@@ -0,0 +1,145 @@
+package rdb
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/config"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  インターフェース
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Store データの保存先を抽象化したインターフェース
+//Postgres, SQLite, メモリの3種類の実装がある
+type Store interface {
+	//SearchSpotinfo spotinfoを検索
+	SearchSpotinfo(option SearchOptions) ([]Spotinfo, error)
+	//BulkInsertSpotinfo spotinfoにまとめて追加（重複は無視）
+	BulkInsertSpotinfo(rows []Spotinfo) (int64, error)
+	//DeleteSpotinfo spotinfoから削除
+	DeleteSpotinfo(option SearchOptions) (int64, error)
+
+	//SearchAnalyze analyzeを検索
+	SearchAnalyze(option SearchOptions) ([]Analyze, error)
+	//SearchAnalyzeDates analyzeに指定時刻より前のデータがある日付の一覧
+	SearchAnalyzeDates(before time.Time) ([]time.Time, error)
+	//BulkInsertAnalyze analyzeにまとめて追加（重複は無視）
+	BulkInsertAnalyze(rows []Analyze) error
+	//DeleteAnalyze analyzeから削除
+	DeleteAnalyze(option SearchOptions) (int64, error)
+
+	//SearchSpotmaster spotmasterを検索
+	SearchSpotmaster(option SearchOptions) ([]Spotmaster, error)
+	//UpsertSpotmaster spotmasterを更新（なければ追加）
+	UpsertSpotmaster(m Spotmaster) error
+	//SearchCurrentFull 最新の台数とマスタを結合して検索
+	SearchCurrentFull(option SearchOptions) ([]CurrentFull, error)
+
+	//SearchRollups 集計を検索（単位はWhere(Equal(ColumnGranularity, ...))で指定する）
+	SearchRollups(option SearchOptions) ([]Rollup, error)
+	//UpsertRollups 集計を保存（同じ単位・スポット・時刻があれば上書き）
+	UpsertRollups(rollups []Rollup) error
+
+	//SearchConfig configを検索
+	SearchConfig(option SearchOptions) ([]ConfigDB, error)
+
+	//GetAllUsers ユーザー設定をすべて取得
+	GetAllUsers() ([]static.JUser, error)
+	//UpsertUser ユーザー設定を更新（なければ追加）
+	UpsertUser(user *static.JUser) error
+
+	//SearchApikeys 全てのAPIキーを取得（作成日時の昇順）
+	SearchApikeys() ([]Apikey, error)
+	//UpsertApikey APIキーを保存（同じIDがあれば上書き、利用回数と最終利用日時は変えない）
+	UpsertApikey(k Apikey) error
+	//AddApikeyUsage 利用回数を加算し最終利用日時を更新する
+	AddApikeyUsage(id string, count int64, lastUsed time.Time) error
+
+	//SearchScrapingStatus エリアごとの最新の台数の時刻、since以降に台数のあるスポット数、有効なスポット数
+	SearchScrapingStatus(since time.Time) ([]AreaScraping, error)
+	//Ping 接続確認
+	Ping() error
+	//Close 切断
+	Close() error
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  構造体
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//sqlStore database/sqlを使ったStoreの実装（PostgresとSQLiteで共用し方言だけ切り替える）
+type sqlStore struct {
+	db     *sql.DB
+	driver DriverType
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//NewPsqlStore Postgresのコネクションを包んだStoreを作成
+func NewPsqlStore(db *sql.DB) Store {
+	return &sqlStore{db: db, driver: DriverTypePostgres}
+}
+
+//NewSQLiteStore SQLiteのコネクションを包んだStoreを作成
+func NewSQLiteStore(db *sql.DB) Store {
+	return &sqlStore{db: db, driver: DriverTypeSQLite3}
+}
+
+//OpenStore app.iniの設定（[DB] DRIVER）に従ってStoreを作成する
+func OpenStore() (Store, error) {
+	conf, err := config.LoadDB()
+	if err != nil {
+		return nil, err
+	}
+	if DriverType(conf.Driver) == DriverTypeMemory {
+		return NewMemoryStore(), nil
+	}
+	db, driver, err := openLiveDB(conf)
+	if err != nil {
+		return nil, err
+	}
+	if driver == DriverTypePostgres {
+		return NewPsqlStore(db), nil
+	}
+	return NewSQLiteStore(db), nil
+}
+
+//openLiveDB app.iniの設定（[DB] DRIVER）に従ってliveのDBに接続する
+//切れた接続の張り直しはsql.DBの接続プールに任せる（[DB] MAX_OPEN_CONNSなど）
+func openLiveDB(conf config.DB) (*sql.DB, DriverType, error) {
+	driver := DriverType(conf.Driver)
+	var db *sql.DB
+	var err error
+	switch driver {
+	case DriverTypePostgres:
+		db, err = GetConnectionPsql(conf)
+	case DriverTypeSQLite3:
+		db, err = sql.Open("sqlite3", conf.Path)
+	default:
+		return nil, driver, fmt.Errorf("OpenStore DRIVER=%sには対応していません", driver)
+	}
+	if err != nil {
+		return nil, driver, err
+	}
+	db.SetMaxOpenConns(conf.MaxOpenConns)
+	db.SetMaxIdleConns(conf.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(conf.ConnMaxLifetime) * time.Minute)
+	db.SetConnMaxIdleTime(time.Duration(conf.ConnMaxIdleTime) * time.Minute)
+	return db, driver, nil
+}
+
+//OpenArchiveStore 日付を指定してアーカイブ（日毎のSQLite）のStoreを取得
+//SQLiteファイルがない場合の挙動createIfNothing = True(DBを作る)
+func OpenArchiveStore(t time.Time, createIfNothing bool) (Store, error) {
+	db, err := GetConnectionSQLite(t, createIfNothing)
+	if err != nil {
+		return nil, err
+	}
+	return NewSQLiteStore(db), nil
+}
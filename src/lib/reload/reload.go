@@ -0,0 +1,104 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/metrics"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：設定とキャッシュの読み込み直し
+//
+//　SIGHUPか管理用のエンドポイントで設定（app.ini）とキャッシュを、一定間隔でキャッシュだけを読み込み直す
+//　読み込み直しは1つずつ順に行う（途中で次のきっかけが来たら前のものが終わるまで待つ）
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Trigger 読み込み直すきっかけ
+type Trigger string
+
+const (
+	//TriggerSignal SIGHUP
+	TriggerSignal Trigger = "signal"
+	//TriggerAPI 管理用のエンドポイント
+	TriggerAPI Trigger = "api"
+	//TriggerInterval 一定間隔（キャッシュのみ）
+	TriggerInterval Trigger = "interval"
+)
+
+//Func 読み込み直す処理（settingsがtrueなら設定も読み込み直す）
+//一部に失敗しても読み込めたものは反映してエラーを返す
+type Func func(settings bool) error
+
+//Reloader 読み込み直しを1つずつ実行する
+type Reloader struct {
+	mu sync.Mutex
+	fn Func
+}
+
+//メトリクス
+var (
+	//reloads 読み込み直した回数（triggerはsignal, api, interval、resultはokまたはerror）
+	reloads = metrics.NewCounter("bikeshare_reload_total", "設定とキャッシュを読み込み直した回数", "trigger", "result")
+	//lastSuccess 最後に読み込み直せた日時
+	lastSuccess = metrics.NewGauge("bikeshare_reload_last_success_timestamp_seconds", "最後に設定とキャッシュを読み込み直せた日時（UNIX時間）", "trigger")
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//New fnで読み込み直すReloaderを作る
+func New(fn Func) *Reloader {
+	return &Reloader{fn: fn}
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Reload 読み込み直す（TriggerInterval以外は設定も読み込み直す）
+func (r *Reloader) Reload(trigger Trigger) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	start := time.Now()
+	if err := r.fn(trigger != TriggerInterval); err != nil {
+		logger.Errorf("Reload(%s) 読み込み直しに失敗しました : %v", trigger, err)
+		reloads.Inc(string(trigger), "error")
+		return err
+	}
+	logger.Infof("Reload(%s) 読み込み直しました(%v)", trigger, time.Since(start))
+	reloads.Inc(string(trigger), "ok")
+	lastSuccess.SetToCurrentTime(string(trigger))
+	return nil
+}
+
+//Watch ctxがキャンセルされるまで、SIGHUPを受けたときとinterval毎に読み込み直す（intervalが0ならSIGHUPのときのみ）
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				r.Reload(TriggerSignal)
+			case <-tick:
+				r.Reload(TriggerInterval)
+			}
+		}
+	}()
+}
@@ -0,0 +1,126 @@
+package shutdown
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：終了処理
+//
+//　SIGTERM（docker stop）かSIGINTを受けたらContextのcontextをキャンセルする
+//　HTTPサーバは新しい接続を止めて処理中のリクエストを待ち、定期実行する処理は実行中のものが終わるのを待つ
+//　2回目のシグナルではすぐに終了する
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Jobs 実行中の処理を数え、終了するときに待つ（ゼロ値で使える）
+type Jobs struct {
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+}
+
+const (
+	//readHeaderTimeout リクエストヘッダを読み終わるまでの時間
+	readHeaderTimeout = 10 * time.Second
+	//idleTimeout keep-aliveの接続を次のリクエストまで待つ時間
+	idleTimeout = 2 * time.Minute
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Context SIGTERMかSIGINTを受けたらキャンセルされるcontext
+func Context() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		s := <-sig
+		logger.Infof("shutdown %vを受けたので終了します", s)
+		//2回目は既定の動作（すぐに終了）に戻す
+		signal.Stop(sig)
+		cancel()
+	}()
+	return ctx
+}
+
+//NewServer portで待ち受けるHTTPサーバ
+//書き込みのタイムアウトは/streamのような長い応答を切ってしまうので付けない
+func NewServer(port int, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+//Serve serverで待ち受け、ctxがキャンセルされたら新しい接続を止めて処理中のリクエストをtimeoutまで待つ
+//待ち受けられなかったとき、timeoutまでに終わらなかったときはエラーを返す
+func Serve(ctx context.Context, server *http.Server, timeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	logger.Infof("shutdown %sの処理中のリクエストを待っています（最大%v）", server.Addr, timeout)
+	waitCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(waitCtx); err != nil {
+		server.Close()
+		return fmt.Errorf("%sの処理中のリクエストが%v以内に終わりませんでした : %v", server.Addr, timeout, err)
+	}
+	return nil
+}
+
+//Sleep dだけ待つ（途中でctxがキャンセルされたらfalse）
+func Sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Run fnを実行中として数えて実行する（Waitを呼んだ後なら実行せずにfalseを返す）
+func (j *Jobs) Run(fn func()) bool {
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return false
+	}
+	j.wg.Add(1)
+	j.mu.Unlock()
+	defer j.wg.Done()
+	fn()
+	return true
+}
+
+//Wait これ以上実行しないようにして、実行中の処理が終わるのを待つ
+func (j *Jobs) Wait() {
+	j.mu.Lock()
+	j.closed = true
+	j.mu.Unlock()
+	j.wg.Wait()
+}
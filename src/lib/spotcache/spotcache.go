@@ -0,0 +1,146 @@
+package spotcache
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：スポットのキャッシュ
+//
+//　エリアとスポットのコードで引ける索引と、名前・最寄り駅の前方一致の索引を持つ
+//　Setのたびに索引ごと作り直して丸ごと入れ替えるので、読む側はロックなしで一貫したスナップショットを見る
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Entry キャッシュする1つのスポット
+type Entry struct {
+	Area, Spot, Name, Station string
+	//Value 呼び出し側のデータ（rdb.MasterCacheならrdb.Spotmaster）
+	Value interface{}
+}
+
+//Cache スポットのキャッシュ（ゼロ値は空のキャッシュとして使える）
+type Cache struct {
+	current atomic.Value
+}
+
+//snapshot ある時点のキャッシュと索引（作ったあとは変更しない）
+type snapshot struct {
+	entries []Entry
+	//byCode area-spot → entriesの位置
+	byCode map[string]int
+	//byName 名前の昇順
+	byName []prefixKey
+	//byStation 最寄り駅の昇順
+	byStation []prefixKey
+}
+
+//prefixKey 前方一致の索引の1行
+type prefixKey struct {
+	key   string
+	index int
+}
+
+//empty Setする前のスナップショット
+var empty = &snapshot{byCode: map[string]int{}}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Code エリアとスポットのコード（area-spot）
+func Code(area, spot string) string {
+	return area + "-" + spot
+}
+
+//newSnapshot entriesから索引を作る
+func newSnapshot(entries []Entry) *snapshot {
+	s := &snapshot{entries: entries, byCode: make(map[string]int, len(entries))}
+	for i, e := range entries {
+		s.byCode[Code(e.Area, e.Spot)] = i
+		if e.Name != "" {
+			s.byName = append(s.byName, prefixKey{key: normalize(e.Name), index: i})
+		}
+		if e.Station != "" {
+			s.byStation = append(s.byStation, prefixKey{key: normalize(e.Station), index: i})
+		}
+	}
+	sortKeys(s.byName)
+	sortKeys(s.byStation)
+	return s
+}
+
+//sortKeys 前方一致の索引を並べる（同じキーは元の順）
+func sortKeys(keys []prefixKey) {
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].key < keys[j].key })
+}
+
+//normalize 前方一致で比べる文字列（前後の空白を除き、英字は小文字にする）
+func normalize(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Set キャッシュを入れ替える（entriesはこの後変更しないこと）
+func (c *Cache) Set(entries []Entry) {
+	c.current.Store(newSnapshot(entries))
+}
+
+//load 今のスナップショット
+func (c *Cache) load() *snapshot {
+	if s, ok := c.current.Load().(*snapshot); ok {
+		return s
+	}
+	return empty
+}
+
+//Len キャッシュしたスポットの数
+func (c *Cache) Len() int {
+	return len(c.load().entries)
+}
+
+//All キャッシュした全てのスポット（Setした順、変更しないこと）
+func (c *Cache) All() []Entry {
+	return c.load().entries
+}
+
+//Get エリアとスポットのコードで引く
+func (c *Cache) Get(area, spot string) (Entry, bool) {
+	s := c.load()
+	if i, ok := s.byCode[Code(area, spot)]; ok {
+		return s.entries[i], true
+	}
+	return Entry{}, false
+}
+
+//ByName 名前がprefixで始まるスポット（名前の順、limitが0なら全て）
+func (c *Cache) ByName(prefix string, limit int) []Entry {
+	s := c.load()
+	return s.match(s.byName, prefix, limit)
+}
+
+//ByStation 最寄り駅がprefixで始まるスポット（最寄り駅の順、limitが0なら全て）
+func (c *Cache) ByStation(prefix string, limit int) []Entry {
+	s := c.load()
+	return s.match(s.byStation, prefix, limit)
+}
+
+//match 索引keysからprefixで始まるものを探す
+func (s *snapshot) match(keys []prefixKey, prefix string, limit int) []Entry {
+	prefix = normalize(prefix)
+	if prefix == "" {
+		return nil
+	}
+	var entries []Entry
+	for i := sort.Search(len(keys), func(i int) bool { return keys[i].key >= prefix }); i < len(keys); i++ {
+		if !strings.HasPrefix(keys[i].key, prefix) || (limit > 0 && len(entries) >= limit) {
+			break
+		}
+		entries = append(entries, s.entries[keys[i].index])
+	}
+	return entries
+}
@@ -12,4 +12,31 @@ const (
 	StatusNotConnected StatusMessage = "DBに接続できませんでした"
 	//StatusScrapingError スクレイピングが滞っています
 	StatusScrapingError StatusMessage = "スクレイピングが滞っています"
+	//StatusScrapingStale エリアの最新の台数が古い
+	StatusScrapingStale StatusMessage = "台数が更新されていません"
+	//StatusScrapingFewSpots エリアの台数のあるスポットが少ない
+	StatusScrapingFewSpots StatusMessage = "台数が更新されたスポットが少なくなっています"
 )
+
+//ErrorCode エラーの種類（クライアントが判定に使う）
+type ErrorCode string
+
+const (
+	//ErrorInvalidParameter パラメータが不正
+	ErrorInvalidParameter ErrorCode = "invalid_parameter"
+	//ErrorUnauthorized 認証に失敗した
+	ErrorUnauthorized ErrorCode = "unauthorized"
+	//ErrorForbidden APIキーのスコープが足りない
+	ErrorForbidden ErrorCode = "forbidden"
+	//ErrorNotFound 対象が見つからない
+	ErrorNotFound ErrorCode = "not_found"
+	//ErrorMethodNotAllowed メソッドが許可されていない
+	ErrorMethodNotAllowed ErrorCode = "method_not_allowed"
+	//ErrorRateLimited リクエスト数の上限を超えた
+	ErrorRateLimited ErrorCode = "rate_limited"
+	//ErrorInternal サーバ内部のエラー（DBの検索失敗など）
+	ErrorInternal ErrorCode = "internal_error"
+)
+
+//ResultFailed エラー時のresult
+const ResultFailed = "failed"
@@ -0,0 +1,148 @@
+package static
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  GBFS（General Bikeshare Feed Specification v2.3）
+//  https://github.com/MobilityData/gbfs/blob/v2.3/gbfs.md
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//GBFSVersion 対応しているGBFSのバージョン
+const GBFSVersion = "2.3"
+
+//GBFSFeedName フィード名
+type GBFSFeedName string
+
+const (
+	//GBFSFeedSystemInformation system_information.json
+	GBFSFeedSystemInformation GBFSFeedName = "system_information"
+	//GBFSFeedStationInformation station_information.json
+	GBFSFeedStationInformation GBFSFeedName = "station_information"
+	//GBFSFeedStationStatus station_status.json
+	GBFSFeedStationStatus GBFSFeedName = "station_status"
+)
+
+//GBFSID station_idなどのID（v1のフィードでは数値のこともあるため文字列に揃える）
+type GBFSID string
+
+//GBFSBool 真偽値（v1のフィードでは0, 1のこともあるため両方受け付ける）
+type GBFSBool bool
+
+//JGBFSHeader 全フィード共通のヘッダ
+type JGBFSHeader struct {
+	LastUpdated int64  `json:"last_updated"`
+	TTL         int    `json:"ttl"`
+	Version     string `json:"version"`
+}
+
+//JGBFSDiscovery gbfs.json
+type JGBFSDiscovery struct {
+	JGBFSHeader
+	Data map[string]JGBFSFeeds `json:"data"`
+}
+
+//JGBFSFeeds gbfs.jsonの言語ごとのフィード一覧
+type JGBFSFeeds struct {
+	Feeds []JGBFSFeed `json:"feeds"`
+}
+
+//JGBFSFeed gbfs.jsonのフィード
+type JGBFSFeed struct {
+	Name GBFSFeedName `json:"name"`
+	URL  string       `json:"url"`
+}
+
+//JGBFSSystemInformation system_information.json
+type JGBFSSystemInformation struct {
+	JGBFSHeader
+	Data JGBFSSystem `json:"data"`
+}
+
+//JGBFSSystem system_information.jsonのデータ
+type JGBFSSystem struct {
+	SystemID string `json:"system_id"`
+	Language string `json:"language"`
+	Name     string `json:"name"`
+	Operator string `json:"operator,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Timezone string `json:"timezone"`
+}
+
+//JGBFSStationInformation station_information.json
+type JGBFSStationInformation struct {
+	JGBFSHeader
+	Data struct {
+		Stations []JGBFSStation `json:"stations"`
+	} `json:"data"`
+}
+
+//JGBFSStation station_information.jsonのステーション
+type JGBFSStation struct {
+	StationID GBFSID  `json:"station_id"`
+	Name      string  `json:"name"`
+	ShortName string  `json:"short_name,omitempty"`
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+	Address   string  `json:"address,omitempty"`
+	Capacity  *int    `json:"capacity,omitempty"`
+}
+
+//JGBFSStationStatus station_status.json
+type JGBFSStationStatus struct {
+	JGBFSHeader
+	Data struct {
+		Stations []JGBFSStatus `json:"stations"`
+	} `json:"data"`
+}
+
+//JGBFSStatus station_status.jsonのステーション
+//空きラック数は取得できないためnum_docks_availableは出力しない
+type JGBFSStatus struct {
+	StationID         GBFSID   `json:"station_id"`
+	NumBikesAvailable int      `json:"num_bikes_available"`
+	NumDocksAvailable *int     `json:"num_docks_available,omitempty"`
+	IsInstalled       GBFSBool `json:"is_installed"`
+	IsRenting         GBFSBool `json:"is_renting"`
+	IsReturning       GBFSBool `json:"is_returning"`
+	LastReported      int64    `json:"last_reported"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//UnmarshalJSON 文字列と数値のどちらも受け付ける
+func (id *GBFSID) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		*id = GBFSID(value)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return err
+	}
+	*id = GBFSID(number.String())
+	return nil
+}
+
+//UnmarshalJSON true, falseと1, 0のどちらも受け付ける
+func (b *GBFSBool) UnmarshalJSON(data []byte) error {
+	switch strings.TrimSpace(string(bytes.Trim(data, `"`))) {
+	case "true", "1":
+		*b = true
+	case "false", "0", "null":
+		*b = false
+	default:
+		var value bool
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		*b = GBFSBool(value)
+	}
+	return nil
+}
@@ -15,7 +15,12 @@ type JSpotinfo struct {
 }
 
 //JSpotmaster スクレーパーから受け取ったJSONパース用
+//Fullがtrueなら全ての有効なスポットを含む（含まれていないスポットは終了とする）
+//FullAreasのエリアはそのエリアの全ての有効なスポットを含む（含まれていないスポットはMASTER_MISSING_COUNT回続けば終了とする）
+//どちらもなければ送られてきたスポットの追加と更新のみ行う
 type JSpotmaster struct {
+	Full       bool     `json:"full"`
+	FullAreas  []string `json:"full_areas,omitempty"`
 	Spotmaster []struct {
 		Area string `json:"area"`
 		Spot string `json:"spot"`
@@ -34,6 +39,8 @@ type JCount struct {
 	Minute   string `json:"minute"`
 	Month    string `json:"month"`
 	Year     string `json:"year"`
+	//Name この時点のスポット名（JCountsBodyのnameと違う場合のみ）
+	Name string `json:"name,omitempty"`
 }
 
 //JCountsBody JSONマージャリング構造体
@@ -56,6 +63,8 @@ type JPlaces struct {
 	Lon         string `json:"lon"`
 	Name        string `json:"name"`
 	Recent      Recent `json:"recent"`
+	//Endtime 終了日時（include_closedで返した終了済みのスポットのみ）
+	Endtime string `json:"endtime,omitempty"`
 }
 
 //JPlacesBody JSONマージャリング構造体
@@ -85,16 +94,103 @@ type JDistances struct {
 	Lon         string `json:"lon"`
 	Name        string `json:"name"`
 	Distance    string `json:"distance"`
+	Meters      int    `json:"meters"`
 	Recent      Recent `json:"recent"`
 }
 
+//JPlacesHistoryBody JSONマージャリング構造体
+type JPlacesHistoryBody struct {
+	Area   string          `json:"area"`
+	Spot   string          `json:"spot"`
+	Active bool            `json:"active"`
+	Num    int             `json:"num"`
+	Items  []JPlaceVersion `json:"items"`
+}
+
+//JPlaceVersion JSONマージャリング構造体 JPlacesHistoryBodyの要素
+//endtimeは現在も有効な版ならnull
+type JPlaceVersion struct {
+	Name        string   `json:"name"`
+	Lat         string   `json:"lat"`
+	Lon         string   `json:"lon"`
+	Description string   `json:"description"`
+	Capacity    *int     `json:"capacity,omitempty"`
+	Starttime   string   `json:"starttime"`
+	Endtime     *string  `json:"endtime"`
+	Changes     []string `json:"changes"`
+}
+
+//JPlacesChangesBody JSONマージャリング構造体
+type JPlacesChangesBody struct {
+	Num   int            `json:"num"`
+	Items []JPlaceChange `json:"items"`
+}
+
+//JPlaceChange JSONマージャリング構造体 JPlacesChangesBodyの要素
+type JPlaceChange struct {
+	Datetime     string `json:"datetime"`
+	Change       string `json:"change"`
+	Area         string `json:"area"`
+	Spot         string `json:"spot"`
+	Name         string `json:"name"`
+	PreviousName string `json:"previous_name,omitempty"`
+	Lat          string `json:"lat"`
+	Lon          string `json:"lon"`
+}
+
+//JRollupBody JSONマージャリング構造体
+type JRollupBody struct {
+	Area        string    `json:"area"`
+	Spot        string    `json:"spot"`
+	Granularity string    `json:"granularity"`
+	Num         int       `json:"num"`
+	Items       []JRollup `json:"items"`
+}
+
+//JRollup JSONマージャリング構造体 JRollupBodyの要素
+//full_minutesはラック数が分かっているスポットのみ
+type JRollup struct {
+	Datetime     string  `json:"datetime"`
+	Min          int     `json:"min"`
+	Max          int     `json:"max"`
+	Avg          float64 `json:"avg"`
+	Last         int     `json:"last"`
+	Samples      int     `json:"samples"`
+	EmptyMinutes int     `json:"empty_minutes"`
+	FullMinutes  *int    `json:"full_minutes,omitempty"`
+}
+
+//JForecast JSONマージャリング構造体
+type JForecast struct {
+	Area        string   `json:"area"`
+	Spot        string   `json:"spot"`
+	Name        string   `json:"name"`
+	Datetime    string   `json:"datetime"`
+	Count       float64  `json:"count"`
+	Lower       float64  `json:"lower"`
+	Upper       float64  `json:"upper"`
+	Probability float64  `json:"probability"`
+	Num         int      `json:"num"`
+	Samples     []Recent `json:"samples"`
+}
+
+//JStreamEvent JSONマージャリング構造体 /streamで配信する台数の変化
+type JStreamEvent struct {
+	Area     string `json:"area"`
+	Spot     string `json:"spot"`
+	Count    string `json:"count"`
+	Previous string `json:"previous"`
+	Datetime string `json:"datetime"`
+}
+
 //JAllPlacesBody JSONマージャリング構造体
 type JAllPlacesBody struct {
 	Num   int `json:"num"`
 	Items []struct {
-		Area string `json:"area"`
-		Spot string `json:"spot"`
-		Name string `json:"name"`
+		Area    string `json:"area"`
+		Spot    string `json:"spot"`
+		Name    string `json:"name"`
+		Endtime string `json:"endtime,omitempty"`
 	} `json:"items"`
 }
 
@@ -103,6 +199,8 @@ type JAllSpotChiled struct {
 	Area string `json:"area"`
 	Spot string `json:"spot"`
 	Name string `json:"name"`
+	//Endtime 終了日時（include_closedで返した終了済みのスポットのみ）
+	Endtime string `json:"endtime,omitempty"`
 }
 
 //JConfig JSONマージャリング構造体
@@ -145,4 +243,80 @@ type JServiceStatus struct {
 	Status     StatusMessage `json:"status"`
 	Connection StatusMessage `json:"connection"`
 	Scraping   StatusMessage `json:"scraping"`
+	//ScrapingDetail スクレイピングの詳細（DBに接続できたときのみ）
+	ScrapingDetail *JScrapingStatus `json:"scraping_detail,omitempty"`
+}
+
+//JScrapingStatus スクレイピングの詳細
+type JScrapingStatus struct {
+	//Latest 最新の台数の時刻
+	Latest *string `json:"latest"`
+	//LatestAge 最新の台数が何秒前のものか
+	LatestAge *int `json:"latest_age"`
+	//Reporting 直近WindowMinutes分に台数のあるスポット数
+	Reporting     int `json:"reporting"`
+	Active        int `json:"active"`
+	WindowMinutes int `json:"window_minutes"`
+	//StaleAreas 問題のあるエリア
+	StaleAreas []string              `json:"stale_areas"`
+	Areas      []JAreaScrapingStatus `json:"areas"`
+}
+
+//JAreaScrapingStatus エリアごとのスクレイピングの状況
+type JAreaScrapingStatus struct {
+	Area      string        `json:"area"`
+	Status    StatusMessage `json:"status"`
+	Latest    *string       `json:"latest"`
+	LatestAge *int          `json:"latest_age"`
+	Reporting int           `json:"reporting"`
+	Active    int           `json:"active"`
+}
+
+//JApikey JSONマージャリング構造体 APIキー（秘密文字列は含まない）
+type JApikey struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	RateLimit int      `json:"rate_limit"`
+	Usage     int64    `json:"usage"`
+	LastUsed  *string  `json:"last_used"`
+	Created   string   `json:"created"`
+	//PreviousUntil ローテーション前の秘密文字列を受け付ける期限（ローテーション中のみ）
+	PreviousUntil *string `json:"previous_until,omitempty"`
+	Revoked       *string `json:"revoked,omitempty"`
+}
+
+//JApikeysBody JSONマージャリング構造体
+type JApikeysBody struct {
+	Num   int       `json:"num"`
+	Items []JApikey `json:"items"`
+}
+
+//JApikeyRequest APIキーの作成のJSONパース用
+type JApikeyRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	RateLimit int      `json:"rate_limit"`
+}
+
+//JApikeySecret JSONマージャリング構造体 作成・ローテーションしたAPIキー（秘密文字列はこのときだけ返す）
+type JApikeySecret struct {
+	JApikey
+	Secret string `json:"secret"`
+}
+
+//JReload JSONマージャリング構造体 設定とキャッシュを読み込み直した結果
+type JReload struct {
+	Reloaded string `json:"reloaded"`
+	//Places キャッシュした有効なスポット数
+	Places int `json:"places"`
+	//Pending app.iniで変わったが再起動するまで反映されない設定
+	Pending []string `json:"pending"`
+}
+
+//JError JSONマージャリング構造体 エラー時のレスポンス
+type JError struct {
+	Result string    `json:"result"`
+	Code   ErrorCode `json:"code"`
+	Reason string    `json:"reason"`
 }
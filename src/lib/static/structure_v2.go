@@ -0,0 +1,80 @@
+package static
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  /v2/ のレスポンス
+//
+//　台数・距離は整数、緯度経度は小数、時刻はタイムゾーン付きのRFC 3339で返す
+//　v1の構造体は既存のクライアントのため変更しない
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//JCountsBodyV2 JSONマージャリング構造体
+type JCountsBodyV2 struct {
+	Area        string     `json:"area"`
+	Spot        string     `json:"spot"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Lat         *float64   `json:"lat"`
+	Lon         *float64   `json:"lon"`
+	Capacity    *int       `json:"capacity,omitempty"`
+	Counts      []JCountV2 `json:"counts"`
+}
+
+//JCountV2 JSONマージャリング構造体 JCountsBodyV2の要素
+type JCountV2 struct {
+	Count int    `json:"count"`
+	Time  string `json:"time"`
+	//Name この時点のスポット名（JCountsBodyV2のnameと違う場合のみ）
+	Name string `json:"name,omitempty"`
+}
+
+//RecentV2 最新の台数情報を格納する
+type RecentV2 struct {
+	Count int    `json:"count"`
+	Time  string `json:"time"`
+}
+
+//JPlaceV2 JSONマージャリング構造体
+//終了したスポットはrecentがnullでendtimeが入る
+type JPlaceV2 struct {
+	Area        string    `json:"area"`
+	Spot        string    `json:"spot"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Lat         *float64  `json:"lat"`
+	Lon         *float64  `json:"lon"`
+	Capacity    *int      `json:"capacity,omitempty"`
+	Recent      *RecentV2 `json:"recent"`
+	Endtime     *string   `json:"endtime,omitempty"`
+}
+
+//JPlacesBodyV2 JSONマージャリング構造体
+type JPlacesBodyV2 struct {
+	Num   int        `json:"num"`
+	Items []JPlaceV2 `json:"items"`
+}
+
+//JDistanceV2 JSONマージャリング構造体
+type JDistanceV2 struct {
+	JPlaceV2
+	Meters int `json:"meters"`
+}
+
+//JDistancesBodyV2 JSONマージャリング構造体
+type JDistancesBodyV2 struct {
+	Num   int           `json:"num"`
+	Items []JDistanceV2 `json:"items"`
+}
+
+//JAllPlaceV2 JSONマージャリング構造体
+type JAllPlaceV2 struct {
+	Area    string  `json:"area"`
+	Spot    string  `json:"spot"`
+	Name    string  `json:"name"`
+	Endtime *string `json:"endtime,omitempty"`
+}
+
+//JAllPlacesBodyV2 JSONマージャリング構造体
+type JAllPlacesBodyV2 struct {
+	Num   int           `json:"num"`
+	Items []JAllPlaceV2 `json:"items"`
+}
@@ -0,0 +1,221 @@
+package line
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：BikeshareのAPIクライアント
+//
+//　apiserverとgrapherへのリクエストは全てここを通す
+//　ctxのリクエストIDをX-Request-IDで渡してAPIサーバのログと突き合わせられるようにする
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	//jsonTimeLayout APIが返す日時の形式
+	jsonTimeLayout = "2006/01/02 15:04"
+	//graphProperty グラフの大きさ（幅,高さ）
+	graphProperty = "500,380"
+)
+
+//BikeshareClient apiserverとgrapherのクライアント
+type BikeshareClient struct {
+	//Endpoint apiserverのURL（末尾は/）
+	Endpoint string
+	//GraphEndpoint grapherの/graphのURL
+	GraphEndpoint string
+	//Cert API_CERT（X-API-Keyで渡す）
+	Cert   string
+	Client *http.Client
+}
+
+//SpotInfo スポットと最新の台数
+type SpotInfo struct {
+	Area, Spot, Name, Description string
+	//Counts 最新の台数（台数がなければ空）
+	Counts []SpotCount
+}
+
+//SpotCount ある時刻の台数
+type SpotCount struct {
+	Count int
+	Time  time.Time
+}
+
+//SpotDistance 指定した位置からの距離
+type SpotDistance struct {
+	SpotInfo SpotInfo
+	Distance string
+}
+
+//Graph 描画したグラフ
+type Graph struct {
+	Title, URL string
+	SpotInfo   SpotInfo
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  関数
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//newSpotInfo JSONのスポットと最新の台数を変換する
+func newSpotInfo(area, spot, name, description string, recent static.Recent) SpotInfo {
+	info := SpotInfo{Area: area, Spot: spot, Name: name, Description: description}
+	count, err := strconv.Atoi(strings.TrimSpace(recent.Count))
+	if err != nil {
+		return info
+	}
+	t, err := time.ParseInLocation(jsonTimeLayout, recent.Datetime, time.Local)
+	if err != nil {
+		return info
+	}
+	info.Counts = []SpotCount{{Count: count, Time: t}}
+	return info
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//GetServiceStatus APIの/statusを取得する（問題があるときは503で返るためステータスコードは見ない）
+func (c *BikeshareClient) GetServiceStatus(ctx context.Context) (status static.JServiceStatus, err error) {
+	req, err := c.newRequest(ctx, http.MethodGet, c.Endpoint+"status", nil)
+	if err != nil {
+		return status, err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return status, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	return status, err
+}
+
+//GetPlaces スポットと最新の台数を検索する（paramsは/placesのパラメータ）
+func (c *BikeshareClient) GetPlaces(ctx context.Context, params url.Values) ([]SpotInfo, error) {
+	var body static.JPlacesBody
+	if err := c.do(ctx, http.MethodGet, c.Endpoint+"places?"+params.Encode(), nil, &body); err != nil {
+		return nil, err
+	}
+	var spotinfos []SpotInfo
+	for _, item := range body.Items {
+		spotinfos = append(spotinfos, newSpotInfo(item.Area, item.Spot, item.Name, item.Description, item.Recent))
+	}
+	return spotinfos, nil
+}
+
+//GetDistances 指定した位置から近いスポットを検索する
+func (c *BikeshareClient) GetDistances(ctx context.Context, lat, lon float64) ([]SpotDistance, error) {
+	params := url.Values{}
+	params.Set("lat", strconv.FormatFloat(lat, 'f', -1, 64))
+	params.Set("lon", strconv.FormatFloat(lon, 'f', -1, 64))
+	var body static.JDistancesBody
+	if err := c.do(ctx, http.MethodGet, c.Endpoint+"distances?"+params.Encode(), nil, &body); err != nil {
+		return nil, err
+	}
+	var distances []SpotDistance
+	for _, item := range body.Items {
+		distances = append(distances, SpotDistance{
+			SpotInfo: newSpotInfo(item.Area, item.Spot, item.Name, item.Description, item.Recent),
+			Distance: item.Distance,
+		})
+	}
+	return distances, nil
+}
+
+//GetAllSpotNames 全てのスポットの名前
+func (c *BikeshareClient) GetAllSpotNames(ctx context.Context) (places static.JAllPlacesBody, err error) {
+	err = c.do(ctx, http.MethodGet, c.Endpoint+"all_places", nil, &places)
+	return places, err
+}
+
+//GetGraph スポットのグラフを描画する（daysが空なら直近の数日分）
+func (c *BikeshareClient) GetGraph(ctx context.Context, area, spot string, days []string) (Graph, error) {
+	params := url.Values{}
+	params.Set("area", area)
+	params.Set("spot", spot)
+	params.Set("property", graphProperty)
+	if len(days) > 0 {
+		params.Set("days", strings.Join(days, ","))
+	}
+	var body static.JGraphResponse
+	if err := c.do(ctx, http.MethodGet, c.GraphEndpoint+"?"+params.Encode(), nil, &body); err != nil {
+		return Graph{}, err
+	}
+	item := body.Item
+	return Graph{
+		Title:    body.Title,
+		URL:      body.URL,
+		SpotInfo: newSpotInfo(item.Area, item.Spot, item.Name, item.Description, item.Recent),
+	}, nil
+}
+
+//GetUsers 全てのユーザー設定
+func (c *BikeshareClient) GetUsers(ctx context.Context) ([]static.JUser, error) {
+	var body static.JUsers
+	err := c.do(ctx, http.MethodGet, c.Endpoint+"private/users", nil, &body)
+	return body.Users, err
+}
+
+//UpdateUser ユーザー設定を更新して全てのユーザー設定を返す
+func (c *BikeshareClient) UpdateUser(ctx context.Context, user static.JUser) ([]static.JUser, error) {
+	var body static.JUsers
+	err := c.do(ctx, http.MethodPost, c.Endpoint+"private/user", user, &body)
+	return body.Users, err
+}
+
+//do リクエストしてJSONのレスポンスをvに入れる（payloadがnilでなければJSONで送る）
+func (c *BikeshareClient) do(ctx context.Context, method, target string, payload interface{}, v interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := c.newRequest(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s status=%d", method, req.URL.Path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+//newRequest APIキーとctxのリクエストIDを付けたリクエスト
+func (c *BikeshareClient) newRequest(ctx context.Context, method, target string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Cert != "" {
+		req.Header.Set("X-API-Key", c.Cert)
+	}
+	if id := logger.RequestID(ctx); id != "" {
+		req.Header.Set(logger.RequestIDHeader, id)
+	}
+	return req, nil
+}
@@ -1,6 +1,7 @@
-package main
+package line
 
 import (
+	"context"
 	"strings"
 
 	"github.com/line/line-bot-sdk-go/linebot"
@@ -14,29 +15,29 @@ func ParseComamnd(data string) (postback PostBackCommand) {
 }
 
 //CommandHandler コマンドを処理
-func CommandHandler(event *linebot.Event, message *linebot.TextMessage) {
+func CommandHandler(ctx context.Context, event *linebot.Event, message *linebot.TextMessage) {
 	command := ParseComamnd(message.Text)
 	switch command.Type {
 	case PostBackCommandTypeAnalyze:
-		ReplyToPostbackAnalyze(event, &command)
+		ReplyToPostbackAnalyze(ctx, event, &command)
 	case PostBackCommandTypeHistory:
 		ReplyToPostbackHistory(event, &command)
 	case PostBackCommandTypeCommands:
 		ReplyToPostbackCommand(event, &command)
 	case PostBackCommandTypeFavoriteList:
-		ReplyToPostbackFavList(event, &command)
+		ReplyToPostbackFavList(ctx, event, &command)
 	case PostBackCommandTypeFavorite:
-		ReplyToPostbackFav(event, &command)
+		ReplyToPostbackFav(ctx, event, &command)
 	case PostBackCommandTypeDatePicker:
-		ReplyToPostbackDatePicker(event, &command)
+		ReplyToPostbackDatePicker(ctx, event, &command)
 	case PostBackCommandTypeConfigOpen:
 		ReplyToPostbackConfigOpen(event, &command)
 	case PostBackCommandTypeNotify:
-		ReplyToPostbackNotifyConfig(event, &command)
+		ReplyToPostbackNotifyConfig(ctx, event, &command)
 	case PostBackCommandTypeStatus:
-		ReplyToPostbackServiceStatus(event, &command)
+		ReplyToPostbackServiceStatus(ctx, event, &command)
 	case PostBackCommandTypeRanking:
-		ReplyToPostbackRanking(event, &command)
+		ReplyToPostbackRanking(ctx, event, &command)
 	case PostBackCommandTypeLacation:
 		reply := linebot.NewTextMessage("現在メニューから位置情報検索ができません。\n↓にある「位置情報で検索」をタップしてください").WithQuickReplies(CreateQuickReplyItems())
 		ReplyMessage(event.ReplyToken, reply)
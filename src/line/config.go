@@ -0,0 +1,117 @@
+package line
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：LINE botの設定
+//
+//　app.iniは使わず全て環境変数で設定する（--print-configで確認できる）
+//　環境変数は起動中に変わらないので、SIGHUPやPOST /reloadで読み込み直すのはキャッシュだけ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Config LINE botの設定
+type Config struct {
+	Line LineConfig `section:"LINE"`
+}
+
+//LineConfig 環境変数の設定
+type LineConfig struct {
+	ClientID     string `ini:"-" env:"LINE_CLIENT_ID"`
+	ClientSecret string `ini:"-" env:"LINE_CLIENT_SECRET" secret:"true"`
+	//APICert apiserverの管理用のAPIキー
+	APICert string `ini:"-" env:"API_CERT" secret:"true"`
+	//Mode DEBUGならlocalhost、LOCALならapiserverとgrapherコンテナのAPIを使う（空ならAPI_ENDPOINTとGRAPH_ENDPOINT）
+	Mode        string `ini:"-" env:"MODE"`
+	APIEndpoint string `ini:"-" env:"API_ENDPOINT" default:"https://hanetwi.ddns.net/bikeshare/api/v1/"`
+	//GraphEndpoint grapherの/graphのURL
+	GraphEndpoint string `ini:"-" env:"GRAPH_ENDPOINT" default:"https://hanetwi.ddns.net/bikeshare/graph"`
+	Port          int    `ini:"-" env:"PORT" default:"5050"`
+	//MetricsAddr 空なら同じポートで/metricsを公開する
+	MetricsAddr string `ini:"-" env:"METRICS_ADDR"`
+	LogLevel    string `ini:"-" env:"LOG_LEVEL" default:"info"`
+	LogFormat   string `ini:"-" env:"LOG_FORMAT" default:"text"`
+	//ReloadInterval スポット名とユーザー設定のキャッシュを読み込み直す間隔（分、0なら一定間隔では読み込み直さない）
+	ReloadInterval int `ini:"-" env:"RELOAD_INTERVAL" default:"10"`
+	//ShutdownTimeout SIGTERMを受けてから処理中のリクエストを待つ時間（秒）
+	ShutdownTimeout int `ini:"-" env:"SHUTDOWN_TIMEOUT" default:"30"`
+}
+
+//conf 起動時に読み込んだ設定
+var conf Config
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  レシーバ
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//Validate config.Validatorの実装
+func (c *LineConfig) Validate() error {
+	var errs []string
+	if c.ClientID == "" {
+		errs = append(errs, "LINE_CLIENT_ID を設定してください")
+	}
+	if c.ClientSecret == "" {
+		errs = append(errs, "LINE_CLIENT_SECRET を設定してください")
+	}
+	switch c.Mode {
+	case "", "DEBUG", "LOCAL":
+	default:
+		errs = append(errs, fmt.Sprintf("MODE=%s には対応していません（DEBUG, LOCALまたは空）", c.Mode))
+	}
+	if !strings.HasPrefix(c.APIEndpoint, "http://") && !strings.HasPrefix(c.APIEndpoint, "https://") {
+		errs = append(errs, fmt.Sprintf("API_ENDPOINT=%s はhttp://かhttps://で始まるURLにしてください", c.APIEndpoint))
+	}
+	if !strings.HasPrefix(c.GraphEndpoint, "http://") && !strings.HasPrefix(c.GraphEndpoint, "https://") {
+		errs = append(errs, fmt.Sprintf("GRAPH_ENDPOINT=%s はhttp://かhttps://で始まるURLにしてください", c.GraphEndpoint))
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("PORT=%d は1から65535の間にしてください", c.Port))
+	}
+	if c.ReloadInterval < 0 {
+		errs = append(errs, fmt.Sprintf("RELOAD_INTERVAL=%d は0以上にしてください", c.ReloadInterval))
+	}
+	if c.ShutdownTimeout < 1 {
+		errs = append(errs, fmt.Sprintf("SHUTDOWN_TIMEOUT=%d は1以上にしてください", c.ShutdownTimeout))
+	}
+	if _, err := logger.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Sprintf("LOG_LEVEL : %v", err))
+	}
+	if _, err := logger.ParseFormat(c.LogFormat); err != nil {
+		errs = append(errs, fmt.Sprintf("LOG_FORMAT : %v", err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+//Endpoint 使うAPIのURL（末尾は/）
+func (c *LineConfig) Endpoint() string {
+	switch c.Mode {
+	case "DEBUG":
+		//デバッグ用
+		return "http://localhost:5001/"
+	case "LOCAL":
+		//APIサーバと同じサーバにあるとき
+		return "http://apiserver:5001/"
+	}
+	if !strings.HasSuffix(c.APIEndpoint, "/") {
+		return c.APIEndpoint + "/"
+	}
+	return c.APIEndpoint
+}
+
+//GraphURL 使うgrapherの/graphのURL
+func (c *LineConfig) GraphURL() string {
+	switch c.Mode {
+	case "DEBUG":
+		return "http://localhost:5010/graph"
+	case "LOCAL":
+		return "http://grapher:5010/graph"
+	}
+	return c.GraphEndpoint
+}
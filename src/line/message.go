@@ -1,9 +1,13 @@
-package main
+package line
 
 import (
+	"context"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 
-	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
 	"github.com/8245snake/bikeshare_api/src/lib/static"
 	"github.com/line/line-bot-sdk-go/linebot"
 )
@@ -27,9 +31,10 @@ func CreateQuickReplyItems() *linebot.QuickReplyItems {
 }
 
 //MakeServiceStatusMessage テンプレートメッセージ
-func MakeServiceStatusMessage() linebot.SendingMessage {
-	status, err := BikeshareAPI.GetStatus()
+func MakeServiceStatusMessage(ctx context.Context) linebot.SendingMessage {
+	status, err := BikeshareAPI.GetServiceStatus(ctx)
 	if err != nil {
+		logger.FromContext(ctx).Errorf("MakeServiceStatusMessage GetServiceStatusでエラー : %v", err)
 		return linebot.NewTextMessage("APIとの通信に失敗しています")
 	}
 
@@ -42,21 +47,41 @@ func MakeServiceStatusMessage() linebot.SendingMessage {
 			message = linebot.NewTextMessage("DBとの接続が切れています")
 		}
 		if status.Scraping != static.StatusOK {
-			message = linebot.NewTextMessage("台数データの取得に失敗しています")
+			message = linebot.NewTextMessage(makeScrapingStatusText(status.ScrapingDetail))
 		}
 	}
 	return message
 }
 
+//makeScrapingStatusText 台数が更新されていないエリアの一覧
+func makeScrapingStatusText(detail *static.JScrapingStatus) string {
+	if detail == nil || len(detail.StaleAreas) == 0 {
+		return "台数データの取得に失敗しています"
+	}
+	text := "台数データの取得が滞っているエリアがあります"
+	for _, area := range detail.Areas {
+		if area.Status == static.StatusOK {
+			continue
+		}
+		latest := "なし"
+		if area.Latest != nil {
+			latest = *area.Latest
+		}
+		text += fmt.Sprintf("\n・%s：%s（%d/%dスポット、最終更新 %s）", area.Area, area.Status, area.Reporting, area.Active, latest)
+	}
+	return text
+}
+
 //MakeSpotListMessageForLocation 位置情報への返信
-func MakeSpotListMessageForLocation(lat, lon float64) linebot.SendingMessage {
-	distances, err := BikeshareAPI.GetDistances(bikeshareapi.SearchDistanceOption{Lat: lat, Lon: lon})
+func MakeSpotListMessageForLocation(ctx context.Context, lat, lon float64) linebot.SendingMessage {
+	distances, err := BikeshareAPI.GetDistances(ctx, lat, lon)
 	if err != nil {
+		logger.FromContext(ctx).Errorf("MakeSpotListMessageForLocation GetDistancesでエラー : %v", err)
 		return linebot.NewTextMessage("検索に失敗しました")
 	}
-	var spotinfos []bikeshareapi.SpotInfo
-	for _, place := range distances.Spots {
-		info := bikeshareapi.SpotInfo{
+	var spotinfos []SpotInfo
+	for _, place := range distances {
+		info := SpotInfo{
 			Area:   place.SpotInfo.Area,
 			Spot:   place.SpotInfo.Spot,
 			Name:   place.SpotInfo.Name + "\n" + place.Distance,
@@ -70,11 +95,12 @@ func MakeSpotListMessageForLocation(lat, lon float64) linebot.SendingMessage {
 }
 
 //MakeSpotListMessage テンプレートメッセージ
-func MakeSpotListMessage(query string) linebot.SendingMessage {
+func MakeSpotListMessage(ctx context.Context, query string) linebot.SendingMessage {
 	var reply linebot.SendingMessage
 	//件数によってテンプレートを振り分ける
-	spotinfos, err := BikeshareAPI.GetPlaces(bikeshareapi.SearchPlacesOption{Query: query})
+	spotinfos, err := BikeshareAPI.GetPlaces(ctx, url.Values{"q": {query}})
 	if err != nil {
+		logger.FromContext(ctx).Errorf("MakeSpotListMessage GetPlacesでエラー : %v", err)
 		reply = linebot.NewTextMessage("駐輪場の検索に失敗しました")
 		return reply
 	}
@@ -96,7 +122,7 @@ func MakeSpotListMessage(query string) linebot.SendingMessage {
 }
 
 //MakeFavriteListMessage テンプレートメッセージ
-func MakeFavriteListMessage(userID string) linebot.SendingMessage {
+func MakeFavriteListMessage(ctx context.Context, userID string) linebot.SendingMessage {
 	user := GetUserConfigFromCache(userID)
 	if user == nil {
 		return linebot.NewTextMessage("ユーザ設定が読み込まれませんでした")
@@ -104,8 +130,9 @@ func MakeFavriteListMessage(userID string) linebot.SendingMessage {
 	if len(user.Favorites) < 1 {
 		return linebot.NewTextMessage("お気に入りがまだ登録されていません")
 	}
-	spotinfos, err := BikeshareAPI.GetPlaces(bikeshareapi.SearchPlacesOption{Places: user.Favorites})
+	spotinfos, err := BikeshareAPI.GetPlaces(ctx, url.Values{"places": {strings.Join(user.Favorites, ",")}})
 	if err != nil {
+		logger.FromContext(ctx).Errorf("MakeFavriteListMessage GetPlacesでエラー : %v", err)
 		return linebot.NewTextMessage("検索に失敗しました")
 	}
 	if len(spotinfos) < 1 {
@@ -119,9 +146,10 @@ func MakeFavriteListMessage(userID string) linebot.SendingMessage {
 }
 
 //MakeRankingMessage ランキング
-func MakeRankingMessage(limit int) linebot.SendingMessage {
-	spotinfos, err := BikeshareAPI.GetPlaces(bikeshareapi.SearchPlacesOption{Sort: "countd", Limit: limit})
+func MakeRankingMessage(ctx context.Context, limit int) linebot.SendingMessage {
+	spotinfos, err := BikeshareAPI.GetPlaces(ctx, url.Values{"sort": {"countd"}, "limit": {strconv.Itoa(limit)}})
 	if err != nil {
+		logger.FromContext(ctx).Errorf("MakeRankingMessage GetPlacesでエラー : %v", err)
 		return linebot.NewTextMessage("検索に失敗しました")
 	}
 	count := len(spotinfos)
@@ -142,15 +170,10 @@ func MakeRankingMessage(limit int) linebot.SendingMessage {
 }
 
 //MakeAnalysisMessage グラフ表示メッセージの作成
-func MakeAnalysisMessage(area string, spot string, span int, userID string) linebot.SendingMessage {
-	option := bikeshareapi.SearchGraphOption{
-		Area:        area,
-		Spot:        spot,
-		Property:    "500,380",
-		UploadImgur: false,
-	}
-	graph, err := BikeshareAPI.GetGraph(option)
+func MakeAnalysisMessage(ctx context.Context, area string, spot string, span int, userID string) linebot.SendingMessage {
+	graph, err := BikeshareAPI.GetGraph(ctx, area, spot, nil)
 	if err != nil {
+		logger.FromContext(ctx).Errorf("MakeAnalysisMessage GetGraphでエラー : %v", err)
 		return linebot.NewTextMessage("グラフの作成に失敗しました")
 	}
 
@@ -203,16 +226,10 @@ func MakeHistryListMessage(userID string) linebot.SendingMessage {
 }
 
 //MakeDateAnalysisMessage 任意の日付のグラフ表示メッセージの作成
-func MakeDateAnalysisMessage(area string, spot string, userID string, days ...string) linebot.SendingMessage {
-	option := bikeshareapi.SearchGraphOption{
-		Area:        area,
-		Spot:        spot,
-		Property:    "500,380",
-		UploadImgur: false,
-		Days:        days,
-	}
-	graph, err := BikeshareAPI.GetGraph(option)
+func MakeDateAnalysisMessage(ctx context.Context, area string, spot string, userID string, days ...string) linebot.SendingMessage {
+	graph, err := BikeshareAPI.GetGraph(ctx, area, spot, days)
 	if err != nil {
+		logger.FromContext(ctx).Errorf("MakeDateAnalysisMessage GetGraphでエラー : %v", err)
 		return linebot.NewTextMessage("グラフの作成に失敗しました")
 	}
 
@@ -1,17 +1,24 @@
-package main
+package line
 
 import (
-	"fmt"
+	"context"
 	"strings"
 
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/metrics"
 	"github.com/line/line-bot-sdk-go/linebot"
 )
 
+//lineMessages LINEへのメッセージの送信数（kindはreplyまたはnotify、resultはokまたはerror）
+var lineMessages = metrics.NewCounter("bikeshare_line_messages_total", "LINEへのメッセージの送信数", "kind", "result")
+
 //ReplyMessage 返信用共通関数
 func ReplyMessage(replyToken string, message linebot.SendingMessage) error {
 	//_, err := LineBotAPI.ReplyMessage(replyToken, message.WithQuickReplies(CreateQuickReplyItems())).Do()
 	_, err := LineBotAPI.ReplyMessage(replyToken, message).Do()
+	lineMessages.Inc("reply", messageResult(err))
 	if err != nil {
+		logger.Errorf("ReplyMessage ReplyMessageでエラー : %v", err)
 		//だめかもしれないけどとりあえずエラーメッセージの再送を試みる
 		ReplyMessage(replyToken, linebot.NewTextMessage(err.Error()))
 	}
@@ -19,15 +26,15 @@ func ReplyMessage(replyToken string, message linebot.SendingMessage) error {
 }
 
 //ReplyToFollowEvent フォローされたとき
-func ReplyToFollowEvent(event *linebot.Event) {
+func ReplyToFollowEvent(ctx context.Context, event *linebot.Event) {
 	//ユーザー登録
-	UpdateUserConfig(UserUpdateTypeUserAdd, event.Source.UserID, "")
+	UpdateUserConfig(ctx, UserUpdateTypeUserAdd, event.Source.UserID, "")
 	//返信
 	ReplyMessage(event.ReplyToken, linebot.NewTextMessage("フォローありがとうございます！\n駐輪場の名前を入力してみてください"))
 }
 
 //ReplyToTextMessage テキストメッセージへの返信
-func ReplyToTextMessage(event *linebot.Event, message *linebot.TextMessage) {
+func ReplyToTextMessage(ctx context.Context, event *linebot.Event, message *linebot.TextMessage) {
 	replyToken := event.ReplyToken
 	text := message.Text
 
@@ -35,21 +42,21 @@ func ReplyToTextMessage(event *linebot.Event, message *linebot.TextMessage) {
 	default:
 		if strings.Index(text, "/") == 0 {
 			//スラッシュコマンド
-			CommandHandler(event, message)
+			CommandHandler(ctx, event, message)
 			break
 		}
 		//その他のメッセージは駐輪場検索とする
-		reply := MakeSpotListMessage(text)
+		reply := MakeSpotListMessage(ctx, text)
 		ReplyMessage(replyToken, reply)
 
 		// 検索履歴は駐輪場検索のみ保存する
-		UpdateUserConfig(UserUpdateTypeHistory, event.Source.UserID, text)
+		UpdateUserConfig(ctx, UserUpdateTypeHistory, event.Source.UserID, text)
 	}
 }
 
 //ReplyToStickerMessage スタンプへの返信
 func ReplyToStickerMessage(event *linebot.Event, message *linebot.StickerMessage) {
-	fmt.Printf("StickerID=%s\n", message.StickerID)
+	logger.Debugf("StickerID=%s", message.StickerID)
 	replyToken := event.ReplyToken
 	//適当なスタンプを返す
 	reply := linebot.NewStickerMessage("11537", "52002734")
@@ -57,16 +64,16 @@ func ReplyToStickerMessage(event *linebot.Event, message *linebot.StickerMessage
 }
 
 //ReplyToLocationMessage 位置情報メッセージへの返信
-func ReplyToLocationMessage(event *linebot.Event, message *linebot.LocationMessage) {
+func ReplyToLocationMessage(ctx context.Context, event *linebot.Event, message *linebot.LocationMessage) {
 	replyToken := event.ReplyToken
-	reply := MakeSpotListMessageForLocation(message.Latitude, message.Longitude)
+	reply := MakeSpotListMessageForLocation(ctx, message.Latitude, message.Longitude)
 	ReplyMessage(replyToken, reply)
 }
 
 //ReplyToPostbackAnalyze グラフ表示
-func ReplyToPostbackAnalyze(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackAnalyze(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	replyToken := event.ReplyToken
-	reply := MakeAnalysisMessage(command.Area, command.Spot, command.Span, event.Source.UserID)
+	reply := MakeAnalysisMessage(ctx, command.Area, command.Spot, command.Span, event.Source.UserID)
 	ReplyMessage(replyToken, reply)
 }
 
@@ -85,31 +92,31 @@ func ReplyToPostbackHistory(event *linebot.Event, command *PostBackCommand) {
 }
 
 //ReplyToPostbackServiceStatus サービス稼働状況の表示
-func ReplyToPostbackServiceStatus(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackServiceStatus(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	replyToken := event.ReplyToken
-	reply := MakeServiceStatusMessage()
+	reply := MakeServiceStatusMessage(ctx)
 	ReplyMessage(replyToken, reply)
 }
 
 //ReplyToPostbackFavList お気に入り一覧表示
-func ReplyToPostbackFavList(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackFavList(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	replyToken := event.ReplyToken
-	reply := MakeFavriteListMessage(event.Source.UserID)
+	reply := MakeFavriteListMessage(ctx, event.Source.UserID)
 	ReplyMessage(replyToken, reply)
 }
 
 //ReplyToPostbackRanking ランキング表示
-func ReplyToPostbackRanking(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackRanking(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	replyToken := event.ReplyToken
-	reply := MakeRankingMessage(20)
+	reply := MakeRankingMessage(ctx, 20)
 	ReplyMessage(replyToken, reply)
 }
 
 //ReplyToPostbackDatePicker 日付検索
-func ReplyToPostbackDatePicker(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackDatePicker(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	replyToken := event.ReplyToken
 	day := strings.Replace(event.Postback.Params.Date, "-", "", -1)
-	reply := MakeDateAnalysisMessage(command.Area, command.Spot, event.Source.UserID, day)
+	reply := MakeDateAnalysisMessage(ctx, command.Area, command.Spot, event.Source.UserID, day)
 	ReplyMessage(replyToken, reply)
 }
 
@@ -121,7 +128,7 @@ func ReplyToPostbackConfigOpen(event *linebot.Event, command *PostBackCommand) {
 }
 
 //ReplyToPostbackFav お気に入り登録
-func ReplyToPostbackFav(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackFav(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	var reply linebot.SendingMessage
 	user := GetUserConfigFromCache(event.Source.UserID)
 	if user == nil {
@@ -139,14 +146,14 @@ func ReplyToPostbackFav(event *linebot.Event, command *PostBackCommand) {
 			break
 		}
 		//登録
-		UpdateUserConfig(UserUpdateTypeFavorite, userID, code)
+		UpdateUserConfig(ctx, UserUpdateTypeFavorite, userID, code)
 		reply = MakeDateConfigWindowMessage(userID)
 	case PostBackCommandModeUnreg:
 		if len(user.Favorites) < 1 {
 			reply = linebot.NewTextMessage("お気に入りを削除できません")
 			break
 		}
-		UpdateUserConfig(UserUpdateTypeFavoriteDelete, userID, code)
+		UpdateUserConfig(ctx, UserUpdateTypeFavoriteDelete, userID, code)
 		reply = MakeDateConfigWindowMessage(userID)
 	}
 	//返信
@@ -154,7 +161,7 @@ func ReplyToPostbackFav(event *linebot.Event, command *PostBackCommand) {
 }
 
 //ReplyToPostbackNotifyConfig 通知時刻編集
-func ReplyToPostbackNotifyConfig(event *linebot.Event, command *PostBackCommand) {
+func ReplyToPostbackNotifyConfig(ctx context.Context, event *linebot.Event, command *PostBackCommand) {
 	var reply linebot.SendingMessage
 	user := GetUserConfigFromCache(event.Source.UserID)
 	if user == nil {
@@ -171,7 +178,7 @@ func ReplyToPostbackNotifyConfig(event *linebot.Event, command *PostBackCommand)
 			break
 		}
 		target := event.Postback.Params.Time
-		UpdateUserConfig(UserUpdateTypeNotify, userID, target)
+		UpdateUserConfig(ctx, UserUpdateTypeNotify, userID, target)
 		reply = MakeDateConfigWindowMessage(userID)
 	case PostBackCommandModeUnreg:
 		if len(user.Notifies) < 1 {
@@ -179,7 +186,7 @@ func ReplyToPostbackNotifyConfig(event *linebot.Event, command *PostBackCommand)
 			break
 		}
 		target := command.Target
-		UpdateUserConfig(UserUpdateTypeNotifyDelete, userID, target)
+		UpdateUserConfig(ctx, UserUpdateTypeNotifyDelete, userID, target)
 		reply = MakeDateConfigWindowMessage(userID)
 	}
 	//返信
@@ -187,15 +194,27 @@ func ReplyToPostbackNotifyConfig(event *linebot.Event, command *PostBackCommand)
 }
 
 //SendScheduledNotify 通知を送信する
-func SendScheduledNotify(userID string) {
-	message := MakeFavriteListMessage(userID)
+func SendScheduledNotify(ctx context.Context, userID string) {
+	message := MakeFavriteListMessage(ctx, userID)
 	switch message.(type) {
 	case *linebot.FlexMessage:
 		//_, err := LineBotAPI.PushMessage(userID, message.WithQuickReplies(CreateQuickReplyItems())).Do()
 		_, err := LineBotAPI.PushMessage(userID, message).Do()
-		fmt.Printf("%v\n", err)
+		lineMessages.Inc("notify", messageResult(err))
+		if err != nil {
+			logger.FromContext(ctx).Errorf("SendScheduledNotify PushMessageでエラー : %v", err)
+		}
 	case *linebot.TextMessage:
 		//バブルコンテナの作成に失敗したときなので何もしない
+		lineMessages.Inc("notify", "error")
 		return
 	}
 }
+
+//messageResult メトリクスのresultラベル
+func messageResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
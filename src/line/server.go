@@ -1,16 +1,23 @@
-package main
+package line
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
+	"time"
 
-	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/8245snake/bikeshare_api/src/lib/command"
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/metrics"
+	"github.com/8245snake/bikeshare_api/src/lib/reload"
+	"github.com/8245snake/bikeshare_api/src/lib/shutdown"
+	"github.com/8245snake/bikeshare_api/src/lib/spotcache"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
 	"github.com/line/line-bot-sdk-go/linebot"
 )
 
@@ -37,9 +44,11 @@ var (
 	//LineBotAPI LINEのAPIクライアント
 	LineBotAPI *linebot.Client
 	//BikeshareAPI BikeshareのAPIクライアント
-	BikeshareAPI bikeshareapi.ApiClient
-	//SpotNamesDictionary スポット名の辞書
-	SpotNamesDictionary = make(map[string]string)
+	BikeshareAPI *BikeshareClient
+	//SpotNames スポット名の辞書
+	SpotNames = &spotcache.Cache{}
+	//reloader キャッシュの読み込み直し
+	reloader = reload.New(reloadCaches)
 )
 
 //getAccessToken アクセストークン取得
@@ -83,10 +92,23 @@ func getAccessToken() string {
 	return data.AccessToken
 }
 
+//requestContext リクエストID（X-Request-IDがなければ生成する）を入れたcontextを返す
+func requestContext(w http.ResponseWriter, req *http.Request) context.Context {
+	id := req.Header.Get(logger.RequestIDHeader)
+	if !logger.ValidRequestID(id) {
+		id = logger.NewRequestID()
+	}
+	w.Header().Set(logger.RequestIDHeader, id)
+	return logger.NewContext(req.Context(), id)
+}
+
 //CallbackHandler コールバック処理
 func CallbackHandler(w http.ResponseWriter, req *http.Request) {
+	ctx := requestContext(w, req)
+	reqLog := logger.FromContext(ctx)
 	events, err := LineBotAPI.ParseRequest(req)
 	if err != nil {
+		reqLog.Warnf("CallbackHandler ParseRequestでエラー : %v", err)
 		if err == linebot.ErrInvalidSignature {
 			w.WriteHeader(400)
 		} else {
@@ -95,46 +117,47 @@ func CallbackHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	for _, event := range events {
+		reqLog.Debugf("CallbackHandler イベント %s", event.Type)
 		switch event.Type {
 		case linebot.EventTypeMessage:
 			switch message := event.Message.(type) {
 			case *linebot.TextMessage:
 				//普通のテキストメッセージ
-				ReplyToTextMessage(event, message)
+				ReplyToTextMessage(ctx, event, message)
 			case *linebot.StickerMessage:
 				//スタンプ
 				ReplyToStickerMessage(event, message)
 			case *linebot.LocationMessage:
 				//位置情報
-				ReplyToLocationMessage(event, message)
+				ReplyToLocationMessage(ctx, event, message)
 			}
 		case linebot.EventTypeFollow:
-			ReplyToFollowEvent(event)
+			ReplyToFollowEvent(ctx, event)
 		case linebot.EventTypeUnfollow:
-			fmt.Printf("%v\n", event)
+			reqLog.Infof("CallbackHandler ブロックされました")
 		case linebot.EventTypePostback:
 			// Postbackのコマンド振り分け
 			switch command := ParsePostbackData(event.Postback.Data); command.Type {
 			case PostBackCommandTypeAnalyze:
-				ReplyToPostbackAnalyze(event, &command)
+				ReplyToPostbackAnalyze(ctx, event, &command)
 			case PostBackCommandTypeHistory:
 				ReplyToPostbackHistory(event, &command)
 			case PostBackCommandTypeCommands:
 				ReplyToPostbackCommand(event, &command)
 			case PostBackCommandTypeFavoriteList:
-				ReplyToPostbackFavList(event, &command)
+				ReplyToPostbackFavList(ctx, event, &command)
 			case PostBackCommandTypeFavorite:
-				ReplyToPostbackFav(event, &command)
+				ReplyToPostbackFav(ctx, event, &command)
 			case PostBackCommandTypeDatePicker:
-				ReplyToPostbackDatePicker(event, &command)
+				ReplyToPostbackDatePicker(ctx, event, &command)
 			case PostBackCommandTypeConfigOpen:
 				ReplyToPostbackConfigOpen(event, &command)
 			case PostBackCommandTypeNotify:
-				ReplyToPostbackNotifyConfig(event, &command)
+				ReplyToPostbackNotifyConfig(ctx, event, &command)
 			case PostBackCommandTypeStatus:
-				ReplyToPostbackServiceStatus(event, &command)
+				ReplyToPostbackServiceStatus(ctx, event, &command)
 			case PostBackCommandTypeRanking:
-				ReplyToPostbackRanking(event, &command)
+				ReplyToPostbackRanking(ctx, event, &command)
 			}
 
 		case linebot.EventTypeJoin:
@@ -156,14 +179,72 @@ func NotifyHandler(w http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
 	params := req.Form
 	userID := params.Get("user")
-	SendScheduledNotify(userID)
+	SendScheduledNotify(requestContext(w, req), userID)
+}
+
+//ReloadHandler スポット名とユーザー設定のキャッシュを読み込み直す
+//X-API-Keyかcertヘッダに環境変数API_CERTと同じ値が必要（API_CERTが空なら使えない）
+func ReloadHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if conf.Line.APICert == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	key := req.Header.Get("X-API-Key")
+	if key == "" {
+		key = req.Header.Get("cert")
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(conf.Line.APICert)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err := reloader.Reload(reload.TriggerAPI); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	body, _ := json.Marshal(static.JReload{Reloaded: time.Now().Format("2006/01/02 15:04"), Places: SpotNames.Len(), Pending: []string{}})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+//CacheSpotNames スポット名の辞書を作り直す（失敗したら前のまま）
+func CacheSpotNames(ctx context.Context) error {
+	places, err := BikeshareAPI.GetAllSpotNames(ctx)
+	if err != nil {
+		return err
+	}
+	entries := make([]spotcache.Entry, len(places.Items))
+	for i, place := range places.Items {
+		entries[i] = spotcache.Entry{Area: place.Area, Spot: place.Spot, Name: place.Name}
+	}
+	SpotNames.Set(entries)
+	return nil
+}
+
+//reloadCaches reload.Funcの実装（設定は環境変数なので読み込み直さない）
+func reloadCaches(settings bool) error {
+	ctx := context.Background()
+	var errs []string
+	if err := CacheSpotNames(ctx); err != nil {
+		errs = append(errs, fmt.Sprintf("スポット名を読み込めません : %v", err))
+	}
+	if err := CacheUsrConfigs(ctx); err != nil {
+		errs = append(errs, fmt.Sprintf("ユーザー設定を読み込めません : %v", err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
 }
 
 //GetPlaceNameByCode コードから名前を返す
 //ない場合は空文字を返す
 func GetPlaceNameByCode(code string) (name string) {
-	if val, ok := SpotNamesDictionary[code]; ok {
-		name = val
+	if entry, ok := SpotNames.Get(SplitAreaSpot(code)); ok {
+		name = entry.Name
 	}
 	return name
 }
@@ -178,49 +259,75 @@ func SplitAreaSpot(code string) (area string, spot string) {
 	return
 }
 
-func init() {
-	ClientID = os.Getenv("LINE_CLIENT_ID")
-	ClientSecret = os.Getenv("LINE_CLIENT_SECRET")
+//Command line-botサブコマンド
+var Command = &command.Command{
+	Name:       "line-bot",
+	Exe:        "line",
+	Summary:    "LINE bot（環境変数で設定する）",
+	Daemon:     true,
+	Config:     &conf,
+	InitLogger: initLogger,
+	Run:        serve,
+}
+
+//initLogger 環境変数LOG_LEVELとLOG_FORMATでロガーを初期化する
+//ファイルには書かず標準エラー出力のみ（run-allではapp.iniの[LOG]を使う）
+func initLogger() error {
+	var logConf logger.Config
+	//Validateで確認済み
+	logConf.Level, _ = logger.ParseLevel(conf.Line.LogLevel)
+	logConf.JSON, _ = logger.ParseFormat(conf.Line.LogFormat)
+	return logger.InitLogger("", logConf)
+}
+
+//setup LINEとBikeshareのAPIクライアントを初期化してキャッシュを読み込む
+func setup() error {
+	ClientID = conf.Line.ClientID
+	ClientSecret = conf.Line.ClientSecret
 	AccessToken = getAccessToken()
-	if bot, err := linebot.New(ClientSecret, AccessToken, linebot.WithHTTPClient(&Client)); err == nil {
-		LineBotAPI = bot
-	} else {
-		panic(err)
+	bot, err := linebot.New(ClientSecret, AccessToken, linebot.WithHTTPClient(&Client))
+	if err != nil {
+		return err
 	}
-	BikeshareAPI = bikeshareapi.NewApiClient()
-	BikeshareAPI.SetCertKey(os.Getenv("API_CERT"))
-	if os.Getenv("MODE") == "DEBUG" {
-		//デバッグ用
-		BikeshareAPI.SetEndpoint("http://localhost:5001/")
-	} else if os.Getenv("MODE") == "LOCAL" {
-		//APIサーバと同じサーバにあるとき
-		BikeshareAPI.SetEndpoint("http://apiserver:5001/")
+	LineBotAPI = bot
+	BikeshareAPI = &BikeshareClient{
+		Endpoint:      conf.Line.Endpoint(),
+		GraphEndpoint: conf.Line.GraphURL(),
+		Cert:          conf.Line.APICert,
+		Client:        &Client,
 	}
 
 	//ユーザー設定を取得
-	if err := CacheUsrConfigs(); err != nil {
-		panic(err)
+	ctx := context.Background()
+	if err := CacheUsrConfigs(ctx); err != nil {
+		return err
 	}
 	//スポット名の辞書を初期化
-	places, err := BikeshareAPI.GetAllSpotNames()
-	if err != nil {
-		panic(err)
-	}
-	for _, place := range places {
-		SpotNamesDictionary[place.Area+"-"+place.Spot] = place.Name
-	}
+	return CacheSpotNames(ctx)
 }
 
-func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "5050"
+//serve 初期化してサーバを開始する（ctxがキャンセルされたら処理中のリクエストをSHUTDOWN_TIMEOUTまで待って終了する）
+func serve(ctx context.Context, args []string) error {
+	if err := setup(); err != nil {
+		return err
 	}
 
-	http.HandleFunc("/callback", CallbackHandler)
-	http.HandleFunc("/notify", NotifyHandler)
-
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatal(err)
+	//run-allでは他のサーバと同じプロセスなのでDefaultServeMuxは使わない
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", CallbackHandler)
+	mux.HandleFunc("/notify", NotifyHandler)
+	mux.HandleFunc("/reload", ReloadHandler)
+	//METRICS_ADDRがあれば別のポート、なければ同じポートで/metricsを公開する
+	if addr := conf.Line.MetricsAddr; addr != "" {
+		if err := metrics.Serve(addr); err != nil {
+			return err
+		}
+	} else {
+		mux.Handle("/metrics", metrics.Handler())
 	}
+	//SIGHUPとRELOAD_INTERVAL毎にキャッシュを読み込み直す
+	reloader.Watch(ctx, time.Duration(conf.Line.ReloadInterval)*time.Minute)
+
+	server := shutdown.NewServer(conf.Line.Port, mux)
+	return shutdown.Serve(ctx, server, time.Duration(conf.Line.ShutdownTimeout)*time.Second)
 }
@@ -1,9 +1,9 @@
-package main
+package line
 
 import (
 	"fmt"
 
-	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
 	"github.com/line/line-bot-sdk-go/linebot"
 )
 
@@ -27,7 +27,7 @@ type TemplateMessageParameter struct {
 }
 
 //getLastUpdateTime 「最終更新日時：yyyy/mm/dd hh:mi」の文字列を生成
-func getLastUpdateTime(spotinfos ...bikeshareapi.SpotInfo) (lastUpdateTime string) {
+func getLastUpdateTime(spotinfos ...SpotInfo) (lastUpdateTime string) {
 	lastUpdateTime = "最終更新日時不明"
 	if len(spotinfos) > 0 {
 		if len(spotinfos[0].Counts) > 0 {
@@ -38,7 +38,7 @@ func getLastUpdateTime(spotinfos ...bikeshareapi.SpotInfo) (lastUpdateTime strin
 }
 
 //CreateSpotListBubbleContainer 台数一覧のテンプレート作成
-func CreateSpotListBubbleContainer(title, altText string, spotinfos []bikeshareapi.SpotInfo) linebot.BubbleContainer {
+func CreateSpotListBubbleContainer(title, altText string, spotinfos []SpotInfo) linebot.BubbleContainer {
 	//最終更新日時
 	lastUpdateTime := getLastUpdateTime(spotinfos...)
 	//ヘッダ
@@ -179,7 +179,7 @@ func CreateListInnerBoxHalf(listitem, buttonColor, buttonCaption, postbackText,
 }
 
 //CreateSpotListCarouselContainer 件数が多いとき用のテンプレート
-func CreateSpotListCarouselContainer(title, altText string, spotinfos []bikeshareapi.SpotInfo) linebot.CarouselContainer {
+func CreateSpotListCarouselContainer(title, altText string, spotinfos []SpotInfo) linebot.CarouselContainer {
 	contents := CreateSpotListBubbleContainer(title, altText, spotinfos)
 	container := linebot.CarouselContainer{
 		Type:     linebot.FlexContainerTypeCarousel,
@@ -344,7 +344,7 @@ func CreateCommandListBubbleContainer(title string, commands []CommandListItem)
 }
 
 //CreateConfigBubbleContainer 設定画面作成
-func CreateConfigBubbleContainer(user *bikeshareapi.Users) linebot.BubbleContainer {
+func CreateConfigBubbleContainer(user *static.JUser) linebot.BubbleContainer {
 	//ボディ
 	body := linebot.BoxComponent{
 		Type:   linebot.FlexComponentTypeBox,
@@ -1,9 +1,11 @@
-package main
+package line
 
 import (
+	"context"
 	"sync"
 
-	bikeshareapi "github.com/8245snake/bikeshare-client"
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
 )
 
 //UserUpdateType ユーザー情報更新タイプ
@@ -26,22 +28,37 @@ const (
 	UserUpdateTypeNotifyDelete UserUpdateType = "d_notify"
 )
 
-//UserConfigs ユーザー設定
-var UserConfigs []bikeshareapi.Users
+var (
+	//UserConfigs ユーザー設定（読み込み直すときは丸ごと入れ替える）
+	UserConfigs []static.JUser
+	//usersMu UserConfigsの排他
+	usersMu sync.RWMutex
+	//updateMu ユーザー情報の更新を1つずつ行う
+	updateMu sync.Mutex
+)
 
-//CacheUsrConfigs ユーザー設定を変数に格納
-func CacheUsrConfigs() error {
+//CacheUsrConfigs ユーザー設定を変数に格納（失敗したら前のまま）
+func CacheUsrConfigs(ctx context.Context) error {
 	//ユーザ情報をキャッシュ
-	if user, err := BikeshareAPI.GetUsers(); err == nil {
-		UserConfigs = user
+	if user, err := BikeshareAPI.GetUsers(ctx); err == nil {
+		setUserConfigs(user)
 	} else {
 		return err
 	}
 	return nil
 }
 
+//setUserConfigs キャッシュを入れ替える
+func setUserConfigs(users []static.JUser) {
+	usersMu.Lock()
+	defer usersMu.Unlock()
+	UserConfigs = users
+}
+
 //GetUserConfigFromCache キャッシュから設定を取得（nilが返る可能性がある）
-func GetUserConfigFromCache(userID string) *bikeshareapi.Users {
+func GetUserConfigFromCache(userID string) *static.JUser {
+	usersMu.RLock()
+	defer usersMu.RUnlock()
 	for _, user := range UserConfigs {
 		if user.LineID == userID {
 			return &user
@@ -51,15 +68,14 @@ func GetUserConfigFromCache(userID string) *bikeshareapi.Users {
 }
 
 //UpdateUserConfig ユーザー情報を更新
-func UpdateUserConfig(updateType UserUpdateType, UsaerID string, value string) {
+func UpdateUserConfig(ctx context.Context, updateType UserUpdateType, UsaerID string, value string) {
 	//排他制御する
-	var mu sync.Mutex
-	mu.Lock()
-	defer mu.Unlock()
+	updateMu.Lock()
+	defer updateMu.Unlock()
 	//ユーザー設定を取得
 	user := GetUserConfigFromCache(UsaerID)
 	if user == nil {
-		user = &bikeshareapi.Users{LineID: UsaerID}
+		user = &static.JUser{LineID: UsaerID}
 	}
 	switch updateType {
 	case UserUpdateTypeUserAdd:
@@ -78,9 +94,12 @@ func UpdateUserConfig(updateType UserUpdateType, UsaerID string, value string) {
 		user.Favorites = RemoveList(user.Favorites, value)
 	}
 	//送信したらレスポンスのデータで内部変数を更新
-	if users, err := BikeshareAPI.UpdateUser(*user); err == nil {
-		UserConfigs = users
+	users, err := BikeshareAPI.UpdateUser(ctx, *user)
+	if err != nil {
+		logger.FromContext(ctx).Errorf("UpdateUserConfig UpdateUserでエラー : %v", err)
+		return
 	}
+	setUserConfigs(users)
 }
 
 //AddList 検索履歴を先頭に追加したスライスを返す
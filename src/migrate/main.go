@@ -0,0 +1,138 @@
+package migrate
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+//  概要：DBのスキーマを作成・更新する
+//
+//　機能：1. liveのDB（Postgres または SQLite）へのマイグレーション適用・取り消し
+//　　　　2. 日毎のSQLite（data/yyyy-mm-dd.db）へのマイグレーション適用・取り消し
+//
+//　使い方：bikeshare migrate [-set live|archive|all] [--print-config] [up|down|status] [バージョン]
+//　　　　　up     指定バージョンまで適用（省略時は最新まで）
+//　　　　　down   指定バージョンまで取り消し（省略時は1つ前まで）
+//　　　　　status 適用済みのバージョンを表示
+/////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/8245snake/bikeshare_api/src/lib/command"
+	"github.com/8245snake/bikeshare_api/src/lib/config"
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/8245snake/bikeshare_api/src/lib/static"
+)
+
+//Config migrateの設定
+type Config struct {
+	DB  config.DB  `section:"DB"`
+	Log config.Log `section:"LOG"`
+}
+
+var (
+	//conf 起動時に読み込んだ設定
+	conf Config
+	//set -setで指定した対象
+	set *string
+)
+
+//Command migrateサブコマンド
+var Command = &command.Command{
+	Name:    "migrate",
+	Exe:     "migrate",
+	Summary: "DBのスキーマを作成・更新する",
+	Usage:   "[-set live|archive|all] [up|down|status] [バージョン]",
+	Config:  &conf,
+	Flags: func(fs *flag.FlagSet) {
+		set = fs.String("set", "all", "対象（live, archive, all）")
+	},
+	Run: run,
+}
+
+//runMigrator 1つのDBに対してコマンドを実行する
+func runMigrator(name string, migrator *rdb.Migrator, command string, version int, hasVersion bool) error {
+	defer migrator.Close()
+	current, err := migrator.Version()
+	if err != nil {
+		return fmt.Errorf("%s バージョンの取得に失敗しました : %v", name, err)
+	}
+	var done []rdb.Migration
+	switch command {
+	case "status":
+		fmt.Printf("%s version=%d latest=%d\n", name, current, migrator.Latest())
+		return nil
+	case "up":
+		done, err = migrator.Up(version)
+	case "down":
+		if !hasVersion {
+			version = current - 1
+		}
+		if version < 0 {
+			version = 0
+		}
+		done, err = migrator.Down(version)
+	default:
+		return fmt.Errorf("コマンド%sには対応していません", command)
+	}
+	for _, migration := range done {
+		logger.Infof("%s %s %d_%s", name, command, migration.Version, migration.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %s : %v", name, command, err)
+	}
+	after, _ := migrator.Version()
+	fmt.Printf("%s version=%d→%d\n", name, current, after)
+	return nil
+}
+
+//run 引数のコマンド（省略時はup）を-setの対象のDBに実行する
+func run(ctx context.Context, args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	version, hasVersion := 0, false
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("バージョンには数値を指定してください : %s", args[1])
+		}
+		version, hasVersion = v, true
+	}
+
+	failed := false
+	//live
+	if *set == "all" || *set == string(rdb.MigrationSetLive) {
+		migrator, err := rdb.OpenMigrator()
+		if err != nil {
+			logger.Errorf("live 接続に失敗しました : %v", err)
+			failed = true
+		} else if err := runMigrator("live", migrator, command, version, hasVersion); err != nil {
+			logger.Errorf("%v", err)
+			failed = true
+		}
+	}
+	//archive
+	if *set == "all" || *set == string(rdb.MigrationSetArchive) {
+		files, _ := filepath.Glob(filepath.Join(static.DirData, "????-??-??.db"))
+		for _, path := range files {
+			migrator, err := rdb.OpenArchiveMigrator(path)
+			if err != nil {
+				logger.Errorf("%s 接続に失敗しました : %v", filepath.Base(path), err)
+				failed = true
+				continue
+			}
+			if err := runMigrator(filepath.Base(path), migrator, command, version, hasVersion); err != nil {
+				logger.Errorf("%v", err)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		return fmt.Errorf("マイグレーションに失敗したDBがあります")
+	}
+	return nil
+}
@@ -1,52 +1,101 @@
-package main
+package notify
 
 import (
-	"fmt"
+	"context"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/8245snake/bikeshare_api/src/lib/filer"
+	"github.com/8245snake/bikeshare_api/src/lib/command"
+	"github.com/8245snake/bikeshare_api/src/lib/config"
+	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/metrics"
 	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/8245snake/bikeshare_api/src/lib/shutdown"
 )
 
+//Config notifyの設定
+type Config struct {
+	DB     config.DB     `section:"DB"`
+	Log    config.Log    `section:"LOG"`
+	Notify config.Notify `section:"NOTIFY"`
+}
+
 var (
+	//conf 起動時に読み込んだ設定
+	conf Config
+
 	//client HTTPクライアント
 	client *http.Client = &http.Client{}
 
 	endpoint string
+
+	//notifyRequests 通知リクエストの送信数（resultはokまたはerror）
+	notifyRequests = metrics.NewCounter("bikeshare_notify_requests_total", "通知リクエストの送信数", "result")
+	//notifyLastLoop 最後にユーザーを確認した日時
+	notifyLastLoop = metrics.NewGauge("bikeshare_notify_last_loop_timestamp_seconds", "最後にユーザーの通知時刻を確認した日時（UNIX時間）")
 )
 
-//SendRequest リクエスト送信
+//SendRequest リクエスト送信（LINE botのログと突き合わせられるようにリクエストIDを付ける）
 func SendRequest(userID string) {
+	requestID := logger.NewRequestID()
+	log := logger.WithRequestID(requestID)
 	URL := strings.Replace(endpoint, "${USER}", userID, -1)
-	client.Get(URL)
-}
-
-func init() {
-	//共通初期化処理
-	err := filer.InitDirSetting()
+	req, err := http.NewRequest("GET", URL, nil)
 	if err != nil {
+		log.Errorf("通知リクエストを作成できません : %v", err)
+		notifyRequests.Inc("error")
 		return
 	}
-	endpoint = filer.GetIniData("NOTIFY", "REQUEST", "")
-	if endpoint == "" {
-		panic("通知リクエストURLが設定されていません")
+	req.Header.Set(logger.RequestIDHeader, requestID)
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Errorf("通知リクエストに失敗しました : %v", err)
+		notifyRequests.Inc("error")
+		return
 	}
-	fmt.Printf("endpoint=%s\n", endpoint)
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		log.Errorf("通知リクエストに失敗しました : %s", resp.Status)
+		notifyRequests.Inc("error")
+		return
+	}
+	log.Debugf("通知リクエストを送信しました")
+	notifyRequests.Inc("ok")
+}
+
+//Command notifyサブコマンド
+var Command = &command.Command{
+	Name:    "notify",
+	Exe:     "notify",
+	Summary: "ユーザーが登録した時刻にLINE botへ通知をリクエストする",
+	Daemon:  true,
+	Config:  &conf,
+	Run:     run,
 }
 
-func main() {
-	db, err := rdb.GetConnectionPsql()
+//run 1分毎にユーザーの通知時刻を確認する（ctxがキャンセルされたら次の確認をせずに終了する）
+func run(ctx context.Context, args []string) error {
+	endpoint = conf.Notify.Request
+	logger.Infof("endpoint=%s", endpoint)
+	if err := metrics.Serve(conf.Notify.MetricsAddr); err != nil {
+		return err
+	}
+	store, err := rdb.OpenStore()
 	if err != nil {
-		fmt.Printf("%v\n", err)
-		panic(err)
+		return err
 	}
+	defer store.Close()
 	for {
-		users, err := rdb.GetAllUsers(db)
+		users, err := store.GetAllUsers()
 		if err != nil {
+			logger.Errorf("GetAllUsersでエラー : %v", err)
+			if !shutdown.Sleep(ctx, 60*time.Second) {
+				return nil
+			}
 			continue
 		}
+		notifyLastLoop.SetToCurrentTime()
 		now := time.Now().Format("15:04")
 		for _, user := range users {
 			for _, notify := range user.Notifies {
@@ -55,6 +104,8 @@ func main() {
 				}
 			}
 		}
-		time.Sleep(60 * time.Second)
+		if !shutdown.Sleep(ctx, 60*time.Second) {
+			return nil
+		}
 	}
 }
@@ -1,4 +1,4 @@
-package main
+package stationfiller
 
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 //  概要：スポットマスタの駅名を補完するタスク
@@ -6,27 +6,51 @@ package main
 //　機能：1. 駅名補完
 //　　　　2. 説明補完
 //
+//　SIGTERMを受けたら処理中のスポットを終えてから終了する（残りは次回補完する）
+//
 /////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"runtime"
 	"time"
 
-	"github.com/8245snake/bikeshare_api/src/lib/filer"
+	"github.com/8245snake/bikeshare_api/src/lib/command"
+	"github.com/8245snake/bikeshare_api/src/lib/config"
 	"github.com/8245snake/bikeshare_api/src/lib/logger"
+	"github.com/8245snake/bikeshare_api/src/lib/metrics"
 	"github.com/8245snake/bikeshare_api/src/lib/rdb"
+	"github.com/8245snake/bikeshare_api/src/lib/shutdown"
 	"github.com/carlescere/scheduler"
-
-	_ "github.com/lib/pq"
 )
 
-var ini_section = "STATION"
+//Config stationfillerの設定
+type Config struct {
+	DB      config.DB      `section:"DB"`
+	Log     config.Log     `section:"LOG"`
+	Station config.Station `section:"STATION"`
+}
+
+//conf 起動時に読み込んだ設定
+var conf Config
+
+//jobs 実行中の補完（終了するときに待つ）
+var jobs shutdown.Jobs
+
+//メトリクス
+var (
+	//fillDuration 補完1回の処理時間
+	fillDuration = metrics.NewHistogram("bikeshare_stationfill_duration_seconds", "駅名補完1回の処理時間（秒）",
+		[]float64{10, 60, 300, 600, 1800, 3600})
+	//fillSpots 処理したスポット数（resultはfilledまたはerror）
+	fillSpots = metrics.NewCounter("bikeshare_stationfill_spots_total", "駅名補完で処理したスポット数", "result")
+	//fillLastRun 最後に補完を実行した日時
+	fillLastRun = metrics.NewGauge("bikeshare_stationfill_last_run_timestamp_seconds", "最後に駅名補完を実行した日時（UNIX時間）")
+)
 
 //Station 駅検索APIの駅データ
 type Station struct {
@@ -81,38 +105,46 @@ func (r Response) GetStations() (result string, err error) {
 	return
 }
 
-//FillStationName 駅名補完
-func FillStationName(db *sql.DB) {
-	opt := rdb.SearchOptions{AddWhere: "endtime is null and (trim(description) = '' or description is null) "}
-	rows, err := rdb.SearchSpotmaster(db, opt)
+//FillStationName 駅名補完（ctxがキャンセルされたら次のスポットに進まずに終わる）
+func FillStationName(ctx context.Context, store rdb.Store) {
+	opt := rdb.SearchOptions{}.Where(rdb.IsNull(rdb.ColumnEndtime), rdb.Empty(rdb.ColumnDescription))
+	rows, err := store.SearchSpotmaster(opt)
 	if err != nil {
-		logger.Debugf("FillStationName SearchSpotmasterでエラー : %v", err)
+		logger.Errorf("FillStationName SearchSpotmasterでエラー : %v", err)
 		return
 	}
 	logger.Infof("FillStationName %d件処理します", len(rows))
 	var station Heartrails
 	for _, row := range rows {
-		time.Sleep(1 * time.Second)
+		if !shutdown.Sleep(ctx, 1*time.Second) {
+			logger.Infof("FillStationName 終了するため残りは次回補完します")
+			return
+		}
 		station, err = requestStationInfo(row.Lon, row.Lat)
 		if err != nil {
-			logger.Debugf("FillStationName requestStationInfoでエラー(area=%s, spot=%s) : %v", row.Area, row.Spot, err)
+			logger.Errorf("FillStationName requestStationInfoでエラー(area=%s, spot=%s) : %v", row.Area, row.Spot, err)
+			fillSpots.Inc("error")
 			continue
 		}
 		row.Description, err = station.Response.GetDescriptions()
 		if err != nil {
-			logger.Debugf("FillStationName GetDescriptionsでエラー(area=%s, spot=%s) : %v", row.Area, row.Spot, err)
+			logger.Errorf("FillStationName GetDescriptionsでエラー(area=%s, spot=%s) : %v", row.Area, row.Spot, err)
+			fillSpots.Inc("error")
 			continue
 		}
 		row.Station, err = station.Response.GetStations()
 		if err != nil {
-			logger.Debugf("FillStationName GetStationsでエラー(area=%s, spot=%s) : %v", row.Area, row.Spot, err)
+			logger.Errorf("FillStationName GetStationsでエラー(area=%s, spot=%s) : %v", row.Area, row.Spot, err)
+			fillSpots.Inc("error")
 			continue
 		}
-		err = rdb.UpsertSpotmaster(db, row)
+		err = store.UpsertSpotmaster(row)
 		if err != nil {
-			logger.Debugf("FillStationName UpsertSpotmasterでエラー(area=%s, spot=%s) : %v", row.Area, row.Spot, err)
+			logger.Errorf("FillStationName UpsertSpotmasterでエラー(area=%s, spot=%s) : %v", row.Area, row.Spot, err)
+			fillSpots.Inc("error")
 			continue
 		}
+		fillSpots.Inc("filled")
 	}
 }
 
@@ -146,35 +178,48 @@ func requestStationInfo(lon string, lat string) (Heartrails, error) {
 }
 
 //RunFiler 駅名補完メイン関数
-func RunFiler() {
+func RunFiler(ctx context.Context) {
 	logger.Debugf("RunFiler_start")
-	db, err := rdb.GetConnectionPsql()
+	defer fillDuration.ObserveSince(time.Now())
+	defer fillLastRun.SetToCurrentTime()
+	store, err := rdb.OpenStore()
 	if err != nil {
-		logger.Debugf("GetConnectionPsqlでエラー : %v", err)
+		logger.Errorf("OpenStoreでエラー : %v", err)
 		return
 	}
-	defer db.Close()
+	defer store.Close()
 
 	//補完処理実行
-	FillStationName(db)
+	FillStationName(ctx, store)
 	logger.Debugf("RunFiler_end")
 }
 
-func main() {
-	//初期化
-	err := filer.InitDirSetting()
-	if err != nil {
-		fmt.Printf("InitDirSettingでエラー : %v", err)
-		return
+//Command fill-stationsサブコマンド
+var Command = &command.Command{
+	Name:    "fill-stations",
+	Exe:     "stationfiller",
+	Summary: "スポットマスタの最寄り駅を毎日補完する",
+	Daemon:  true,
+	Config:  &conf,
+	Run:     run,
+}
+
+//run 定期実行を登録し、ctxがキャンセルされたら実行中の補完を待って終了する
+func run(ctx context.Context, args []string) error {
+	//メトリクス
+	if err := metrics.Serve(conf.Station.MetricsAddr); err != nil {
+		return err
 	}
-	exeName := filer.GetExeName()
-	logger.Info(exeName, "開始")
 
 	//開始
-	scheduledTime := filer.GetIniData(ini_section, "START", "00:00")
-	_, _ = scheduler.Every().Day().At(scheduledTime).Run(RunFiler)
+	scheduledTime := conf.Station.Start
+	_, _ = scheduler.Every().Day().At(scheduledTime).Run(func() {
+		jobs.Run(func() { RunFiler(ctx) })
+	})
 	logger.Infof("%sに実行します", scheduledTime)
 
-	//終了させない
-	runtime.Goexit()
+	//SIGTERMかSIGINTを受けたら実行中の補完を待って終了する
+	<-ctx.Done()
+	jobs.Wait()
+	return nil
 }